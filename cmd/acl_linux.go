@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"syscall"
+)
+
+// tags of the posix_acl xattr entries, see acl_ea.h
+const (
+	aclXattrVersion = 2
+	aclUserObj      = 0x01
+	aclUser         = 0x02
+	aclGroupObj     = 0x04
+	aclGroup        = 0x08
+	aclMask         = 0x10
+	aclOther        = 0x20
+)
+
+func fileOwner(info fs.FileInfo) (int, int, error) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, fmt.Errorf("no owner information for %s", info.Name())
+	}
+	return int(stat.Uid), int(stat.Gid), nil
+}
+
+// readDefaultACL returns the default ACL of a directory in setfacl's short form, e.g. u::rwx,g::rwx,o::r-x.
+// An empty string means the directory has no default ACL.
+func readDefaultACL(path string) (string, error) {
+	size, err := syscall.Getxattr(path, "system.posix_acl_default", nil)
+	if errors.Is(err, syscall.ENODATA) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read default ACL of %s: %w", path, err)
+	}
+	buf := make([]byte, size)
+	if size, err = syscall.Getxattr(path, "system.posix_acl_default", buf); err != nil {
+		return "", fmt.Errorf("failed to read default ACL of %s: %w", path, err)
+	}
+	return formatACL(buf[:size])
+}
+
+func formatACL(xattr []byte) (string, error) {
+	if len(xattr) < 4 || (len(xattr)-4)%8 != 0 || binary.LittleEndian.Uint32(xattr) != aclXattrVersion {
+		return "", fmt.Errorf("malformed ACL of %d bytes", len(xattr))
+	}
+	entries := make([]string, 0, (len(xattr)-4)/8)
+	for entry := xattr[4:]; len(entry) > 0; entry = entry[8:] {
+		tag := binary.LittleEndian.Uint16(entry)
+		perm := binary.LittleEndian.Uint16(entry[2:])
+		id := binary.LittleEndian.Uint32(entry[4:])
+		var qualifier string
+		switch tag {
+		case aclUserObj:
+			qualifier = "u:"
+		case aclUser:
+			qualifier = fmt.Sprintf("u:%d", id)
+		case aclGroupObj:
+			qualifier = "g:"
+		case aclGroup:
+			qualifier = fmt.Sprintf("g:%d", id)
+		case aclMask:
+			qualifier = "m:"
+		case aclOther:
+			qualifier = "o:"
+		default:
+			return "", fmt.Errorf("unknown ACL tag %#x", tag)
+		}
+		entries = append(entries, qualifier+":"+aclPerm(perm))
+	}
+	return strings.Join(entries, ","), nil
+}
+
+func aclPerm(perm uint16) string {
+	b := []byte("---")
+	if perm&4 != 0 {
+		b[0] = 'r'
+	}
+	if perm&2 != 0 {
+		b[1] = 'w'
+	}
+	if perm&1 != 0 {
+		b[2] = 'x'
+	}
+	return string(b)
+}
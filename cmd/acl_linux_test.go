@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// aclXattr encodes entries of tag, perm and id the way the kernel stores system.posix_acl_default
+func aclXattr(version uint32, entries ...[3]uint32) []byte {
+	buf := binary.LittleEndian.AppendUint32(nil, version)
+	for _, e := range entries {
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(e[0]))
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(e[1]))
+		buf = binary.LittleEndian.AppendUint32(buf, e[2])
+	}
+	return buf
+}
+
+func TestFormatACL(t *testing.T) {
+	// the kernel stores 0xffffffff as the id of entries without a qualifier
+	const noID = 0xffffffff
+	tests := []struct {
+		name    string
+		xattr   []byte
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "minimal",
+			xattr: aclXattr(aclXattrVersion, [3]uint32{aclUserObj, 7, noID}, [3]uint32{aclGroupObj, 7, noID}, [3]uint32{aclOther, 5, noID}),
+			want:  "u::rwx,g::rwx,o::r-x",
+		},
+		{
+			name: "named entries and mask",
+			xattr: aclXattr(aclXattrVersion,
+				[3]uint32{aclUserObj, 6, noID},
+				[3]uint32{aclUser, 4, 1001},
+				[3]uint32{aclGroupObj, 0, noID},
+				[3]uint32{aclGroup, 3, 1002},
+				[3]uint32{aclMask, 7, noID},
+				[3]uint32{aclOther, 0, noID},
+			),
+			want: "u::rw-,u:1001:r--,g::---,g:1002:-wx,m::rwx,o::---",
+		},
+		{
+			name:    "wrong version",
+			xattr:   aclXattr(1, [3]uint32{aclUserObj, 7, noID}),
+			wantErr: true,
+		},
+		{
+			name:    "truncated entry",
+			xattr:   aclXattr(aclXattrVersion, [3]uint32{aclUserObj, 7, noID})[:10],
+			wantErr: true,
+		},
+		{
+			name:    "too short",
+			xattr:   []byte{2, 0},
+			wantErr: true,
+		},
+		{
+			name:    "unknown tag",
+			xattr:   aclXattr(aclXattrVersion, [3]uint32{0x40, 7, noID}),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := formatACL(tt.xattr)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("formatACL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStaticDriftModes(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "docs")
+	file := filepath.Join(sub, "a.pdf")
+	if err := os.Mkdir(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		path string
+		mode os.FileMode
+		root bool
+		want []string
+	}{
+		{name: "root with setgid", path: root, mode: staticRootMode, root: true},
+		{name: "root without setgid", path: root, mode: 0o775, root: true, want: []string{"setgid is unset, want set"}},
+		{name: "subdirectory without setgid", path: sub, mode: staticDirMode},
+		{name: "subdirectory with setgid", path: sub, mode: os.ModeSetgid | 0o775, want: []string{"setgid is set, want unset"}},
+		{name: "subdirectory not group writable", path: sub, mode: 0o755, want: []string{"mode is 0755, want 0775"}},
+		{name: "file", path: file, mode: staticFileMode},
+		{name: "file not group writable", path: file, mode: 0o644, want: []string{"mode is 0644, want 0664"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.Chmod(tt.path, tt.mode); err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			drift, err := staticDrift(tt.path, info, os.Getgid(), tt.root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// owner and default ACL of a temporary directory depend on who runs the test
+			var got []string
+			for _, d := range drift {
+				if strings.HasPrefix(d, "mode") || strings.HasPrefix(d, "setgid") {
+					got = append(got, d)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("staticDrift() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package cmd
+
+import (
+	"errors"
+	"io/fs"
+)
+
+var errACLUnsupported = errors.New("ownership and ACLs of the static path can only be checked on linux")
+
+func fileOwner(info fs.FileInfo) (int, int, error) {
+	return 0, 0, errACLUnsupported
+}
+
+func readDefaultACL(path string) (string, error) {
+	return "", errACLUnsupported
+}
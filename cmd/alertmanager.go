@@ -0,0 +1,332 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/caner-cetin/oblivion/internal"
+	"github.com/caner-cetin/oblivion/internal/config"
+	"github.com/fatih/color"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// resolves to the docker host from inside the alertmanager container
+	alertTestHost     = "host.docker.internal"
+	alertTestReceiver = "oblivion-test"
+	alertTestLabel    = "oblivion_test"
+	// test alerts carrying this label also reach the real receiver, set by observer alerts test --notify
+	alertTestNotifyLabel = "oblivion_test_notify"
+	// alertmanager needs at least one receiver, alerts routed here are dropped
+	alertBlackholeReceiver = "blackhole"
+)
+
+var (
+	observerAlertsTestCmd = &cobra.Command{
+		Use:   "test",
+		Short: "fire a synthetic alert through alertmanager and wait for it to be delivered",
+		Run:   WrapCommandWithResources(observerAlertsTest, ResourceConfig{}),
+	}
+	observerAlertsCmd = &cobra.Command{
+		Use: "alerts",
+	}
+	alertsTestTimeout time.Duration
+	alertsTestNotify  bool
+)
+
+func getObserverAlertsCmd() *cobra.Command {
+	observerAlertsTestCmd.Flags().DurationVar(&alertsTestTimeout, "timeout", 2*time.Minute, "how long to wait for the stand-in webhook to receive the alert")
+	observerAlertsTestCmd.Flags().BoolVar(&alertsTestNotify, "notify", false, "also deliver the test alert to the real default receiver")
+	observerAlertsCmd.AddCommand(observerAlertsTestCmd)
+	return observerAlertsCmd
+}
+
+type alertmanagerConfig struct {
+	Route     alertmanagerRoute      `yaml:"route"`
+	Receivers []alertmanagerReceiver `yaml:"receivers"`
+}
+
+type alertmanagerRoute struct {
+	Receiver       string              `yaml:"receiver"`
+	GroupBy        []string            `yaml:"group_by,omitempty"`
+	GroupWait      string              `yaml:"group_wait,omitempty"`
+	GroupInterval  string              `yaml:"group_interval,omitempty"`
+	RepeatInterval string              `yaml:"repeat_interval,omitempty"`
+	Matchers       []string            `yaml:"matchers,omitempty"`
+	Continue       bool                `yaml:"continue,omitempty"`
+	Routes         []alertmanagerRoute `yaml:"routes,omitempty"`
+}
+
+type alertmanagerReceiver struct {
+	Name           string                `yaml:"name"`
+	DiscordConfigs []alertmanagerDiscord `yaml:"discord_configs,omitempty"`
+	SlackConfigs   []alertmanagerSlack   `yaml:"slack_configs,omitempty"`
+	WebhookConfigs []alertmanagerWebhook `yaml:"webhook_configs,omitempty"`
+	EmailConfigs   []alertmanagerEmail   `yaml:"email_configs,omitempty"`
+}
+
+type alertmanagerDiscord struct {
+	WebhookURL   string `yaml:"webhook_url"`
+	SendResolved bool   `yaml:"send_resolved"`
+}
+
+type alertmanagerSlack struct {
+	APIURL       string `yaml:"api_url"`
+	Channel      string `yaml:"channel,omitempty"`
+	SendResolved bool   `yaml:"send_resolved"`
+}
+
+type alertmanagerWebhook struct {
+	URL          string `yaml:"url"`
+	SendResolved bool   `yaml:"send_resolved"`
+}
+
+type alertmanagerEmail struct {
+	To           string `yaml:"to"`
+	From         string `yaml:"from"`
+	Smarthost    string `yaml:"smarthost"`
+	AuthUsername string `yaml:"auth_username,omitempty"`
+	AuthPassword string `yaml:"auth_password,omitempty"`
+	SendResolved bool   `yaml:"send_resolved"`
+}
+
+// alert as posted to and delivered by alertmanager, only the fields oblivion uses
+type alertmanagerAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	StartsAt    time.Time         `json:"startsAt,omitzero"`
+	EndsAt      time.Time         `json:"endsAt,omitzero"`
+}
+
+func alertmanagerURL() string {
+	return "http://" + net.JoinHostPort("localhost", cfg.Observer.Ports.Alertmanager)
+}
+
+func alertTestWebhookURL() string {
+	return "http://" + net.JoinHostPort(alertTestHost, cfg.Observer.Alerting.TestWebhookPort) + "/"
+}
+
+// renderAlertmanagerConfig generates alertmanager's config.yml from [Observer.Alerting], resolving receiver secrets.
+func (a *AppCtx) renderAlertmanagerConfig() ([]byte, error) {
+	alerting := cfg.Observer.Alerting
+	var refs []string
+	for _, r := range alerting.Receivers {
+		for _, ref := range []string{r.URLRef, r.UsernameRef, r.PasswordRef} {
+			if ref != "" {
+				refs = append(refs, ref)
+			}
+		}
+	}
+	secret := func(string) string { return "" }
+	if len(refs) > 0 {
+		prefixedKeys, secrets, err := a.resolveSecrets(refs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve alert receiver secrets: %w", internal.RedactError(err))
+		}
+		secret = func(ref string) string {
+			if ref == "" {
+				return ""
+			}
+			return secrets[prefixedKeys[ref]].Content.Secret
+		}
+	}
+	out, err := internal.MarshalYAML(alertmanagerConfigFor(alerting, secret))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal alertmanager config: %w", err)
+	}
+	return append([]byte("# generated by oblivion from [Observer.Alerting] config, edits are overwritten on observer up\n"), out...), nil
+}
+
+// alertmanagerConfigFor builds the config from [Observer.Alerting], secret maps a reference to its resolved value
+func alertmanagerConfigFor(alerting config.AlertingConfig, secret func(ref string) string) *alertmanagerConfig {
+	receivers := make([]alertmanagerReceiver, 0, len(alerting.Receivers)+2)
+	for _, r := range alerting.Receivers {
+		receivers = append(receivers, alertReceiver(r, secret))
+	}
+	defaultReceiver := alerting.DefaultReceiver
+	if defaultReceiver == "" && len(alerting.Receivers) > 0 {
+		defaultReceiver = alerting.Receivers[0].Name
+	}
+	if defaultReceiver == "" {
+		log.Warn().Msg("no [[Observer.Alerting.Receivers]] configured, alerts will not be delivered anywhere")
+		defaultReceiver = alertBlackholeReceiver
+		receivers = append(receivers, alertmanagerReceiver{Name: alertBlackholeReceiver})
+	}
+	receivers = append(receivers, alertmanagerReceiver{
+		Name:           alertTestReceiver,
+		WebhookConfigs: []alertmanagerWebhook{{URL: alertTestWebhookURL()}},
+	})
+
+	testMatcher := []string{alertTestLabel + `=~".+"`}
+	return &alertmanagerConfig{
+		Route: alertmanagerRoute{
+			Receiver:       defaultReceiver,
+			GroupBy:        alerting.GroupBy,
+			GroupWait:      alerting.GroupWait,
+			GroupInterval:  alerting.GroupInterval,
+			RepeatInterval: alerting.RepeatInterval,
+			// test alerts skip grouping delays and only reach the stand-in, unless they opt in to the real receiver.
+			// A test alert matching neither child route would fall back to the default receiver, so the first one
+			// catches all of them and continues to the second only for the opt-in.
+			Routes: []alertmanagerRoute{
+				{Receiver: alertTestReceiver, Matchers: testMatcher, GroupBy: []string{alertTestLabel}, GroupWait: "0s", Continue: true},
+				{Receiver: defaultReceiver, Matchers: []string{testMatcher[0], alertTestNotifyLabel + `="true"`}, GroupBy: []string{alertTestLabel}, GroupWait: "0s"},
+			},
+		},
+		Receivers: receivers,
+	}
+}
+
+func alertReceiver(r config.AlertReceiverConfig, secret func(ref string) string) alertmanagerReceiver {
+	receiver := alertmanagerReceiver{Name: r.Name}
+	switch r.Type {
+	case "discord":
+		receiver.DiscordConfigs = []alertmanagerDiscord{{WebhookURL: secret(r.URLRef), SendResolved: r.SendResolved}}
+	case "slack":
+		receiver.SlackConfigs = []alertmanagerSlack{{APIURL: secret(r.URLRef), Channel: r.Channel, SendResolved: r.SendResolved}}
+	case "webhook":
+		receiver.WebhookConfigs = []alertmanagerWebhook{{URL: secret(r.URLRef), SendResolved: r.SendResolved}}
+	case "email":
+		receiver.EmailConfigs = []alertmanagerEmail{{
+			To:           r.To,
+			From:         r.From,
+			Smarthost:    r.Smarthost,
+			AuthUsername: secret(r.UsernameRef),
+			AuthPassword: secret(r.PasswordRef),
+			SendResolved: r.SendResolved,
+		}}
+	}
+	return receiver
+}
+
+func (a *AppCtx) alertmanagerFiles() (map[string][]byte, error) {
+	amYml, err := a.renderAlertmanagerConfig()
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{"config.yml": amYml}, nil
+}
+
+func (a *AppCtx) reloadAlertmanager() error {
+	return a.postReload(alertmanagerURL())
+}
+
+func observerAlertsTest(cmd *cobra.Command, args []string) {
+	app := GetApp(cmd)
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		log.Error().Err(err).Msg("failed to generate alert id")
+		return
+	}
+	testID := hex.EncodeToString(id)
+
+	delivered := make(chan struct{}, 1)
+	listener, err := net.Listen("tcp", net.JoinHostPort("", cfg.Observer.Alerting.TestWebhookPort))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to start stand-in webhook")
+		return
+	}
+	server := &http.Server{
+		ReadHeaderTimeout: 10 * time.Second,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var payload struct {
+				Alerts []alertmanagerAlert `json:"alerts"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			for _, alert := range payload.Alerts {
+				// anything else reaching the port is not ours
+				if alert.Labels[alertTestLabel] == testID {
+					select {
+					case delivered <- struct{}{}:
+					default:
+					}
+				}
+			}
+			w.WriteHeader(http.StatusOK)
+		}),
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msg("stand-in webhook stopped")
+		}
+	}()
+	defer func() {
+		if err := server.Close(); err != nil {
+			log.Error().Err(err).Msg("failed to stop stand-in webhook")
+		}
+	}()
+
+	alert := alertmanagerAlert{
+		Labels: map[string]string{
+			"alertname":    "OblivionTestAlert",
+			"severity":     "info",
+			alertTestLabel: testID,
+		},
+		Annotations: map[string]string{
+			"summary": "synthetic alert fired by oblivion observer alerts test",
+		},
+		StartsAt: time.Now(),
+		EndsAt:   time.Now().Add(alertsTestTimeout),
+	}
+	if alertsTestNotify {
+		alert.Labels[alertTestNotifyLabel] = "true"
+	}
+	app.Spinner.Prefix = "firing test alert"
+	if err := app.postAlerts(alert); err != nil {
+		log.Error().Err(err).Msg("failed to fire test alert")
+		return
+	}
+	start := time.Now()
+	app.Spinner.Prefix = "waiting for alertmanager to deliver the test alert"
+	select {
+	case <-delivered:
+	case <-time.After(alertsTestTimeout):
+		log.Error().Msgf("test alert was not delivered to %s within %s. alertmanager containers created before alerting support must be recreated, and the host firewall must allow the docker network to reach port %s",
+			alertTestWebhookURL(), alertsTestTimeout, cfg.Observer.Alerting.TestWebhookPort)
+		return
+	case <-app.Context.Done():
+		log.Error().Err(app.Context.Err()).Send()
+		return
+	}
+	elapsed := time.Since(start).Round(time.Millisecond)
+
+	// resolve it so receivers with send_resolved do not keep it open
+	alert.EndsAt = time.Now()
+	if err := app.postAlerts(alert); err != nil {
+		log.Warn().Err(err).Msg("failed to resolve test alert")
+	}
+	color.Green("test alert %s delivered through alertmanager in %s", testID, elapsed)
+}
+
+func (a *AppCtx) postAlerts(alerts ...alertmanagerAlert) error {
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alerts: %w", err)
+	}
+	req, err := http.NewRequestWithContext(a.Context, http.MethodPost, alertmanagerURL()+"/api/v2/alerts", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create alerts request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach alertmanager: %w", err)
+	}
+	defer internal.CloseReader(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("alertmanager returned %s", resp.Status)
+	}
+	return nil
+}
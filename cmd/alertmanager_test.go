@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/caner-cetin/oblivion/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// routeMatches evaluates the two matcher forms the generated config uses, name="value" and name=~"regex"
+func routeMatches(t *testing.T, matchers []string, labels map[string]string) bool {
+	t.Helper()
+	for _, m := range matchers {
+		if name, value, ok := strings.Cut(m, `=~`); ok {
+			if !regexp.MustCompile("^(?:" + strings.Trim(value, `"`) + ")$").MatchString(labels[name]) {
+				return false
+			}
+			continue
+		}
+		name, value, ok := strings.Cut(m, "=")
+		if !ok {
+			t.Fatalf("unsupported matcher %q", m)
+		}
+		if labels[name] != strings.Trim(value, `"`) {
+			return false
+		}
+	}
+	return true
+}
+
+// deliveredTo walks the routing tree like alertmanager does and returns the receivers an alert ends up at
+func deliveredTo(t *testing.T, route alertmanagerRoute, labels map[string]string) []string {
+	t.Helper()
+	var receivers []string
+	for _, child := range route.Routes {
+		if !routeMatches(t, child.Matchers, labels) {
+			continue
+		}
+		receivers = append(receivers, deliveredTo(t, child, labels)...)
+		if !child.Continue {
+			return receivers
+		}
+	}
+	if len(receivers) == 0 {
+		return []string{route.Receiver}
+	}
+	return receivers
+}
+
+func TestAlertmanagerConfigRoutes(t *testing.T) {
+	secret := func(ref string) string { return "https://hooks.example.com/" + ref }
+	discord := config.AlertReceiverConfig{Name: "discord", Type: "discord", URLRef: "/Alerting/discord"}
+	slack := config.AlertReceiverConfig{Name: "slack", Type: "slack", URLRef: "/Alerting/slack", Channel: "#ops"}
+	tests := []struct {
+		name     string
+		alerting config.AlertingConfig
+		labels   map[string]string
+		want     []string
+	}{
+		{
+			name:     "real alert goes to the first receiver",
+			alerting: config.AlertingConfig{Receivers: []config.AlertReceiverConfig{discord, slack}},
+			labels:   map[string]string{"alertname": "InstanceDown"},
+			want:     []string{"discord"},
+		},
+		{
+			name:     "real alert goes to the configured default receiver",
+			alerting: config.AlertingConfig{DefaultReceiver: "slack", Receivers: []config.AlertReceiverConfig{discord, slack}},
+			labels:   map[string]string{"alertname": "InstanceDown"},
+			want:     []string{"slack"},
+		},
+		{
+			name:     "test alert only reaches the stand-in",
+			alerting: config.AlertingConfig{Receivers: []config.AlertReceiverConfig{discord}},
+			labels:   map[string]string{"alertname": "OblivionTestAlert", alertTestLabel: "abc"},
+			want:     []string{alertTestReceiver},
+		},
+		{
+			name:     "test alert with notify also reaches the default receiver",
+			alerting: config.AlertingConfig{Receivers: []config.AlertReceiverConfig{discord}},
+			labels:   map[string]string{"alertname": "OblivionTestAlert", alertTestLabel: "abc", alertTestNotifyLabel: "true"},
+			want:     []string{alertTestReceiver, "discord"},
+		},
+		{
+			name:     "no receivers fall back to the blackhole",
+			alerting: config.AlertingConfig{},
+			labels:   map[string]string{"alertname": "InstanceDown"},
+			want:     []string{alertBlackholeReceiver},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			amCfg := alertmanagerConfigFor(tt.alerting, secret)
+			got := deliveredTo(t, amCfg.Route, tt.labels)
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("delivered to %v, want %v", got, tt.want)
+			}
+			for _, name := range got {
+				if !slices.ContainsFunc(amCfg.Receivers, func(r alertmanagerReceiver) bool { return r.Name == name }) {
+					t.Fatalf("route points at undefined receiver %q", name)
+				}
+			}
+		})
+	}
+}
+
+func TestAlertReceiver(t *testing.T) {
+	secret := func(ref string) string { return "resolved:" + ref }
+	tests := []struct {
+		name  string
+		input config.AlertReceiverConfig
+		check func(alertmanagerReceiver) bool
+	}{
+		{
+			name:  "discord",
+			input: config.AlertReceiverConfig{Name: "d", Type: "discord", URLRef: "/a", SendResolved: true},
+			check: func(r alertmanagerReceiver) bool {
+				return len(r.DiscordConfigs) == 1 && r.DiscordConfigs[0].WebhookURL == "resolved:/a" && r.DiscordConfigs[0].SendResolved
+			},
+		},
+		{
+			name:  "slack",
+			input: config.AlertReceiverConfig{Name: "s", Type: "slack", URLRef: "/b", Channel: "#ops"},
+			check: func(r alertmanagerReceiver) bool {
+				return len(r.SlackConfigs) == 1 && r.SlackConfigs[0].APIURL == "resolved:/b" && r.SlackConfigs[0].Channel == "#ops"
+			},
+		},
+		{
+			name:  "email",
+			input: config.AlertReceiverConfig{Name: "e", Type: "email", To: "ops@example.com", UsernameRef: "/u", PasswordRef: "/p"},
+			check: func(r alertmanagerReceiver) bool {
+				return len(r.EmailConfigs) == 1 && r.EmailConfigs[0].AuthUsername == "resolved:/u" && r.EmailConfigs[0].AuthPassword == "resolved:/p"
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := alertReceiver(tt.input, secret)
+			if got.Name != tt.input.Name || !tt.check(got) {
+				t.Fatalf("unexpected receiver %+v", got)
+			}
+		})
+	}
+}
+
+func TestRenderAlertmanagerConfig(t *testing.T) {
+	setTestConfig(t, func(c *config.Root) {
+		c.Observer.Alerting.TestWebhookPort = "9097"
+		// receivers without references are rendered without asking 1Password
+		c.Observer.Alerting.Receivers = []config.AlertReceiverConfig{{Name: "email", Type: "email", To: "ops@example.com", Smarthost: "smtp.example.com:587"}}
+	})
+	var app AppCtx
+	out, err := app.renderAlertmanagerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(out), "# generated by oblivion") {
+		t.Errorf("missing generated header:\n%s", out)
+	}
+	var got alertmanagerConfig
+	if err := yaml.Unmarshal(out, &got); err != nil {
+		t.Fatalf("rendered config is not valid yaml: %v\n%s", err, out)
+	}
+	if got.Route.Receiver != "email" || got.Route.GroupWait != cfg.Observer.Alerting.GroupWait {
+		t.Errorf("route = %+v", got.Route)
+	}
+	var names []string
+	for _, r := range got.Receivers {
+		names = append(names, r.Name)
+	}
+	if !slices.Equal(names, []string{"email", alertTestReceiver}) {
+		t.Errorf("receivers = %v", names)
+	}
+	if webhook := got.Receivers[1].WebhookConfigs; len(webhook) != 1 || webhook[0].URL != "http://host.docker.internal:9097/" {
+		t.Errorf("stand-in webhook = %+v", webhook)
+	}
+}
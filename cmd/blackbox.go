@@ -0,0 +1,274 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/caner-cetin/oblivion/internal"
+	"github.com/caner-cetin/oblivion/internal/config"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+	"github.com/fatih/color"
+)
+
+const blackboxExporterInternalPort = "9115"
+
+type blackboxConfig struct {
+	Modules map[string]blackboxModule `yaml:"modules"`
+}
+
+type blackboxModule struct {
+	Prober  string             `yaml:"prober"`
+	Timeout string             `yaml:"timeout"`
+	HTTP    *blackboxHTTPProbe `yaml:"http,omitempty"`
+	TCP     *blackboxTCPProbe  `yaml:"tcp,omitempty"`
+}
+
+type blackboxHTTPProbe struct {
+	ValidStatusCodes    []int  `yaml:"valid_status_codes"`
+	PreferredIPProtocol string `yaml:"preferred_ip_protocol"`
+	FollowRedirects     bool   `yaml:"follow_redirects"`
+}
+
+type blackboxTCPProbe struct {
+	TLS                 bool   `yaml:"tls"`
+	PreferredIPProtocol string `yaml:"preferred_ip_protocol"`
+}
+
+type prometheusRuleGroups struct {
+	Groups []prometheusRuleGroup `yaml:"groups"`
+}
+
+type prometheusRuleGroup struct {
+	Name  string           `yaml:"name"`
+	Rules []prometheusRule `yaml:"rules"`
+}
+
+type prometheusRule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+func probesEnabled() bool {
+	return len(cfg.Observer.Probes.Targets) > 0
+}
+
+// every probe is run through the module matching its scheme and, for http, its expected status
+func probeModule(probe config.ProbeConfig) string {
+	target, _ := url.Parse(probe.URL)
+	switch target.Scheme {
+	case "tcp":
+		return "tcp_connect"
+	case "tls":
+		return "tls_connect"
+	}
+	return "http_" + strconv.Itoa(probeExpectedStatus(probe))
+}
+
+func probeExpectedStatus(probe config.ProbeConfig) int {
+	if probe.ExpectedStatus == 0 {
+		return 200
+	}
+	return probe.ExpectedStatus
+}
+
+// blackbox takes host:port for tcp probes and the full url for http probes
+func probeTarget(probe config.ProbeConfig) string {
+	target, _ := url.Parse(probe.URL)
+	if target.Scheme == "tcp" || target.Scheme == "tls" {
+		return target.Host
+	}
+	return probe.URL
+}
+
+// renderBlackboxConfig generates the blackbox_exporter modules the declared probes need.
+func renderBlackboxConfig() ([]byte, error) {
+	bbCfg := blackboxConfig{Modules: make(map[string]blackboxModule)}
+	for _, probe := range cfg.Observer.Probes.Targets {
+		name := probeModule(probe)
+		switch name {
+		case "tcp_connect", "tls_connect":
+			bbCfg.Modules[name] = blackboxModule{
+				Prober:  "tcp",
+				Timeout: "10s",
+				TCP:     &blackboxTCPProbe{TLS: name == "tls_connect", PreferredIPProtocol: "ip4"},
+			}
+		default:
+			bbCfg.Modules[name] = blackboxModule{
+				Prober:  "http",
+				Timeout: "10s",
+				HTTP: &blackboxHTTPProbe{
+					ValidStatusCodes:    []int{probeExpectedStatus(probe)},
+					PreferredIPProtocol: "ip4",
+					FollowRedirects:     true,
+				},
+			}
+		}
+	}
+	out, err := internal.MarshalYAML(&bbCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal blackbox config: %w", err)
+	}
+	return append([]byte("# generated by oblivion from [Observer.Probes] config, edits are overwritten on observer up\n"), out...), nil
+}
+
+// probeScrapeJobs returns one job per blackbox module, every probe is a target labelled with its name
+func probeScrapeJobs() []prometheusScrapeJob {
+	targets := make(map[string][]prometheusStaticConfig)
+	for _, probe := range cfg.Observer.Probes.Targets {
+		module := probeModule(probe)
+		targets[module] = append(targets[module], prometheusStaticConfig{
+			Targets: []string{probeTarget(probe)},
+			Labels:  map[string]string{"probe": probe.Name},
+		})
+	}
+	modules := make([]string, 0, len(targets))
+	for module := range targets {
+		modules = append(modules, module)
+	}
+	sort.Strings(modules)
+	jobs := make([]prometheusScrapeJob, 0, len(modules))
+	for _, module := range modules {
+		jobs = append(jobs, prometheusScrapeJob{
+			JobName:       "blackbox-" + module,
+			MetricsPath:   "/probe",
+			Params:        map[string][]string{"module": {module}},
+			StaticConfigs: targets[module],
+			// the target becomes a parameter of the probe, prometheus scrapes the exporter itself
+			RelabelConfigs: []prometheusRelabel{
+				{SourceLabels: []string{"__address__"}, TargetLabel: "__param_target"},
+				{SourceLabels: []string{"__param_target"}, TargetLabel: "instance"},
+				{TargetLabel: "__address__", Replacement: containerTarget(cfg.Observer.ContainerNames.BlackboxExporter, blackboxExporterInternalPort)},
+			},
+		})
+	}
+	return jobs
+}
+
+// renderProbeRules generates alert rules for failing probes and, per probe, for certificates about to expire
+func renderProbeRules() ([]byte, error) {
+	rules := []prometheusRule{{
+		Alert: "ProbeFailed",
+		Expr:  `probe_success{job=~"blackbox-.+"} == 0`,
+		For:   "2m",
+		Labels: map[string]string{
+			"severity": "critical",
+		},
+		Annotations: map[string]string{
+			"summary":     "probe {{ $labels.probe }} is failing",
+			"description": "{{ $labels.instance }} has failed its {{ $labels.job }} probe for 2 minutes.",
+		},
+	}}
+	for _, probe := range cfg.Observer.Probes.Targets {
+		if probe.TLSExpiryDays == 0 {
+			continue
+		}
+		rules = append(rules, prometheusRule{
+			Alert: "ProbeTLSCertificateExpiring",
+			Expr:  fmt.Sprintf(`probe_ssl_earliest_cert_expiry{probe=%q} - time() < %d * 86400`, probe.Name, probe.TLSExpiryDays),
+			For:   "10m",
+			Labels: map[string]string{
+				"severity": "warning",
+			},
+			Annotations: map[string]string{
+				"summary":     fmt.Sprintf("certificate of %s expires within %d days", probe.Name, probe.TLSExpiryDays),
+				"description": "the certificate served by {{ $labels.instance }} expires in {{ $value | humanizeDuration }}.",
+			},
+		})
+	}
+	out, err := internal.MarshalYAML(&prometheusRuleGroups{Groups: []prometheusRuleGroup{{Name: "probes", Rules: rules}}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal probe rules: %w", err)
+	}
+	return append([]byte("# generated by oblivion from [Observer.Probes] config, edits are overwritten on observer up\n"), out...), nil
+}
+
+func probeRuleFile() string {
+	return path.Join(prometheusRulesDir, "probes.yml")
+}
+
+func (a *AppCtx) blackboxExporterUp() error {
+	if !probesEnabled() {
+		return nil
+	}
+	if err := a.pullImageIfNotExists(cfg.Observer.Images.BlackboxExporter); err != nil {
+		return fmt.Errorf("failed to pull blackbox exporter image: %w", err)
+	}
+	exists, err := a.containerExists(cfg.Observer.ContainerNames.BlackboxExporter)
+	if err != nil {
+		return fmt.Errorf("failed to check existence of blackbox exporter container: %w", err)
+	}
+	blackboxYml, err := renderBlackboxConfig()
+	if err != nil {
+		return err
+	}
+	generated := map[string][]byte{"config.yml": blackboxYml}
+	if exists {
+		changed, err := a.renderObserverConfig(cfg.Observer.ContainerNames.BlackboxExporter, cfg.Observer.Binds.BlackboxExporter, "", "/etc/blackbox_exporter/", generated)
+		if err != nil {
+			return err
+		}
+		if changed {
+			// the exporter port is not published by default, so it is restarted instead of reloaded over http
+			a.Spinner.Prefix = "restarting blackbox exporter"
+			if err := a.Docker.Client.ContainerRestart(a.Context, cfg.Observer.ContainerNames.BlackboxExporter, container.StopOptions{}); err != nil {
+				return fmt.Errorf("failed to restart blackbox exporter: %w", err)
+			}
+		}
+		color.Cyan("blackbox exporter running")
+		return nil
+	}
+	if cfg.Observer.Binds.BlackboxExporter == "" {
+		if err := a.recreateVolume(cfg.Observer.ConfigVolumes.BlackboxExporter); err != nil {
+			return fmt.Errorf("failed to create blackbox exporter config volume: %w", err)
+		}
+	}
+	port := nat.Port(blackboxExporterInternalPort + "/tcp")
+	hostConfig := &container.HostConfig{
+		RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyAlways},
+		Mounts: []mount.Mount{
+			observerConfigMount(cfg.Observer.Binds.BlackboxExporter, cfg.Observer.ConfigVolumes.BlackboxExporter, "/etc/blackbox_exporter/"),
+		},
+		// lets probes reach services published on the host, like the static nginx
+		ExtraHosts: []string{"host.docker.internal:host-gateway"},
+		LogConfig:  managedLogConfig("observer", "blackbox-exporter"),
+	}
+	if cfg.Observer.Ports.BlackboxExporter != "" {
+		hostConfig.PortBindings = nat.PortMap{port: []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: cfg.Observer.Ports.BlackboxExporter}}}
+	}
+	a.Spinner.Prefix = "creating blackbox exporter"
+	resp, err := a.Docker.Client.ContainerCreate(a.Context,
+		&container.Config{
+			Image:        cfg.Observer.Images.BlackboxExporter,
+			Labels:       managedLabels("observer", "blackbox-exporter"),
+			Healthcheck:  blackboxExporterHealthcheck,
+			Cmd:          []string{"--config.file=/etc/blackbox_exporter/config.yml"},
+			ExposedPorts: nat.PortSet{port: struct{}{}},
+		},
+		hostConfig,
+		&network.NetworkingConfig{
+			EndpointsConfig: a.getNetworks(cfg.Networks.GrafanaNetworkName, cfg.Networks.DatabaseNetworkName),
+		},
+		nil,
+		cfg.Observer.ContainerNames.BlackboxExporter,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create blackbox exporter container: %w", err)
+	}
+	if _, err := a.renderObserverConfig(resp.ID, cfg.Observer.Binds.BlackboxExporter, "", "/etc/blackbox_exporter/", generated); err != nil {
+		return err
+	}
+	a.Spinner.Prefix = "starting blackbox exporter"
+	if err := a.Docker.Client.ContainerStart(a.Context, resp.ID, container.StartOptions{}); err != nil {
+		return fmt.Errorf("failed to start blackbox exporter: %w", err)
+	}
+	return nil
+}
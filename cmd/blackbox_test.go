@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/caner-cetin/oblivion/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+func TestProbeScrapeJobs(t *testing.T) {
+	type job struct {
+		module  string
+		targets []string
+		probes  []string
+	}
+	tests := []struct {
+		name    string
+		targets []config.ProbeConfig
+		want    []job
+	}{
+		{
+			name: "no probes",
+		},
+		{
+			name: "probes are grouped by module",
+			targets: []config.ProbeConfig{
+				{Name: "site", URL: "https://cansu.dev"},
+				{Name: "static", URL: "http://host.docker.internal:8080/", ExpectedStatus: 200},
+				{Name: "login", URL: "https://cansu.dev/admin", ExpectedStatus: 401},
+				{Name: "db", URL: "tcp://cansu.dev-pg-primary:5432"},
+				{Name: "cert", URL: "tls://cansu.dev:443"},
+			},
+			want: []job{
+				{"http_200", []string{"https://cansu.dev", "http://host.docker.internal:8080/"}, []string{"site", "static"}},
+				{"http_401", []string{"https://cansu.dev/admin"}, []string{"login"}},
+				{"tcp_connect", []string{"cansu.dev-pg-primary:5432"}, []string{"db"}},
+				{"tls_connect", []string{"cansu.dev:443"}, []string{"cert"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestConfig(t, func(c *config.Root) {
+				c.Observer.ContainerNames.BlackboxExporter = "blackbox"
+				c.Observer.Probes.Targets = tt.targets
+			})
+			jobs := probeScrapeJobs()
+			if len(jobs) != len(tt.want) {
+				t.Fatalf("%d jobs, want %d: %+v", len(jobs), len(tt.want), jobs)
+			}
+			for i, want := range tt.want {
+				got := jobs[i]
+				if got.JobName != "blackbox-"+want.module || got.MetricsPath != "/probe" || !slices.Equal(got.Params["module"], []string{want.module}) {
+					t.Errorf("job %d = %+v, want module %s", i, got, want.module)
+				}
+				var targets, probes []string
+				for _, sc := range got.StaticConfigs {
+					targets = append(targets, sc.Targets...)
+					probes = append(probes, sc.Labels["probe"])
+				}
+				if !slices.Equal(targets, want.targets) || !slices.Equal(probes, want.probes) {
+					t.Errorf("job %s targets %v with probes %v, want %v and %v", got.JobName, targets, probes, want.targets, want.probes)
+				}
+				// prometheus has to scrape the exporter, not the probed target
+				last := got.RelabelConfigs[len(got.RelabelConfigs)-1]
+				if last.TargetLabel != "__address__" || last.Replacement != "blackbox:"+blackboxExporterInternalPort {
+					t.Errorf("job %s does not point at the exporter: %+v", got.JobName, last)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderProbeRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets []config.ProbeConfig
+		alerts  []string
+		exprs   []string
+	}{
+		{
+			name:    "failure alert only",
+			targets: []config.ProbeConfig{{Name: "site", URL: "https://cansu.dev"}},
+			alerts:  []string{"ProbeFailed"},
+		},
+		{
+			name: "expiry alert per probe that asks for one",
+			targets: []config.ProbeConfig{
+				{Name: "site", URL: "https://cansu.dev", TLSExpiryDays: 14},
+				{Name: "db", URL: "tcp://cansu.dev-pg-primary:5432"},
+				{Name: "cert", URL: "tls://cansu.dev:443", TLSExpiryDays: 7},
+			},
+			alerts: []string{"ProbeFailed", "ProbeTLSCertificateExpiring", "ProbeTLSCertificateExpiring"},
+			exprs: []string{
+				`probe_ssl_earliest_cert_expiry{probe="site"} - time() < 14 * 86400`,
+				`probe_ssl_earliest_cert_expiry{probe="cert"} - time() < 7 * 86400`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestConfig(t, func(c *config.Root) {
+				c.Observer.Probes.Targets = tt.targets
+			})
+			out, err := renderProbeRules()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(string(out), "# generated by oblivion") {
+				t.Errorf("missing generated header:\n%s", out)
+			}
+			var got prometheusRuleGroups
+			if err := yaml.Unmarshal(out, &got); err != nil {
+				t.Fatalf("rendered rules are not valid yaml: %v\n%s", err, out)
+			}
+			if len(got.Groups) != 1 || got.Groups[0].Name != "probes" {
+				t.Fatalf("groups = %+v", got.Groups)
+			}
+			var alerts, exprs []string
+			for i, rule := range got.Groups[0].Rules {
+				alerts = append(alerts, rule.Alert)
+				if i > 0 {
+					exprs = append(exprs, rule.Expr)
+				}
+				if rule.Labels["severity"] == "" {
+					t.Errorf("%s has no severity", rule.Alert)
+				}
+			}
+			if !slices.Equal(alerts, tt.alerts) || !slices.Equal(exprs, tt.exprs) {
+				t.Errorf("alerts %v with %v, want %v with %v", alerts, exprs, tt.alerts, tt.exprs)
+			}
+		})
+	}
+}
+
+func TestRenderBlackboxConfig(t *testing.T) {
+	setTestConfig(t, func(c *config.Root) {
+		c.Observer.Probes.Targets = []config.ProbeConfig{
+			{Name: "site", URL: "https://cansu.dev"},
+			{Name: "login", URL: "https://cansu.dev/admin", ExpectedStatus: 401},
+			{Name: "cert", URL: "tls://cansu.dev:443"},
+		}
+	})
+	out, err := renderBlackboxConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got blackboxConfig
+	if err := yaml.Unmarshal(out, &got); err != nil {
+		t.Fatalf("rendered config is not valid yaml: %v\n%s", err, out)
+	}
+	if m := got.Modules["http_401"]; m.Prober != "http" || m.HTTP == nil || !slices.Equal(m.HTTP.ValidStatusCodes, []int{401}) {
+		t.Errorf("http_401 = %+v", m)
+	}
+	if m := got.Modules["tls_connect"]; m.Prober != "tcp" || m.TCP == nil || !m.TCP.TLS {
+		t.Errorf("tls_connect = %+v", m)
+	}
+	if len(got.Modules) != 3 {
+		t.Errorf("modules = %v", got.Modules)
+	}
+}
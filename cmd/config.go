@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/caner-cetin/oblivion/internal"
+	"github.com/caner-cetin/oblivion/internal/config"
+	"github.com/fatih/color"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	configShowEffective bool
+	configShowCmd       = &cobra.Command{
+		Use:   "show",
+		Short: "print the config file, or the merged configuration with --effective",
+		// no spinner, output is meant to be piped
+		Run: configShow,
+	}
+	configValidateCmd = &cobra.Command{
+		Use:   "validate",
+		Short: "check the merged configuration and report every problem at once",
+		Run:   configValidate,
+	}
+	configMigrateDryRun bool
+	configMigrateCmd    = &cobra.Command{
+		Use:   "migrate",
+		Short: "upgrade the config file to the current schema version, keeping a backup of the original",
+		Long: `Other commands refuse to run on a config file older than this build until it is migrated.
+Only the keys a migration renames, moves or removes are edited, comments and everything else stay as they are.
+Keys missing from the file keep coming from the defaults. --dry-run prints the diff without touching the file.`,
+		Run: configMigrate,
+	}
+	configCmd = &cobra.Command{
+		Use: "config",
+	}
+)
+
+func getConfigCmd() *cobra.Command {
+	configShowCmd.Flags().BoolVar(&configShowEffective, "effective", false, "print the merged result of defaults, config file, host overlay and OBLIVION_* variables, annotated with the source of every key")
+	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configValidateCmd)
+	configMigrateCmd.Flags().BoolVar(&configMigrateDryRun, "dry-run", false, "print the changes without writing them")
+	configCmd.AddCommand(configMigrateCmd)
+	return configCmd
+}
+
+func configShow(cmd *cobra.Command, args []string) {
+	if configShowEffective {
+		if err := cfg.WriteAnnotated(os.Stdout, cfgProvenance); err != nil {
+			log.Error().Err(err).Send()
+		}
+		return
+	}
+	contents, err := internal.ReadFile(cfgPath)
+	if err != nil {
+		log.Error().Err(err).Str("path", cfgPath).Msg("failed to read config file")
+		return
+	}
+	if _, err := os.Stdout.Write(contents); err != nil {
+		log.Error().Err(err).Send()
+	}
+}
+
+func configValidate(cmd *cobra.Command, args []string) {
+	if err := cfg.Validate(cfgProvenance); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exitCode = 1
+		return
+	}
+	color.Green("%s is valid", cfgPath)
+}
+
+func configMigrate(cmd *cobra.Command, args []string) {
+	result, backup, err := migrateConfigFile(cfgPath, configMigrateDryRun)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	if len(result.Applied) == 0 {
+		color.Green("%s is at version %d, nothing to migrate", cfgPath, result.From)
+		return
+	}
+	for _, m := range result.Applied {
+		fmt.Printf("v%d -> v%d: %s\n", m.From, m.From+1, m.Description)
+	}
+	for _, key := range result.Unknown {
+		color.Yellow("unknown key %s is kept, rename or remove it", key)
+	}
+	fmt.Print(internal.LineDiff(string(result.Original), string(result.Contents), 3))
+	if configMigrateDryRun {
+		return
+	}
+	color.Green("migrated %s to version %d, original saved to %s", cfgPath, result.To, backup)
+}
+
+// migrateConfigFile upgrades the config file at path in place if it is older than config.CurrentVersion.
+// The original is copied next to it as <path>.v<version>.<timestamp>.bak first, its path is returned.
+func migrateConfigFile(path string, dryRun bool) (*config.MigrationResult, string, error) {
+	contents, err := internal.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read config file: %w", err)
+	}
+	result, err := config.Migrate(contents)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to migrate config: %w", err)
+	}
+	if len(result.Applied) == 0 || dryRun {
+		return result, "", nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to stat config file: %w", err)
+	}
+	backup := fmt.Sprintf("%s.v%d.%s.bak", path, result.From, time.Now().Format("20060102-150405"))
+	if err := os.WriteFile(backup, contents, info.Mode().Perm()); err != nil {
+		return nil, "", fmt.Errorf("failed to back up config file: %w", err)
+	}
+	if err := os.WriteFile(path, result.Contents, info.Mode().Perm()); err != nil {
+		return nil, "", fmt.Errorf("failed to write migrated config, original is at %s: %w", backup, err)
+	}
+	return result, backup, nil
+}
@@ -4,12 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"time"
 
 	"github.com/1password/onepassword-sdk-go"
 	"github.com/briandowns/spinner"
 	"github.com/caner-cetin/oblivion/internal"
+	"github.com/caner-cetin/oblivion/internal/secretcache"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
 	"github.com/rs/zerolog/log"
@@ -25,6 +27,8 @@ type AppCtx struct {
 		Prefix string
 		Client *onepassword.Client
 		ID     string
+		// nil unless [Onepass.Cache] is enabled
+		Cache *secretcache.Cache
 	}
 	Context context.Context
 	Spinner *spinner.Spinner
@@ -66,6 +70,10 @@ func WrapCommandWithResources(fn func(cmd *cobra.Command, args []string), resour
 					log.Error().Err(err).Msg("failed to initialize docker")
 					return
 				}
+				if err := appCtx.checkLokiPlugin(); err != nil {
+					log.Error().Err(err).Send()
+					return
+				}
 				appCtx.Docker.Networks = make(map[string]*network.EndpointSettings)
 			case ResourceOnePassword:
 				if err := appCtx.InitializeOnePass(); err != nil {
@@ -130,7 +138,22 @@ func (ctx *AppCtx) InitializeDocker() error {
 	return nil
 }
 
+// InitializeOnePass prepares secret resolution. With the secret cache enabled the 1Password
+// client is only created once a secret is missing from the cache, see connectOnePass.
 func (ctx *AppCtx) InitializeOnePass() error {
+	ctx.Vault.Prefix = fmt.Sprintf("op://%s", cfg.Onepass.VaultName)
+	if cfg.Onepass.Cache.Enabled {
+		cache, err := openSecretCache()
+		if err != nil {
+			return err
+		}
+		ctx.Vault.Cache = cache
+		return nil
+	}
+	return ctx.connectOnePass()
+}
+
+func (ctx *AppCtx) connectOnePass() error {
 	token := os.Getenv("OP_SERVICE_ACCOUNT_TOKEN")
 	if token == "" {
 		return fmt.Errorf("onepassword service account token not set")
@@ -144,7 +167,6 @@ func (ctx *AppCtx) InitializeOnePass() error {
 		return fmt.Errorf("failed to create 1Password client: %w", err)
 	}
 	ctx.Vault.Client = client
-	ctx.Vault.Prefix = fmt.Sprintf("op://%s", cfg.Onepass.VaultName)
 	vaults, err := ctx.Vault.Client.Vaults().ListAll(ctx.Context)
 	if err != nil {
 		return fmt.Errorf("failed to list 1Password vaults: %w", err)
@@ -168,6 +190,16 @@ func (ctx *AppCtx) InitializeOnePass() error {
 	return nil
 }
 
+// dockerHostname returns the host the docker daemon runs on, containers publish their ports there.
+// A local socket or an empty [Docker] Socket is localhost.
+func dockerHostname() string {
+	daemon, err := url.Parse(cfg.Docker.Socket)
+	if err != nil || daemon.Hostname() == "" {
+		return "localhost"
+	}
+	return daemon.Hostname()
+}
+
 func NewDockerClient() (*client.Client, error) {
 	if cfg.Docker.Socket == "" {
 		log.Warn().Msg("docker socket is not set, defaulting back to unix:///var/run/docker.sock")
@@ -10,7 +10,7 @@ import (
 	"io"
 	"io/fs"
 	"os"
-	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -21,12 +21,13 @@ import (
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/errdefs"
 	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/fatih/color"
-	git "github.com/go-git/go-git/v5"
 	v1 "github.com/moby/docker-image-spec/specs-go/v1"
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
 	"github.com/rs/zerolog/log"
-	"github.com/spf13/cobra"
 	"github.com/vbauerster/mpb/v8"
 	"github.com/vbauerster/mpb/v8/decor"
 )
@@ -47,6 +48,10 @@ func (a *AppCtx) waitForContainerHealthWithConfig(containerID string, healthConf
 			log.Info().Msg("container is healthy")
 			return nil
 		}
+		// an exited container never turns healthy, restarting ones get another chance
+		if inspect.State != nil && !inspect.State.Running && !inspect.State.Restarting {
+			return fmt.Errorf("container exited with %d", inspect.State.ExitCode)
+		}
 		if time.Since(startTime) > healthConfig.Interval*time.Duration(healthConfig.Retries) {
 			return fmt.Errorf("timeout waiting for container to become healthy")
 		}
@@ -78,20 +83,24 @@ func (a *AppCtx) readLogs(ctx context.Context, containerID string) {
 
 	done := make(chan struct{})
 	go func() {
-		_, err := stdcopy.StdCopy(
-			&containerLogWriter{
-				Writer:    os.Stdout,
-				Container: &container_info,
-			},
-			&containerLogWriter{
-				Writer:    os.Stderr,
-				Container: &container_info,
-			},
-			logs)
+		stdout := &containerLogWriter{
+			Writer:    os.Stdout,
+			Container: &container_info,
+		}
+		stderr := &containerLogWriter{
+			Writer:    os.Stderr,
+			Container: &container_info,
+		}
+		_, err := stdcopy.StdCopy(stdout, stderr, logs)
 
 		if err != nil && !errors.Is(err, io.EOF) {
 			logger.Error().Err(err).Msg("error reading container logs")
 		}
+		for _, w := range []*containerLogWriter{stdout, stderr} {
+			if err := w.Flush(); err != nil {
+				logger.Error().Err(err).Msg("error writing container logs")
+			}
+		}
 		close(done)
 	}()
 
@@ -107,13 +116,46 @@ func (a *AppCtx) readLogs(ctx context.Context, containerID string) {
 
 }
 
+// lines longer than this are written out in pieces, a secret crossing a piece boundary is not redacted
+const containerLogMaxLine = 64 * 1024
+
+// containerLogWriter prefixes every line of a container's output with its name and stream.
+// Output is held back until a line is complete so secrets split across reads are redacted as a whole.
 type containerLogWriter struct {
 	Writer    io.Writer
 	Container *container.InspectResponse
-	lastByte  byte
+	pending   bytes.Buffer
+}
+
+func (w *containerLogWriter) Write(raw []byte) (n int, err error) {
+	w.pending.Write(raw)
+	for {
+		i := bytes.IndexByte(w.pending.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		if err := w.writeLine(w.pending.Next(i + 1)); err != nil {
+			return 0, err
+		}
+	}
+	if w.pending.Len() > containerLogMaxLine {
+		if err := w.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(raw), nil
+}
+
+// Flush writes out a partial line, terminated so the next line gets its own prefix
+func (w *containerLogWriter) Flush() error {
+	if w.pending.Len() == 0 {
+		return nil
+	}
+	line := append(w.pending.Next(w.pending.Len()), '\n')
+	return w.writeLine(line)
 }
 
-func (w *containerLogWriter) Write(p []byte) (n int, err error) {
+func (w *containerLogWriter) writeLine(line []byte) error {
 	var prefix string
 	switch w.Writer {
 	case os.Stdout:
@@ -121,37 +163,10 @@ func (w *containerLogWriter) Write(p []byte) (n int, err error) {
 	case os.Stderr:
 		prefix = fmt.Sprintf("[%s][stderr] ", w.Container.Name)
 	}
-	prefix_bytes := []byte(prefix)
-	if w.lastByte == 0 || w.lastByte == '\n' {
-		_, err = w.Writer.Write(prefix_bytes)
-		if err != nil {
-			return 0, fmt.Errorf("failed to write prefix: %w", err)
-		}
-	}
-
-	start := 0
-	for i, b := range p {
-		if b == '\n' && i < len(p)-1 {
-			_, err = w.Writer.Write(p[start : i+1])
-			if err != nil {
-				return 0, fmt.Errorf("failed to write line: %w", err)
-			}
-			_, err = w.Writer.Write(prefix_bytes)
-			if err != nil {
-				return 0, fmt.Errorf("failed to write prefix: %w", err)
-			}
-			start = i + 1
-		}
-		w.lastByte = b
-	}
-
-	if start < len(p) {
-		_, err = w.Writer.Write(p[start:])
-		if err != nil {
-			return 0, fmt.Errorf("failed to write remaining bytes: %w", err)
-		}
+	if _, err := io.WriteString(w.Writer, prefix+internal.Redact(string(line))); err != nil {
+		return fmt.Errorf("failed to write line: %w", err)
 	}
-	return len(p), nil
+	return nil
 }
 
 func (a *AppCtx) spawnLogs(containerID string) context.CancelFunc {
@@ -165,32 +180,37 @@ type BuildResponse struct {
 	Error  string `json:"error"`
 }
 
-// src is the source folder
-func (a *AppCtx) buildImage(fs fs.FS, dir string, image_tag string, dockerfile string) error {
-	buildCtx, err := createBuildContext(fs, dir)
+// buildImage builds image_tag from src, which must be rooted at the build context: dockerfile and .dockerignore are looked up at its top
+func (a *AppCtx) buildImage(src fs.FS, image_tag string, dockerfile string) error {
+	buildCtx, err := createBuildContext(src, dockerfile)
 	if err != nil {
 		return err
 	}
+	// closing the context stops the walk if the daemon gives up before reading all of it
+	defer buildCtx.Close()
 	response, err := a.Docker.Client.ImageBuild(a.Context, buildCtx, types.ImageBuildOptions{
 		Tags:       []string{image_tag},
 		Dockerfile: dockerfile,
 	})
 	if err != nil {
-		return fmt.Errorf("failed to build docker image: %w", err)
+		return fmt.Errorf("failed to build docker image: %w", internal.RedactError(err))
 	}
+	defer response.Body.Close()
 	decoder := json.NewDecoder(response.Body)
+	failed := false
 	for {
 		var message BuildResponse
 		if err := decoder.Decode(&message); err != nil {
 			if err == io.EOF {
 				break
 			}
-			cobra.CheckErr(err)
+			return fmt.Errorf("failed to decode build output: %w", internal.RedactError(err))
 		}
 
 		if message.Error != "" {
+			failed = true
 			a.Spinner.Stop()
-			log.Error().Msg(message.Error)
+			log.Error().Msg(internal.Redact(message.Error))
 			a.Spinner.Start()
 			continue
 		}
@@ -199,65 +219,279 @@ func (a *AppCtx) buildImage(fs fs.FS, dir string, image_tag string, dockerfile s
 			cleanMsg := strings.TrimSuffix(message.Stream, "\n")
 			if cleanMsg != "" {
 				a.Spinner.Stop()
-				fmt.Println(cleanMsg)
+				fmt.Println(internal.Redact(cleanMsg))
 				a.Spinner.Start()
 			}
 		}
 	}
+	if failed {
+		return fmt.Errorf("failed to build %s", image_tag)
+	}
 	return nil
 }
 
-func createBuildContext(filesystem fs.FS, dir string) (*bytes.Buffer, error) {
-	buf := new(bytes.Buffer)
-	tw := tar.NewWriter(buf)
-	defer func() {
-		if closeErr := tw.Close(); closeErr != nil {
-			err := fmt.Errorf("failed to close tar writer: %w", closeErr)
-			log.Error().Err(err).Msg("error closing tar writer")
+// readDockerignore returns the patterns of the .dockerignore at the root of filesystem, none if there is no such file
+func readDockerignore(filesystem fs.FS) ([]string, error) {
+	f, err := filesystem.Open(".dockerignore")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open .dockerignore: %w", err)
+	}
+	defer f.Close()
+	patterns, err := ignorefile.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read .dockerignore: %w", err)
+	}
+	return patterns, nil
+}
+
+// createBuildContext streams filesystem as a tar archive, leaving out what .dockerignore excludes the way
+// docker build does. The Dockerfile and .dockerignore are always sent, the daemon needs them.
+// The archive is written while it is read, closing the reader early stops the walk.
+func createBuildContext(filesystem fs.FS, dockerfile string) (io.ReadCloser, error) {
+	patterns, err := readDockerignore(filesystem)
+	if err != nil {
+		return nil, err
+	}
+	matcher, err := patternmatcher.New(patterns)
+	if err != nil {
+		return nil, fmt.Errorf("invalid .dockerignore: %w", err)
+	}
+	reader, writer := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(writer)
+		err := fs.WalkDir(filesystem, ".", func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if path == "." {
+				return nil
+			}
+			if path != dockerfile && path != ".dockerignore" {
+				ignored, err := matcher.MatchesOrParentMatches(path)
+				if err != nil {
+					return fmt.Errorf("failed to match %s against .dockerignore: %w", path, err)
+				}
+				if ignored {
+					// an exclusion like !dir/keep can bring back files below an ignored directory
+					if d.IsDir() && !matcher.Exclusions() {
+						return fs.SkipDir
+					}
+					return nil
+				}
+			}
+			return writeBuildContextEntry(tw, filesystem, path, d.IsDir())
+		})
+		if err == nil {
+			err = tw.Close()
 		}
+		writer.CloseWithError(err)
 	}()
+	return reader, nil
+}
 
-	err := fs.WalkDir(filesystem, ".", func(path string, d fs.DirEntry, err error) error {
+// writes a file, with its contents, or a directory of filesystem into the build context. Symlinks are followed.
+func writeBuildContextEntry(tw *tar.Writer, filesystem fs.FS, path string, dir bool) error {
+	if dir {
+		info, err := fs.Stat(filesystem, path)
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to stat %s: %w", path, err)
 		}
-		if d.IsDir() {
-			return nil
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return fmt.Errorf("failed to create tar header for %s: %w", path, err)
+		}
+		header.Name = path + "/"
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write tar header: %w", err)
 		}
+		return nil
+	}
+	f, err := filesystem.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", path, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get info for file %s: %w", path, err)
+	}
+	if !info.Mode().IsRegular() {
+		// sockets, pipes and devices have no place in a build context
+		return nil
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return fmt.Errorf("failed to create tar header for %s: %w", path, err)
+	}
+	header.Name = path
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write tar header: %w", err)
+	}
+	if _, err := io.Copy(tw, f); err != nil {
+		return fmt.Errorf("failed to write tar content: %w", err)
+	}
+	return nil
+}
 
+// reads every regular file of filesystem into memory, keyed by its slash separated path
+func readFiles(filesystem fs.FS) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	err := fs.WalkDir(filesystem, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
 		data, err := fs.ReadFile(filesystem, path)
 		if err != nil {
 			return fmt.Errorf("failed to read file %s: %w", path, err)
 		}
+		files[path] = data
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk files: %w", err)
+	}
+	return files, nil
+}
 
-		info, err := d.Info()
-		if err != nil {
-			return fmt.Errorf("failed to get info for file %s: %w", path, err)
-		}
-
+func createArchive(files map[string][]byte) (*bytes.Buffer, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	for _, name := range names {
 		header := &tar.Header{
-			Name:    path,
-			Size:    info.Size(),
-			Mode:    int64(info.Mode()),
-			ModTime: info.ModTime(),
+			Name:    name,
+			Size:    int64(len(files[name])),
+			Mode:    0644,
+			ModTime: time.Now(),
 		}
-
 		if err := tw.WriteHeader(header); err != nil {
-			return fmt.Errorf("failed to write tar header: %w", err)
+			return nil, fmt.Errorf("failed to write tar header: %w", err)
 		}
-
-		if _, err := tw.Write(data); err != nil {
-			return fmt.Errorf("failed to write tar content: %w", err)
+		if _, err := tw.Write(files[name]); err != nil {
+			return nil, fmt.Errorf("failed to write tar content: %w", err)
 		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close tar writer: %w", err)
+	}
+	return buf, nil
+}
 
-		return nil
-	})
+// writes files into dest inside the container, dest must exist but the container does not need to be running
+func (a *AppCtx) copyFilesToContainer(containerID string, files map[string][]byte, dest string) error {
+	archive, err := createArchive(files)
+	if err != nil {
+		return fmt.Errorf("failed to archive files for %s: %w", dest, err)
+	}
+	if err := a.Docker.Client.CopyToContainer(a.Context, containerID, dest, archive, container.CopyToContainerOptions{}); err != nil {
+		return fmt.Errorf("failed to copy files to %s:%s: %w", containerID, dest, err)
+	}
+	return nil
+}
 
+// reads a single file out of a container, running or not
+func (a *AppCtx) readContainerFile(containerID string, path string) ([]byte, error) {
+	reader, _, err := a.Docker.Client.CopyFromContainer(a.Context, containerID, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy %s from container: %w", path, err)
+	}
+	defer internal.CloseReader(reader)
+	tr := tar.NewReader(reader)
+	if _, err := tr.Next(); err != nil {
+		return nil, fmt.Errorf("failed to read archive of %s: %w", path, err)
+	}
+	contents, err := io.ReadAll(tr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to walk context path: %w", err)
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
 	}
+	return contents, nil
+}
 
-	return buf, nil
+// runs cmd inside a running container, feeding stdin if not nil, and waits for it to finish.
+// Returns stdout, a non-zero exit code is an error carrying stderr.
+func (a *AppCtx) execInContainer(containerID string, cmd []string, env []string, stdin io.Reader) (string, error) {
+	exec, err := a.Docker.Client.ContainerExecCreate(a.Context, containerID, container.ExecOptions{
+		Cmd:          cmd,
+		Env:          env,
+		AttachStdin:  stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create exec %s: %w", cmd[0], internal.RedactError(err))
+	}
+	attach, err := a.Docker.Client.ContainerExecAttach(a.Context, exec.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to attach to exec %s: %w", cmd[0], err)
+	}
+	defer attach.Close()
+	if stdin != nil {
+		if _, err := io.Copy(attach.Conn, stdin); err != nil {
+			return "", fmt.Errorf("failed to write stdin of %s: %w", cmd[0], err)
+		}
+		if err := attach.CloseWrite(); err != nil {
+			return "", fmt.Errorf("failed to close stdin of %s: %w", cmd[0], err)
+		}
+	}
+	var stdout, stderr bytes.Buffer
+	if _, err := stdcopy.StdCopy(&stdout, &stderr, attach.Reader); err != nil {
+		return "", fmt.Errorf("failed to read output of %s: %w", cmd[0], err)
+	}
+	inspect, err := a.Docker.Client.ContainerExecInspect(a.Context, exec.ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect exec %s: %w", cmd[0], err)
+	}
+	if inspect.ExitCode != 0 {
+		return stdout.String(), fmt.Errorf("%s exited with %d: %s", cmd[0], inspect.ExitCode, internal.Redact(strings.TrimSpace(stderr.String())))
+	}
+	return stdout.String(), nil
+}
+
+// runs a throwaway container from config until it exits, files are copied to dest (which must exist in the image) before it starts.
+// Returns the combined stdout and stderr and the exit code, the container is removed afterwards.
+func (a *AppCtx) runContainerOnce(config *container.Config, files map[string][]byte, dest string) (string, int, error) {
+	resp, err := a.Docker.Client.ContainerCreate(a.Context, config, &container.HostConfig{}, nil, nil, "")
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create %s container: %w", config.Image, err)
+	}
+	defer func() {
+		if err := a.Docker.Client.ContainerRemove(context.Background(), resp.ID, container.RemoveOptions{Force: true}); err != nil {
+			log.Warn().Err(err).Str("container", resp.ID).Msg("failed to remove throwaway container")
+		}
+	}()
+	if len(files) > 0 {
+		if err := a.copyFilesToContainer(resp.ID, files, dest); err != nil {
+			return "", 0, err
+		}
+	}
+	statusCh, errCh := a.Docker.Client.ContainerWait(a.Context, resp.ID, container.WaitConditionNextExit)
+	if err := a.Docker.Client.ContainerStart(a.Context, resp.ID, container.StartOptions{}); err != nil {
+		return "", 0, fmt.Errorf("failed to start %s container: %w", config.Image, err)
+	}
+	var exitCode int
+	select {
+	case err := <-errCh:
+		return "", 0, fmt.Errorf("failed to wait for %s container: %w", config.Image, err)
+	case status := <-statusCh:
+		exitCode = int(status.StatusCode)
+	}
+	logs, err := a.Docker.Client.ContainerLogs(a.Context, resp.ID, container.LogsOptions{ShowStdout: true, ShowStderr: true})
+	if err != nil {
+		return "", exitCode, fmt.Errorf("failed to read %s container output: %w", config.Image, err)
+	}
+	defer internal.CloseReader(logs)
+	var output bytes.Buffer
+	if _, err := stdcopy.StdCopy(&output, &output, logs); err != nil {
+		return "", exitCode, fmt.Errorf("failed to read %s container output: %w", config.Image, err)
+	}
+	return output.String(), exitCode, nil
 }
 
 func (a *AppCtx) volumeExists(name string) (bool, error) {
@@ -289,6 +523,17 @@ func (a *AppCtx) createVolumeIfNotExists(name string, opts *volume.CreateOptions
 	return nil
 }
 
+// removes the volume if it exists and creates it empty, for volumes whose contents are rendered from scratch
+func (a *AppCtx) recreateVolume(name string) error {
+	if err := a.Docker.Client.VolumeRemove(a.Context, name, false); err != nil && !errdefs.IsNotFound(err) {
+		return fmt.Errorf("failed to remove %s volume: %w", name, err)
+	}
+	if _, err := a.Docker.Client.VolumeCreate(a.Context, volume.CreateOptions{Name: name}); err != nil {
+		return fmt.Errorf("failed to create %s volume: %w", name, err)
+	}
+	return nil
+}
+
 func (a *AppCtx) imageExists(name string) (bool, error) {
 	resp, err := a.Docker.Client.ImageList(a.Context, image.ListOptions{
 		Filters: filters.NewArgs(filters.Arg("reference", name)),
@@ -444,50 +689,3 @@ func (a *AppCtx) printPullProgressPretty(msg map[string]interface{}, progressMap
 
 	bar.SetCurrent(int64(current))
 }
-
-func (a *AppCtx) pullRepo(repo_path string) error {
-	if _, err := os.Stat(filepath.Join(repo_path, ".git")); err == nil {
-		// Directory exists with .git, open the repo and pull
-		repo, err := git.PlainOpen(repo_path)
-		if err != nil {
-			// If open fails, try removing and re-cloning
-			if err := os.RemoveAll(repo_path); err != nil {
-				return fmt.Errorf("failed to remove existing repository directory: %w", err)
-			}
-			return fmt.Errorf("failed to open existing repository: %w", err)
-			// Will fall through to clone
-		} else {
-			// Repository opened successfully, pull latest
-			w, err := repo.Worktree()
-			if err != nil {
-				return fmt.Errorf("failed to get worktree: %w", err)
-			}
-
-			err = w.Pull(&git.PullOptions{Force: true})
-			if err != nil && err != git.NoErrAlreadyUpToDate {
-				return fmt.Errorf("failed to pull updates: %w", err)
-			}
-
-			return nil
-		}
-	} else if !os.IsNotExist(err) {
-		// Some other error occurred
-		return fmt.Errorf("failed to check if repository directory exists: %w", err)
-	} else {
-		// Make sure the parent directory exists
-		if err := os.MkdirAll(repo_path, 0755); err != nil {
-			return fmt.Errorf("failed to create repository directory: %w", err)
-		}
-	}
-
-	// Directory doesn't exist or we decided to re-clone, clone it
-	_, err := git.PlainClone(repo_path, false, &git.CloneOptions{
-		URL:      cfg.Playground.Backend.Repository,
-		Progress: os.Stdout, // Optional: Shows progress
-	})
-	if err != nil {
-		return fmt.Errorf("failed to clone repository: %w", err)
-	}
-
-	return nil
-}
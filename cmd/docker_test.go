@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/caner-cetin/oblivion/internal"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestContainerLogWriter(t *testing.T) {
+	const secret = "container-log-secret"
+	internal.RegisterSecret(secret)
+	tests := []struct {
+		name   string
+		chunks []string
+		want   string
+	}{
+		{
+			name:   "whole lines",
+			chunks: []string{"one " + secret + "\ntwo\n"},
+			want:   "one [REDACTED]\ntwo\n",
+		},
+		{
+			name:   "secret split across writes",
+			chunks: []string{"token=container-log", "-secret done\n"},
+			want:   "token=[REDACTED] done\n",
+		},
+		{
+			name:   "partial line is flushed",
+			chunks: []string{"first\nsecond " + secret[:5], secret[5:]},
+			want:   "first\nsecond [REDACTED]\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			w := &containerLogWriter{Writer: &out, Container: &container.InspectResponse{}}
+			for _, chunk := range tt.chunks {
+				if n, err := w.Write([]byte(chunk)); err != nil || n != len(chunk) {
+					t.Fatalf("Write(%q) = %d, %v", chunk, n, err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Fatalf("got %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestContainerLogWriterLongLine(t *testing.T) {
+	var out bytes.Buffer
+	w := &containerLogWriter{Writer: &out, Container: &container.InspectResponse{}}
+	if _, err := w.Write([]byte(strings.Repeat("x", containerLogMaxLine+1))); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != containerLogMaxLine+2 {
+		t.Fatalf("long line was held back, wrote %d bytes", out.Len())
+	}
+}
@@ -0,0 +1,244 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/caner-cetin/oblivion/internal"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-connections/nat"
+	"github.com/fatih/color"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	postgresExporterInternalPort  = "9187"
+	pgbouncerExporterInternalPort = "9127"
+	redisExporterInternalPort     = "9121"
+	// dragonfly always listens on 6379 inside its container, [Dragonfly].port is the host side
+	redisInternalPort = "6379"
+	// pgbouncer listens on 6432 inside its container, [Postgres.Bouncer].port is the host side
+	pgbouncerInternalPort = "6432"
+
+	monitoringUsernameRef = "/Postgres/Monitoring/username"
+	monitoringPasswordRef = "/Postgres/Monitoring/password"
+)
+
+// exportersUp starts an exporter for every database service oblivion manages that is present on this host.
+// Services that are not running are skipped with a warning, so the observer stack can come up before them.
+func (a *AppCtx) exportersUp() error {
+	primary, err := a.containerRunning(cfg.Postgres.Primary.Name)
+	if err != nil {
+		return err
+	}
+	bouncer, err := a.containerRunning(cfg.Postgres.Bouncer.Name)
+	if err != nil {
+		return err
+	}
+	if primary || bouncer {
+		credentials, err := a.loadPostgresSecrets(internal.Ptr(monitoringUsernameRef), internal.Ptr(monitoringPasswordRef))
+		if err != nil {
+			return err
+		}
+		if primary {
+			if err := credentials.ensureMonitoringRole(a); err != nil {
+				return err
+			}
+			if err := credentials.postgresExporterUp(a); err != nil {
+				return err
+			}
+		} else {
+			log.Warn().Str("container", cfg.Postgres.Primary.Name).Msg("postgres primary is not running, skipping postgres exporter")
+		}
+		if bouncer {
+			if err := credentials.checkBouncerStatsUser(a); err != nil {
+				log.Warn().Err(err).Msg("skipping pgbouncer exporter")
+			} else if err := credentials.pgbouncerExporterUp(a); err != nil {
+				return err
+			}
+		} else {
+			log.Warn().Str("container", cfg.Postgres.Bouncer.Name).Msg("pgbouncer is not running, skipping pgbouncer exporter")
+		}
+	} else {
+		log.Warn().Msg("postgres is not running, skipping postgres and pgbouncer exporters")
+	}
+	redis, err := a.containerRunning(cfg.Dragonfly.ContainerName)
+	if err != nil {
+		return err
+	}
+	if !redis {
+		log.Warn().Str("container", cfg.Dragonfly.ContainerName).Msg("redis is not running, skipping redis exporter")
+		return nil
+	}
+	return a.redisExporterUp()
+}
+
+// ensureMonitoringRole creates or updates the role the postgres exporter logs in with and grants it pg_monitor.
+// The statement is fed through stdin so the password never shows up in the exec command line.
+func (c *postgresCredentials) ensureMonitoringRole(app *AppCtx) error {
+	if c.Role == nil {
+		return fmt.Errorf("monitoring role credentials are not loaded")
+	}
+	user := quoteIdentifier(c.Role.User)
+	password := quoteLiteral(c.Role.Password)
+	sql := fmt.Sprintf(`DO $$
+BEGIN
+	IF NOT EXISTS (SELECT FROM pg_catalog.pg_roles WHERE rolname = %s) THEN
+		CREATE ROLE %s LOGIN PASSWORD %s;
+	ELSE
+		ALTER ROLE %s WITH LOGIN PASSWORD %s;
+	END IF;
+END
+$$;
+GRANT pg_monitor TO %s;
+`, quoteLiteral(c.Role.User), user, password, user, password, user)
+	app.Spinner.Prefix = "creating postgres monitoring role"
+	_, err := app.execInContainer(cfg.Postgres.Primary.Name,
+		[]string{"psql", "-v", "ON_ERROR_STOP=1", "-q", "-U", c.Postgres.User, "-d", cfg.Postgres.DB},
+		[]string{"PGPASSWORD=" + c.Postgres.Password},
+		strings.NewReader(sql),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create postgres monitoring role: %w", err)
+	}
+	return nil
+}
+
+func (c *postgresCredentials) postgresExporterUp(app *AppCtx) error {
+	return app.exporterUp(
+		"postgres exporter",
+		cfg.Observer.ContainerNames.PostgresExporter,
+		cfg.Observer.Images.PostgresExporter,
+		postgresExporterInternalPort,
+		cfg.Observer.Ports.PostgresExporter,
+		[]string{
+			fmt.Sprintf("DATA_SOURCE_URI=%s:5432/%s?sslmode=disable", cfg.Postgres.Primary.Name, cfg.Postgres.DB),
+			"DATA_SOURCE_USER=" + c.Role.User,
+			"DATA_SOURCE_PASS=" + c.Role.Password,
+		},
+	)
+}
+
+func (c *postgresCredentials) pgbouncerExporterUp(app *AppCtx) error {
+	connection := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.Bouncer.User, c.Bouncer.Password),
+		Host:     containerTarget(cfg.Postgres.Bouncer.Name, pgbouncerInternalPort),
+		Path:     "/pgbouncer",
+		RawQuery: "sslmode=disable",
+	}
+	internal.RegisterSecret(connection.String())
+	return app.exporterUp(
+		"pgbouncer exporter",
+		cfg.Observer.ContainerNames.PgbouncerExporter,
+		cfg.Observer.Images.PgbouncerExporter,
+		pgbouncerExporterInternalPort,
+		cfg.Observer.Ports.PgbouncerExporter,
+		[]string{"PGBOUNCER_EXPORTER_CONNECTION_STRING=" + connection.String()},
+	)
+}
+
+func (a *AppCtx) redisExporterUp() error {
+	password, err := a.resolveSecret("/Redis/password")
+	if err != nil {
+		return fmt.Errorf("failed to get redis password: %w", err)
+	}
+	return a.exporterUp(
+		"redis exporter",
+		cfg.Observer.ContainerNames.RedisExporter,
+		cfg.Observer.Images.RedisExporter,
+		redisExporterInternalPort,
+		cfg.Observer.Ports.RedisExporter,
+		[]string{
+			"REDIS_ADDR=redis://" + containerTarget(cfg.Dragonfly.ContainerName, redisInternalPort),
+			"REDIS_PASSWORD=" + password,
+		},
+	)
+}
+
+// exporters only differ in image, port and environment, they all sit between the database and grafana networks
+func (a *AppCtx) exporterUp(label string, name string, image string, internalPort string, hostPort string, env []string) error {
+	if err := a.pullImageIfNotExists(image); err != nil {
+		return fmt.Errorf("failed to pull %s image: %w", label, err)
+	}
+	exists, err := a.containerExists(name)
+	if err != nil {
+		return fmt.Errorf("failed to check existence of %s: %w", label, err)
+	}
+	if exists {
+		color.Cyan("%s running", label)
+		return nil
+	}
+	port := nat.Port(internalPort + "/tcp")
+	component := strings.ReplaceAll(label, " ", "-")
+	hostConfig := &container.HostConfig{
+		RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyAlways},
+		LogConfig:     managedLogConfig("observer", component),
+	}
+	if hostPort != "" {
+		hostConfig.PortBindings = nat.PortMap{port: []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: hostPort}}}
+	}
+	a.Spinner.Prefix = fmt.Sprintf("creating %s", label)
+	resp, err := a.Docker.Client.ContainerCreate(a.Context,
+		&container.Config{
+			Image:        image,
+			Labels:       managedLabels("observer", component),
+			Env:          env,
+			ExposedPorts: nat.PortSet{port: struct{}{}},
+		},
+		hostConfig,
+		&network.NetworkingConfig{
+			EndpointsConfig: a.getNetworks(cfg.Networks.DatabaseNetworkName, cfg.Networks.GrafanaNetworkName),
+		},
+		nil,
+		name,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create %s container: %w", label, internal.RedactError(err))
+	}
+	a.Spinner.Prefix = fmt.Sprintf("starting %s", label)
+	if err := a.Docker.Client.ContainerStart(a.Context, resp.ID, container.StartOptions{}); err != nil {
+		return fmt.Errorf("failed to start %s: %w", label, err)
+	}
+	return nil
+}
+
+// unlike containerExists this does not start a stopped container
+func (a *AppCtx) containerRunning(name string) (bool, error) {
+	containers, err := a.Docker.Client.ContainerList(a.Context, container.ListOptions{
+		Filters: filters.NewArgs(filters.KeyValuePair{Key: "name", Value: "^/" + name + "$"}),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to list containers: %w", err)
+	}
+	return len(containers) > 0, nil
+}
+
+// existingExporters returns which exporter containers exist. exportersUp runs before prometheus and skips the
+// exporters of services that are not running, prometheus would report those as down targets.
+func (a *AppCtx) existingExporters() (map[string]bool, error) {
+	exporters := make(map[string]bool)
+	for _, name := range []string{cfg.Observer.ContainerNames.PostgresExporter, cfg.Observer.ContainerNames.PgbouncerExporter, cfg.Observer.ContainerNames.RedisExporter} {
+		_, err := a.Docker.Client.ContainerInspect(a.Context, name)
+		if errdefs.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect %s: %w", name, err)
+		}
+		exporters[name] = true
+	}
+	return exporters, nil
+}
+
+func quoteIdentifier(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,239 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os/user"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/caner-cetin/oblivion/internal"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-connections/nat"
+	"github.com/fatih/color"
+	v1 "github.com/moby/docker-image-spec/specs-go/v1"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// where every account finds its directory after logging in, relative to its chroot
+const sftpUploadDir = "static"
+
+var (
+	staticFTPUpCmd = &cobra.Command{
+		Use:   "up",
+		Short: "create or update the sftp server the [[Static.FTP.Accounts]] upload with",
+		Run:   WrapCommandWithResources(staticFTPUp, ResourceConfig{Resources: []ResourceType{ResourceDocker, ResourceOnePassword}}),
+	}
+	staticFTPCmd = &cobra.Command{
+		Use: "ftp",
+	}
+)
+
+func getStaticFTPCmd() *cobra.Command {
+	staticFTPCmd.AddCommand(staticFTPUpCmd)
+	return staticFTPCmd
+}
+
+// sshd runs in the foreground and writes its pid file once it listens
+var sftp_healthcheck = &v1.HealthcheckConfig{
+	Test:     []string{"CMD", "test", "-s", "/var/run/sshd.pid"},
+	Interval: 2 * time.Second,
+	Timeout:  5 * time.Second,
+	Retries:  10,
+}
+
+// the image's own sshd_config, except that uploads are group writable like the rest of the static path
+func renderSSHDConfig() []byte {
+	return []byte(`# generated by oblivion from [Static.FTP] config, edits are overwritten on static ftp up
+Protocol 2
+HostKey /etc/ssh/ssh_host_ed25519_key
+HostKey /etc/ssh/ssh_host_rsa_key
+UseDNS no
+PermitRootLogin no
+X11Forwarding no
+AllowTcpForwarding no
+Subsystem sftp internal-sftp
+ForceCommand internal-sftp -u 0002
+ChrootDirectory %h
+`)
+}
+
+func sftpAccountDir(name string, directory string) string {
+	if directory == "" {
+		return name
+	}
+	return directory
+}
+
+// sftpFiles renders the users.conf the image creates its accounts from and the authorized keys of every account,
+// keyed by their path relative to the container's root. Accounts share the uploader's uid and gid.
+func (a *AppCtx) sftpFiles(uid string, gid string) (map[string][]byte, error) {
+	var users strings.Builder
+	files := map[string][]byte{"etc/ssh/sshd_config": renderSSHDConfig()}
+	for _, account := range cfg.Static.FTP.Accounts {
+		password := ""
+		if account.PasswordRef != "" {
+			secret, err := a.resolveSecret(account.PasswordRef)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve password of %s: %w", account.Name, err)
+			}
+			if strings.ContainsAny(secret, ":\n") {
+				return nil, fmt.Errorf("password of %s must not contain ':' or a newline, users.conf cannot hold it", account.Name)
+			}
+			password = secret
+		}
+		fmt.Fprintf(&users, "%s:%s:%s:%s\n", account.Name, password, uid, gid)
+		if len(account.AuthorizedKeys) > 0 {
+			files[path.Join("home", account.Name, ".ssh/keys/oblivion.pub")] = []byte(strings.Join(account.AuthorizedKeys, "\n") + "\n")
+		}
+	}
+	files["etc/sftp/users.conf"] = []byte(users.String())
+	return files, nil
+}
+
+func sftpMounts() []mount.Mount {
+	mounts := []mount.Mount{{
+		Type:   mount.TypeVolume,
+		Source: cfg.Static.FTP.KeysVolume,
+		Target: "/etc/ssh",
+	}}
+	for _, account := range cfg.Static.FTP.Accounts {
+		mounts = append(mounts, mount.Mount{
+			Type:   mount.TypeBind,
+			Source: filepath.Join(cfg.Static.StaticPath, sftpAccountDir(account.Name, account.Directory)),
+			Target: path.Join("/home", account.Name, sftpUploadDir),
+		})
+	}
+	return mounts
+}
+
+// sftpUpToDate reports whether the sftp container exists and whether it was created from the same image, accounts and directories
+func (a *AppCtx) sftpUpToDate(files map[string][]byte, mounts []mount.Mount) (exists bool, upToDate bool, err error) {
+	inspect, err := a.Docker.Client.ContainerInspect(a.Context, cfg.Static.FTP.ContainerName)
+	if errdefs.IsNotFound(err) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, fmt.Errorf("failed to inspect sftp container: %w", err)
+	}
+	if inspect.Config.Image != cfg.Static.FTP.Image || !slices.EqualFunc(inspect.HostConfig.Mounts, mounts, func(x mount.Mount, y mount.Mount) bool {
+		return x.Type == y.Type && x.Source == y.Source && x.Target == y.Target
+	}) {
+		return true, false, nil
+	}
+	for name, contents := range files {
+		current, err := a.readContainerFile(inspect.ID, "/"+name)
+		if err != nil || !bytes.Equal(current, contents) {
+			return true, false, nil
+		}
+	}
+	return true, true, nil
+}
+
+func staticFTPUp(cmd *cobra.Command, args []string) {
+	app := GetApp(cmd)
+	if len(cfg.Static.FTP.Accounts) == 0 {
+		color.Yellow("no [[Static.FTP.Accounts]] configured, nothing to serve")
+		return
+	}
+	uploader, err := user.Lookup(cfg.Static.UploaderUser)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to get user %s", cfg.Static.UploaderUser)
+		return
+	}
+	app.Spinner.Prefix = "resolving account passwords"
+	files, err := app.sftpFiles(uploader.Uid, uploader.Gid)
+	if err != nil {
+		log.Error().Err(internal.RedactError(err)).Send()
+		return
+	}
+	uid, gid, err := staticOwner()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	for _, account := range cfg.Static.FTP.Accounts {
+		if err := staticMkdir(filepath.Join(cfg.Static.StaticPath, sftpAccountDir(account.Name, account.Directory)), uid, gid); err != nil {
+			log.Error().Err(err).Send()
+			return
+		}
+	}
+	if err := app.pullImageIfNotExists(cfg.Static.FTP.Image); err != nil {
+		log.Error().Err(err).Msg("failed to pull sftp image")
+		return
+	}
+	mounts := sftpMounts()
+	exists, upToDate, err := app.sftpUpToDate(files, mounts)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	if exists {
+		if upToDate {
+			if _, err := app.containerExists(cfg.Static.FTP.ContainerName); err != nil {
+				log.Error().Err(err).Send()
+				return
+			}
+			app.Spinner.Stop()
+			color.Cyan("sftp server running")
+			return
+		}
+		// accounts are only created when the container starts, host keys are kept in the keys volume
+		app.Spinner.Prefix = "accounts changed, recreating sftp server"
+		if err := app.Docker.Client.ContainerRemove(app.Context, cfg.Static.FTP.ContainerName, container.RemoveOptions{Force: true}); err != nil {
+			log.Error().Err(err).Msg("failed to remove sftp container")
+			return
+		}
+	}
+	if err := app.createVolumeIfNotExists(cfg.Static.FTP.KeysVolume, nil); err != nil {
+		log.Error().Err(err).Msg("failed to create sftp keys volume")
+		return
+	}
+	app.Spinner.Prefix = "creating sftp container"
+	resp, err := app.Docker.Client.ContainerCreate(app.Context,
+		&container.Config{
+			Image:        cfg.Static.FTP.Image,
+			Labels:       managedLabels("static", "sftp"),
+			ExposedPorts: nat.PortSet{nat.Port("22/tcp"): struct{}{}},
+			Healthcheck:  sftp_healthcheck,
+		},
+		&container.HostConfig{
+			LogConfig: managedLogConfig("static", "sftp"),
+			PortBindings: nat.PortMap{
+				nat.Port("22/tcp"): []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: cfg.Static.FTP.Port}},
+			},
+			Mounts:        mounts,
+			RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyAlways},
+		},
+		nil,
+		nil,
+		cfg.Static.FTP.ContainerName,
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create sftp container")
+		return
+	}
+	if err := app.copyFilesToContainer(resp.ID, files, "/"); err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	app.Spinner.Prefix = "starting sftp server"
+	if err := app.Docker.Client.ContainerStart(app.Context, resp.ID, container.StartOptions{}); err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	if err := app.waitForContainerHealthWithConfig(resp.ID, sftp_healthcheck); err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	app.Spinner.Stop()
+	for _, account := range cfg.Static.FTP.Accounts {
+		color.Green("sftp://%s@<host>:%s/%s -> %s", account.Name, cfg.Static.FTP.Port, sftpUploadDir, filepath.Join(cfg.Static.StaticPath, sftpAccountDir(account.Name, account.Directory)))
+	}
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/caner-cetin/oblivion/internal/config"
+	"github.com/caner-cetin/oblivion/internal/secretcache"
+)
+
+// sftpTestApp serves the given secrets from a cache, so sftpFiles never needs a 1Password client
+func sftpTestApp(t *testing.T, secrets map[string]string) *AppCtx {
+	t.Helper()
+	cache, err := secretcache.Open(filepath.Join(t.TempDir(), "secrets.cache"), 0, []byte("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &AppCtx{}
+	app.Vault.Prefix = "op://Server"
+	for ref, secret := range secrets {
+		cache.Put(app.Vault.Prefix+ref, secret)
+	}
+	app.Vault.Cache = cache
+	return app
+}
+
+func TestSFTPFiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		accounts []config.FTPAccountConfig
+		secrets  map[string]string
+		users    string
+		keys     map[string]string
+		wantErr  string
+	}{
+		{
+			name:  "no accounts",
+			users: "",
+		},
+		{
+			name: "password and key accounts",
+			accounts: []config.FTPAccountConfig{
+				{Name: "alice", PasswordRef: "/SFTP/alice"},
+				{Name: "bob", AuthorizedKeys: []string{"ssh-ed25519 AAAA bob@laptop", "ssh-ed25519 BBBB bob@desktop"}},
+			},
+			secrets: map[string]string{"/SFTP/alice": "hunter2"},
+			users:   "alice:hunter2:1001:1002\nbob::1001:1002\n",
+			keys: map[string]string{
+				"home/bob/.ssh/keys/oblivion.pub": "ssh-ed25519 AAAA bob@laptop\nssh-ed25519 BBBB bob@desktop\n",
+			},
+		},
+		{
+			name:     "password with a colon",
+			accounts: []config.FTPAccountConfig{{Name: "alice", PasswordRef: "/SFTP/alice"}},
+			secrets:  map[string]string{"/SFTP/alice": "a:b"},
+			wantErr:  "must not contain ':'",
+		},
+		{
+			name:     "password with a newline",
+			accounts: []config.FTPAccountConfig{{Name: "alice", PasswordRef: "/SFTP/alice"}},
+			secrets:  map[string]string{"/SFTP/alice": "a\nb"},
+			wantErr:  "must not contain ':'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestConfig(t, func(c *config.Root) { c.Static.FTP.Accounts = tt.accounts })
+			files, err := sftpTestApp(t, tt.secrets).sftpFiles("1001", "1002")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("sftpFiles() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(files["etc/sftp/users.conf"]); got != tt.users {
+				t.Errorf("users.conf = %q, want %q", got, tt.users)
+			}
+			if _, ok := files["etc/ssh/sshd_config"]; !ok {
+				t.Error("sshd_config is not rendered")
+			}
+			var keyFiles []string
+			for name := range files {
+				if strings.HasPrefix(name, "home/") {
+					keyFiles = append(keyFiles, name)
+				}
+			}
+			if len(keyFiles) != len(tt.keys) {
+				t.Errorf("key files = %v, want %d", keyFiles, len(tt.keys))
+			}
+			for name, want := range tt.keys {
+				if got := string(files[name]); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSFTPAccountDir(t *testing.T) {
+	if got := sftpAccountDir("alice", ""); got != "alice" {
+		t.Errorf("sftpAccountDir(alice, \"\") = %q, want alice", got)
+	}
+	if got := sftpAccountDir("alice", "shared/uploads"); got != "shared/uploads" {
+		t.Errorf("sftpAccountDir(alice, shared/uploads) = %q, want shared/uploads", got)
+	}
+	if !slices.Contains(strings.Split(string(renderSSHDConfig()), "\n"), "ChrootDirectory %h") {
+		t.Error("sshd_config does not chroot accounts to their home")
+	}
+}
@@ -0,0 +1,380 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/caner-cetin/oblivion/internal"
+	"github.com/fatih/color"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// relative to the grafana config directory, both in the embedded tree and in a [Observer.Binds] grafana directory
+const grafanaDashboardsDir = "provisioning/dashboards"
+
+var (
+	observerDashboardsExportCmd = &cobra.Command{
+		Use:   "export",
+		Short: "write every dashboard in the running grafana to the provisioning directory",
+		Run:   WrapCommandWithResources(observerDashboardsExport, ResourceConfig{Resources: []ResourceType{ResourceOnePassword}}),
+	}
+	observerDashboardsImportCmd = &cobra.Command{
+		Use:   "import",
+		Short: "push the dashboards in the provisioning directory to the running grafana",
+		Run:   WrapCommandWithResources(observerDashboardsImport, ResourceConfig{Resources: []ResourceType{ResourceOnePassword}}),
+	}
+	observerDashboardsCmd = &cobra.Command{
+		Use: "dashboards",
+	}
+	dashboardsDir string
+)
+
+func getObserverDashboardsCmd() *cobra.Command {
+	observerDashboardsCmd.PersistentFlags().StringVar(&dashboardsDir, "dir", "", "dashboards directory, defaults to the grafana bind if set, otherwise cmd/config/grafana/"+grafanaDashboardsDir+" of the checkout")
+	observerDashboardsCmd.AddCommand(observerDashboardsExportCmd)
+	observerDashboardsCmd.AddCommand(observerDashboardsImportCmd)
+	return observerDashboardsCmd
+}
+
+type grafanaDatasources struct {
+	APIVersion  int                 `yaml:"apiVersion"`
+	Datasources []grafanaDatasource `yaml:"datasources"`
+}
+
+type grafanaDatasource struct {
+	Name      string         `yaml:"name"`
+	UID       string         `yaml:"uid"`
+	Type      string         `yaml:"type"`
+	Access    string         `yaml:"access"`
+	OrgID     int            `yaml:"orgId"`
+	URL       string         `yaml:"url"`
+	IsDefault bool           `yaml:"isDefault"`
+	Editable  bool           `yaml:"editable"`
+	JSONData  map[string]any `yaml:"jsonData,omitempty"`
+}
+
+// renderGrafanaDatasources generates the datasource provisioning file for the enabled components, every url points at a container oblivion manages.
+func renderGrafanaDatasources() ([]byte, error) {
+	datasources := grafanaDatasources{APIVersion: 1, Datasources: []grafanaDatasource{}}
+	if cfg.Observer.Enabled.Prometheus {
+		datasources.Datasources = append(datasources.Datasources, grafanaDatasource{
+			Name:      "Prometheus",
+			UID:       "prometheus",
+			Type:      "prometheus",
+			Access:    "proxy",
+			OrgID:     1,
+			URL:       "http://" + containerTarget(cfg.Observer.ContainerNames.Prometheus, prometheusInternalPort),
+			IsDefault: true,
+			JSONData:  map[string]any{"timeInterval": cfg.Observer.Prometheus.ScrapeInterval},
+		})
+	}
+	if cfg.Observer.Enabled.Loki {
+		datasources.Datasources = append(datasources.Datasources, grafanaDatasource{
+			Name:   "Loki",
+			UID:    "loki",
+			Type:   "loki",
+			Access: "proxy",
+			OrgID:  1,
+			URL:    "http://" + containerTarget(cfg.Observer.ContainerNames.Loki, lokiInternalPort),
+		})
+	}
+	if cfg.Observer.Enabled.Alertmanager {
+		datasources.Datasources = append(datasources.Datasources, grafanaDatasource{
+			Name:     "Alertmanager",
+			UID:      "alertmanager",
+			Type:     "alertmanager",
+			Access:   "proxy",
+			OrgID:    1,
+			URL:      "http://" + containerTarget(cfg.Observer.ContainerNames.Alertmanager, alertmanagerInternalPort),
+			JSONData: map[string]any{"implementation": "prometheus"},
+		})
+	}
+	out, err := internal.MarshalYAML(&datasources)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal grafana datasources: %w", err)
+	}
+	return append([]byte("# generated by oblivion from [Observer] config, edits are overwritten on observer up\n"), out...), nil
+}
+
+// grafanaFiles returns the generated files rendered over the embedded config/grafana directory
+func grafanaFiles() (map[string][]byte, error) {
+	datasources, err := renderGrafanaDatasources()
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{"provisioning/datasources/datasource.yml": datasources}, nil
+}
+
+func grafanaURL() string {
+	return "http://" + net.JoinHostPort("localhost", cfg.Observer.Ports.Grafana)
+}
+
+// grafanaClient talks to the grafana HTTP API as the admin user
+type grafanaClient struct {
+	baseURL  string
+	user     string
+	password string
+	http     http.Client
+}
+
+func (a *AppCtx) newGrafanaClient() (*grafanaClient, error) {
+	user, err := a.resolveSecret("/Grafana/Admin/Username")
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve grafana admin username: %w", err)
+	}
+	password, err := a.resolveSecret("/Grafana/Admin/Password")
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve grafana admin password: %w", err)
+	}
+	return &grafanaClient{
+		baseURL:  grafanaURL(),
+		user:     user,
+		password: password,
+		http:     http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// do sends body as JSON if not nil and decodes the response into out if not nil
+func (g *grafanaClient) do(a *AppCtx, method string, path string, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode grafana request: %w", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(a.Context, method, g.baseURL+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create grafana request: %w", err)
+	}
+	req.SetBasicAuth(g.user, g.password)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := g.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach grafana: %w", err)
+	}
+	defer internal.CloseReader(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("grafana %s %s returned %s: %s", method, path, resp.Status, apiErr.Message)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode grafana response of %s: %w", path, err)
+	}
+	return nil
+}
+
+// asks grafana to re-read the provisioning directories after they were rendered into a running container
+func (a *AppCtx) reloadGrafanaProvisioning() error {
+	grafana, err := a.newGrafanaClient()
+	if err != nil {
+		return err
+	}
+	for _, kind := range []string{"datasources", "dashboards"} {
+		if err := grafana.do(a, http.MethodPost, "/api/admin/provisioning/"+kind+"/reload", nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func resolveDashboardsDir() string {
+	if dashboardsDir != "" {
+		return dashboardsDir
+	}
+	if cfg.Observer.Binds.Grafana != "" {
+		return filepath.Join(cfg.Observer.Binds.Grafana, grafanaDashboardsDir)
+	}
+	return filepath.Join("cmd", "config", "grafana", grafanaDashboardsDir)
+}
+
+type grafanaSearchHit struct {
+	UID         string `json:"uid"`
+	Title       string `json:"title"`
+	FolderUID   string `json:"folderUid"`
+	FolderTitle string `json:"folderTitle"`
+}
+
+type grafanaFolder struct {
+	UID   string `json:"uid"`
+	Title string `json:"title"`
+}
+
+// dashboard files under dir keyed by the uid of the dashboard they hold
+func dashboardFilesByUID(dir string) (map[string]string, error) {
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".json" {
+			return err
+		}
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var dashboard struct {
+			UID string `json:"uid"`
+		}
+		if err := json.Unmarshal(contents, &dashboard); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		if dashboard.UID != "" {
+			files[dashboard.UID] = path
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read dashboards in %s: %w", dir, err)
+	}
+	return files, nil
+}
+
+// file and directory names are dashboard and folder titles, the characters filesystems trip over are replaced
+func sanitizeFileName(title string) string {
+	replacer := strings.NewReplacer("/", "-", "\\", "-", ":", "-", "*", "-", "?", "-", "\"", "", "<", "", ">", "", "|", "-")
+	return strings.TrimSpace(replacer.Replace(title))
+}
+
+func observerDashboardsExport(cmd *cobra.Command, args []string) {
+	app := GetApp(cmd)
+	grafana, err := app.newGrafanaClient()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	dir := resolveDashboardsDir()
+	existing, err := dashboardFilesByUID(dir)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	app.Spinner.Prefix = "listing dashboards"
+	var hits []grafanaSearchHit
+	if err := grafana.do(&app, http.MethodGet, "/api/search?type=dash-db&limit=5000", nil, &hits); err != nil {
+		log.Error().Err(err).Msg("failed to list dashboards")
+		return
+	}
+	for _, hit := range hits {
+		app.Spinner.Prefix = fmt.Sprintf("exporting %s", hit.Title)
+		var full struct {
+			Dashboard map[string]any `json:"dashboard"`
+		}
+		if err := grafana.do(&app, http.MethodGet, "/api/dashboards/uid/"+url.PathEscape(hit.UID), nil, &full); err != nil {
+			log.Error().Err(err).Msgf("failed to export %s", hit.Title)
+			return
+		}
+		// ids and versions are per grafana instance, keeping them makes every export a diff
+		full.Dashboard["id"] = nil
+		delete(full.Dashboard, "version")
+		contents, err := json.MarshalIndent(full.Dashboard, "", "  ")
+		if err != nil {
+			log.Error().Err(err).Msgf("failed to encode %s", hit.Title)
+			return
+		}
+		path, ok := existing[hit.UID]
+		if !ok {
+			path = filepath.Join(dir, sanitizeFileName(hit.Title)+".json")
+			if hit.FolderTitle != "" && hit.FolderTitle != "General" {
+				path = filepath.Join(dir, sanitizeFileName(hit.FolderTitle), sanitizeFileName(hit.Title)+".json")
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			log.Error().Err(err).Msg("failed to create dashboard directory")
+			return
+		}
+		if err := os.WriteFile(path, append(contents, '\n'), 0644); err != nil {
+			log.Error().Err(err).Msgf("failed to write %s", path)
+			return
+		}
+		color.Green("%s -> %s", hit.Title, path)
+	}
+	if dashboardsDir == "" && cfg.Observer.Binds.Grafana == "" {
+		color.Cyan("dashboards are embedded into the binary, rebuild oblivion to deploy them on the next observer up")
+	}
+}
+
+func observerDashboardsImport(cmd *cobra.Command, args []string) {
+	app := GetApp(cmd)
+	grafana, err := app.newGrafanaClient()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	dir := resolveDashboardsDir()
+	var folders []grafanaFolder
+	if err := grafana.do(&app, http.MethodGet, "/api/folders?limit=1000", nil, &folders); err != nil {
+		log.Error().Err(err).Msg("failed to list folders")
+		return
+	}
+	folderUIDs := make(map[string]string, len(folders))
+	for _, f := range folders {
+		folderUIDs[f.Title] = f.UID
+	}
+	imported := 0
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".json" {
+			return err
+		}
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var dashboard map[string]any
+		if err := json.Unmarshal(contents, &dashboard); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		dashboard["id"] = nil
+		// one directory level below dir is a folder, like grafana's foldersFromFilesStructure
+		folderUID := ""
+		if rel, _ := filepath.Rel(dir, filepath.Dir(path)); rel != "." {
+			title := strings.Split(rel, string(filepath.Separator))[0]
+			uid, ok := folderUIDs[title]
+			if !ok {
+				var created grafanaFolder
+				if err := grafana.do(&app, http.MethodPost, "/api/folders", map[string]string{"title": title}, &created); err != nil {
+					return fmt.Errorf("failed to create folder %s: %w", title, err)
+				}
+				uid = created.UID
+				folderUIDs[title] = uid
+			}
+			folderUID = uid
+		}
+		app.Spinner.Prefix = fmt.Sprintf("importing %s", path)
+		body := map[string]any{
+			"dashboard": dashboard,
+			"folderUid": folderUID,
+			"overwrite": true,
+			"message":   "imported by oblivion",
+		}
+		if err := grafana.do(&app, http.MethodPost, "/api/dashboards/db", body, nil); err != nil {
+			return fmt.Errorf("failed to import %s: %w", path, err)
+		}
+		imported++
+		return nil
+	})
+	if err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	color.Green("imported %d dashboard(s) from %s", imported, dir)
+}
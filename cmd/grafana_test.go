@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/caner-cetin/oblivion/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRenderGrafanaDatasources(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *config.Root)
+		want   []string
+	}{
+		{
+			name:   "defaults",
+			modify: func(c *config.Root) {},
+			want:   []string{"prometheus", "loki", "alertmanager"},
+		},
+		{
+			name:   "loki disabled",
+			modify: func(c *config.Root) { c.Observer.Enabled.Loki = false },
+			want:   []string{"prometheus", "alertmanager"},
+		},
+		{
+			name: "nothing to query",
+			modify: func(c *config.Root) {
+				c.Observer.Enabled.Prometheus = false
+				c.Observer.Enabled.Loki = false
+				c.Observer.Enabled.Alertmanager = false
+			},
+			want: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestConfig(t, tt.modify)
+			out, err := renderGrafanaDatasources()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got grafanaDatasources
+			if err := yaml.Unmarshal(out, &got); err != nil {
+				t.Fatalf("rendered datasources are not valid yaml: %v\n%s", err, out)
+			}
+			uids := []string{}
+			for _, ds := range got.Datasources {
+				uids = append(uids, ds.UID)
+			}
+			if !slices.Equal(uids, tt.want) {
+				t.Errorf("datasources = %v, want %v", uids, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderGrafanaDatasourcesURLs(t *testing.T) {
+	setTestConfig(t, func(c *config.Root) {
+		c.Observer.ContainerNames.Prometheus = "metrics"
+		c.Observer.Prometheus.ScrapeInterval = "30s"
+	})
+	out, err := renderGrafanaDatasources()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got grafanaDatasources
+	if err := yaml.Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	defaults := 0
+	for _, ds := range got.Datasources {
+		if ds.IsDefault {
+			defaults++
+		}
+		if ds.UID != "prometheus" {
+			continue
+		}
+		if want := "http://metrics:" + prometheusInternalPort; ds.URL != want {
+			t.Errorf("prometheus url = %q, want %q", ds.URL, want)
+		}
+		if ds.JSONData["timeInterval"] != "30s" {
+			t.Errorf("prometheus timeInterval = %v, want 30s", ds.JSONData["timeInterval"])
+		}
+	}
+	if defaults != 1 {
+		t.Errorf("%d default datasources, want 1", defaults)
+	}
+}
+
+func TestDashboardFilesByUID(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, contents string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	hosts := write("hosts.json", `{"uid":"hosts","title":"Hosts"}`)
+	postgres := write("Databases/Postgres.json", `{"uid":"pg","title":"Postgres"}`)
+	write("Databases/no-uid.json", `{"title":"Scratch"}`)
+	write("README.md", `not a dashboard`)
+
+	got, err := dashboardFilesByUID(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"hosts": hosts, "pg": postgres}
+	if len(got) != len(want) {
+		t.Fatalf("dashboardFilesByUID() = %v, want %v", got, want)
+	}
+	for uid, path := range want {
+		if got[uid] != path {
+			t.Errorf("dashboardFilesByUID()[%q] = %q, want %q", uid, got[uid], path)
+		}
+	}
+
+	t.Run("missing dir", func(t *testing.T) {
+		got, err := dashboardFilesByUID(filepath.Join(dir, "missing"))
+		if err != nil || len(got) != 0 {
+			t.Errorf("dashboardFilesByUID(missing) = %v, %v, want an empty map", got, err)
+		}
+	})
+	t.Run("invalid json", func(t *testing.T) {
+		path := write("broken.json", `{"uid":`)
+		if _, err := dashboardFilesByUID(dir); err == nil || !strings.Contains(err.Error(), path) {
+			t.Errorf("dashboardFilesByUID() = %v, want an error naming %s", err, path)
+		}
+	})
+}
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{title: "Node Exporter Full", want: "Node Exporter Full"},
+		{title: "Postgres / PgBouncer", want: "Postgres - PgBouncer"},
+		{title: `C:\dashboards`, want: "C--dashboards"},
+		{title: `"quoted" <tags>`, want: "quoted tags"},
+		{title: "what? *|*", want: "what- ---"},
+		{title: "  padded  ", want: "padded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			if got := sanitizeFileName(tt.title); got != tt.want {
+				t.Errorf("sanitizeFileName(%q) = %q, want %q", tt.title, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,11 +1,17 @@
 package cmd
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
 	"github.com/docker/go-connections/nat"
+	"github.com/fatih/color"
+	v1 "github.com/moby/docker-image-spec/specs-go/v1"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
@@ -17,7 +23,7 @@ var (
 	}
 	kumaUpCmd = &cobra.Command{
 		Use: "up",
-		Run: WrapCommandWithResources(kumaUp, ResourceConfig{Resources: []ResourceType{ResourceDocker}, Networks: []Network{NetworkDatabase, NetworkUptime}}),
+		Run: WrapCommandWithResources(kumaUp, ResourceConfig{Resources: []ResourceType{ResourceDocker}, Networks: []Network{NetworkDatabase, NetworkUptime, NetworkGrafana}}),
 	}
 	kumaCmd = &cobra.Command{
 		Use: "kuma",
@@ -27,65 +33,138 @@ var (
 func getKumaCmd() *cobra.Command {
 	kumaCmd.AddCommand(kumaDownCmd)
 	kumaCmd.AddCommand(kumaUpCmd)
+	kumaSyncCmd.Flags().BoolVar(&kumaSyncDryRun, "dry-run", false, "list what would change without touching any monitor")
+	kumaCmd.AddCommand(kumaSyncCmd)
+	kumaCmd.AddCommand(kumaBackupCmd)
+	kumaCmd.AddCommand(kumaRestoreCmd)
 	return kumaCmd
 }
 
+// kumaMounts adds the docker socket when [Kuma] docker_daemon names one. Kuma only lists and inspects containers,
+// but the socket accepts every call of the docker API and read-only does not change that, whoever controls kuma
+// controls the host. A socket proxy limited to GET /containers is the safer choice.
+func kumaMounts() []mount.Mount {
+	mounts := []mount.Mount{
+		{
+			Type:   mount.TypeVolume,
+			Source: cfg.Kuma.DataVolume,
+			Target: "/app/data",
+		},
+	}
+	if socket, ok := strings.CutPrefix(cfg.Kuma.DockerDaemon, "unix://"); ok {
+		mounts = append(mounts, mount.Mount{
+			Type:     mount.TypeBind,
+			Source:   socket,
+			Target:   kumaDockerSocket,
+			ReadOnly: true,
+		})
+	}
+	return mounts
+}
+
+// extra/healthcheck ships with the image and asks kuma's own web server, the first start migrates the database
+var kumaHealthcheck = &v1.HealthcheckConfig{
+	Test:        []string{"CMD", "extra/healthcheck"},
+	Interval:    5 * time.Second,
+	Timeout:     10 * time.Second,
+	StartPeriod: 30 * time.Second,
+	Retries:     36,
+}
+
 func kumaUp(cmd *cobra.Command, args []string) {
 	app := GetApp(cmd)
-	app.Spinner.Prefix = "creating container"
-	app.Spinner.Start()
-	resp, err := app.Docker.Client.ContainerCreate(cmd.Context(),
+	created, err := app.startKuma()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	app.Spinner.Stop()
+	if !created {
+		color.Cyan("uptime kuma running")
+		return
+	}
+	color.Green("uptime kuma is up on port %s", cfg.Kuma.Port)
+}
+
+// startKuma creates and starts the kuma container unless it exists, and waits until it is healthy either way.
+// Reports whether the container was created.
+func (a *AppCtx) startKuma() (bool, error) {
+	exists, err := a.containerExists(cfg.Kuma.ContainerName)
+	if err != nil {
+		return false, fmt.Errorf("failed to check existence of kuma container: %w", err)
+	}
+	if exists {
+		return false, a.waitForKuma(cfg.Kuma.ContainerName)
+	}
+	if err := a.pullImageIfNotExists(cfg.Kuma.ImageName); err != nil {
+		return false, fmt.Errorf("failed to pull kuma image: %w", err)
+	}
+	if err := a.createVolumeIfNotExists(cfg.Kuma.DataVolume, nil); err != nil {
+		return false, fmt.Errorf("failed to create kuma data volume: %w", err)
+	}
+	a.Spinner.Prefix = "creating kuma container"
+	a.Spinner.Start()
+	resp, err := a.Docker.Client.ContainerCreate(a.Context,
 		&container.Config{
 			AttachStdout: true,
 			AttachStderr: true,
 			AttachStdin:  false,
 			OpenStdin:    false,
 			Image:        cfg.Kuma.ImageName,
+			Labels:       managedLabels("kuma", "uptime-kuma"),
 			ExposedPorts: nat.PortSet{
 				nat.Port("3001/tcp"): struct{}{},
 			},
+			Healthcheck: kumaHealthcheck,
 		},
 		&container.HostConfig{
+			LogConfig:     managedLogConfig("kuma", "uptime-kuma"),
 			RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyAlways},
 			PortBindings:  nat.PortMap{nat.Port("3001/tcp"): []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: cfg.Kuma.Port}}},
-			Mounts: []mount.Mount{
-				{
-					Type:   mount.TypeVolume,
-					Source: cfg.Kuma.DataVolume,
-					Target: "/app/data",
-				},
-			},
+			Mounts:        kumaMounts(),
 		},
 		&network.NetworkingConfig{
-			EndpointsConfig: app.getNetworks(cfg.Networks.DatabaseNetworkName, cfg.Networks.UptimeNetworkName),
+			// kuma sync monitors postgres and redis on the database network and the observer stack on the grafana network
+			EndpointsConfig: a.getNetworks(cfg.Networks.DatabaseNetworkName, cfg.Networks.UptimeNetworkName, cfg.Networks.GrafanaNetworkName),
 		},
 		nil,
 		cfg.Kuma.ContainerName,
 	)
 	if err != nil {
-		app.Spinner.Stop()
-		log.Error().Err(err).Send()
-		return
+		return false, fmt.Errorf("failed to create kuma container: %w", err)
 	}
-	app.Spinner.Prefix = "starting container"
-	if err := app.Docker.Client.ContainerStart(app.Context, resp.ID, container.StartOptions{}); err != nil {
-		app.Spinner.Start()
-		log.Error().Err(err).Send()
-		return
+	a.Spinner.Prefix = "starting kuma container"
+	if err := a.Docker.Client.ContainerStart(a.Context, resp.ID, container.StartOptions{}); err != nil {
+		return false, fmt.Errorf("failed to start kuma container: %w", err)
 	}
-	go app.spawnLogs(resp.ID)
-	app.Spinner.Stop()
+	cancel := a.spawnLogs(resp.ID)
+	defer cancel()
+	return true, a.waitForKuma(resp.ID)
 }
 
-func kumaDown(cmd *cobra.Command, args []string) {
-	app := GetApp(cmd)
-	sum, err := app.Docker.Client.ContainerList(app.Context, container.ListOptions{Filters: filters.NewArgs(filters.KeyValuePair{Key: "name", Value: cfg.Kuma.ContainerName})})
+// waitForKuma blocks until the container reports healthy, containers created before the healthcheck was added are only reported
+func (a *AppCtx) waitForKuma(containerID string) error {
+	inspect, err := a.Docker.Client.ContainerInspect(a.Context, containerID)
 	if err != nil {
-		log.Error().Err(err).Send()
-		return
+		return fmt.Errorf("failed to inspect kuma container: %w", err)
+	}
+	if inspect.Config.Healthcheck == nil || len(inspect.Config.Healthcheck.Test) == 0 {
+		log.Warn().Str("container", cfg.Kuma.ContainerName).Msg("container has no healthcheck, remove it and run kuma up to wait for it")
+		return nil
 	}
+	if err := a.waitForContainerHealthWithConfig(inspect.ID, kumaHealthcheck); err != nil {
+		return fmt.Errorf("uptime kuma is not healthy: %w", err)
+	}
+	return nil
+}
 
-	if err := app.Docker.Client.ContainerStop(app.Context, sum[0].ID, container.StopOptions{}); err != nil {
+func kumaDown(cmd *cobra.Command, args []string) {
+	app := GetApp(cmd)
+	if err := app.Docker.Client.ContainerStop(app.Context, cfg.Kuma.ContainerName, container.StopOptions{}); err != nil {
+		if errdefs.IsNotFound(err) {
+			color.Cyan("uptime kuma is not running")
+			return
+		}
 		log.Error().Err(err).Send()
 		return
 	}
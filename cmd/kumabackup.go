@@ -0,0 +1,267 @@
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/caner-cetin/oblivion/internal"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/errdefs"
+	"github.com/fatih/color"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// where kumaMounts mounts the data volume, archives hold its contents under data/
+	kumaDataDir = "/app/data"
+	// the sqlite database every restore must contain
+	kumaDatabase = "data/kuma.db"
+	// kuma closes the database on SIGTERM, give it longer than docker's default to finish
+	kumaStopTimeout = 30
+)
+
+var (
+	kumaBackupCmd = &cobra.Command{
+		Use:   "backup [dir]",
+		Short: "stop uptime kuma, archive its data volume to dir/kuma-<timestamp>.tar.gz and start it again",
+		Args:  cobra.MaximumNArgs(1),
+		Run:   WrapCommandWithResources(kumaBackup, ResourceConfig{Resources: []ResourceType{ResourceDocker}}),
+	}
+	kumaRestoreCmd = &cobra.Command{
+		Use:   "restore <archive>",
+		Short: "replace the uptime kuma data volume with a backup, the current data is backed up next to the archive first",
+		Args:  cobra.ExactArgs(1),
+		Run:   WrapCommandWithResources(kumaRestore, ResourceConfig{Resources: []ResourceType{ResourceDocker}, Networks: []Network{NetworkDatabase, NetworkUptime, NetworkGrafana}}),
+	}
+)
+
+// stopKuma stops the kuma container so the sqlite database is closed, reports whether it was running
+func (a *AppCtx) stopKuma() (bool, error) {
+	inspect, err := a.Docker.Client.ContainerInspect(a.Context, cfg.Kuma.ContainerName)
+	if errdefs.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect kuma container: %w", err)
+	}
+	if !inspect.State.Running {
+		return false, nil
+	}
+	a.Spinner.Prefix = "stopping uptime kuma"
+	timeout := kumaStopTimeout
+	if err := a.Docker.Client.ContainerStop(a.Context, inspect.ID, container.StopOptions{Timeout: &timeout}); err != nil {
+		return false, fmt.Errorf("failed to stop kuma container: %w", err)
+	}
+	return true, nil
+}
+
+// withKumaVolume runs fn against a container that mounts the data volume like kuma does but is never started,
+// docker cp reads and writes the volume through it whether kuma exists or not
+func (a *AppCtx) withKumaVolume(fn func(containerID string) error) error {
+	if err := a.pullImageIfNotExists(cfg.Kuma.ImageName); err != nil {
+		return fmt.Errorf("failed to pull kuma image: %w", err)
+	}
+	resp, err := a.Docker.Client.ContainerCreate(a.Context,
+		&container.Config{Image: cfg.Kuma.ImageName},
+		&container.HostConfig{
+			Mounts: []mount.Mount{{Type: mount.TypeVolume, Source: cfg.Kuma.DataVolume, Target: kumaDataDir}},
+		},
+		nil, nil, "")
+	if err != nil {
+		return fmt.Errorf("failed to create container for the kuma data volume: %w", err)
+	}
+	defer func() {
+		if err := a.Docker.Client.ContainerRemove(context.Background(), resp.ID, container.RemoveOptions{Force: true}); err != nil {
+			log.Warn().Err(err).Str("container", resp.ID).Msg("failed to remove throwaway container")
+		}
+	}()
+	return fn(resp.ID)
+}
+
+// archiveKumaVolume writes the data volume to dir/kuma-<timestamp>.tar.gz and returns its path.
+// Kuma must be stopped, a running kuma may be halfway through writing the database.
+func (a *AppCtx) archiveKumaVolume(dir string) (string, error) {
+	name := filepath.Join(dir, fmt.Sprintf("kuma-%s.tar.gz", time.Now().Format("20060102-150405")))
+	// monitors carry notification tokens and the database holds the admin's password hash
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to create backup: %w", err)
+	}
+	err = a.withKumaVolume(func(containerID string) error {
+		a.Spinner.Prefix = "archiving kuma data volume"
+		reader, _, err := a.Docker.Client.CopyFromContainer(a.Context, containerID, kumaDataDir)
+		if err != nil {
+			return fmt.Errorf("failed to read kuma data volume: %w", err)
+		}
+		defer internal.CloseReader(reader)
+		gz := gzip.NewWriter(file)
+		if _, err := io.Copy(gz, reader); err != nil {
+			return fmt.Errorf("failed to write backup: %w", err)
+		}
+		if err := gz.Close(); err != nil {
+			return fmt.Errorf("failed to write backup: %w", err)
+		}
+		return file.Close()
+	})
+	if err != nil {
+		file.Close()
+		os.Remove(name)
+		return "", err
+	}
+	return name, nil
+}
+
+// checkKumaArchive makes sure the archive is a backup of the data volume before anything is deleted
+func checkKumaArchive(name string) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("%s is not a gzipped tarball: %w", name, err)
+	}
+	tr := tar.NewReader(gz)
+	database := false
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read backup: %w", err)
+		}
+		clean := path.Clean(header.Name)
+		if clean != path.Base(kumaDataDir) && !strings.HasPrefix(clean, path.Base(kumaDataDir)+"/") {
+			return fmt.Errorf("%s is not a kuma backup, it contains %s", name, header.Name)
+		}
+		if clean == kumaDatabase && header.Typeflag == tar.TypeReg {
+			database = true
+		}
+	}
+	if !database {
+		return fmt.Errorf("%s is not a kuma backup, it has no %s", name, kumaDatabase)
+	}
+	return nil
+}
+
+func kumaBackup(cmd *cobra.Command, args []string) {
+	app := GetApp(cmd)
+	dir := "."
+	if len(args) == 1 {
+		dir = args[0]
+	}
+	exists, err := app.volumeExists(cfg.Kuma.DataVolume)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	if !exists {
+		log.Error().Str("volume", cfg.Kuma.DataVolume).Msg("kuma data volume does not exist, nothing to back up")
+		return
+	}
+	running, err := app.stopKuma()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	name, err := app.archiveKumaVolume(dir)
+	if err != nil {
+		log.Error().Err(err).Send()
+	}
+	if running {
+		app.Spinner.Prefix = "starting uptime kuma"
+		if err := app.Docker.Client.ContainerStart(app.Context, cfg.Kuma.ContainerName, container.StartOptions{}); err != nil {
+			log.Error().Err(err).Msg("failed to start uptime kuma again")
+			return
+		}
+		if err := app.waitForKuma(cfg.Kuma.ContainerName); err != nil {
+			log.Error().Err(err).Send()
+			return
+		}
+	}
+	if name == "" {
+		return
+	}
+	app.Spinner.Stop()
+	color.Green("backed up uptime kuma to %s", name)
+}
+
+// kumaRestore replaces the data volume instead of writing over it, files of the current database such as its
+// write-ahead log must not survive next to the restored one
+func kumaRestore(cmd *cobra.Command, args []string) {
+	app := GetApp(cmd)
+	archive := args[0]
+	if err := checkKumaArchive(archive); err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	if _, err := app.stopKuma(); err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	exists, err := app.volumeExists(cfg.Kuma.DataVolume)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	if exists {
+		current, err := app.archiveKumaVolume(filepath.Dir(archive))
+		if err != nil {
+			log.Error().Err(err).Msg("failed to back up the current data, nothing was restored")
+			return
+		}
+		color.Cyan("current data backed up to %s", current)
+		app.Spinner.Prefix = "removing kuma data volume"
+		if err := app.Docker.Client.ContainerRemove(app.Context, cfg.Kuma.ContainerName, container.RemoveOptions{}); err != nil && !errdefs.IsNotFound(err) {
+			log.Error().Err(err).Msg("failed to remove kuma container")
+			return
+		}
+		if err := app.Docker.Client.VolumeRemove(app.Context, cfg.Kuma.DataVolume, false); err != nil {
+			log.Error().Err(err).Msg("failed to remove kuma data volume")
+			return
+		}
+	}
+	if err := app.createVolumeIfNotExists(cfg.Kuma.DataVolume, nil); err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	err = app.withKumaVolume(func(containerID string) error {
+		file, err := os.Open(archive)
+		if err != nil {
+			return fmt.Errorf("failed to open backup: %w", err)
+		}
+		defer file.Close()
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("failed to read backup: %w", err)
+		}
+		app.Spinner.Prefix = "restoring kuma data volume"
+		if err := app.Docker.Client.CopyToContainer(app.Context, containerID, path.Dir(kumaDataDir), gz, container.CopyToContainerOptions{}); err != nil {
+			return fmt.Errorf("failed to restore kuma data volume: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	if _, err := app.startKuma(); err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	app.Spinner.Stop()
+	color.Green("restored uptime kuma from %s", archive)
+}
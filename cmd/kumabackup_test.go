@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeKumaArchive writes a gzipped tarball with the given entries, names ending with a slash are directories
+func writeKumaArchive(t *testing.T, names ...string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "kuma.tar.gz")
+	file, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+	for _, entry := range names {
+		header := &tar.Header{Name: entry, Mode: 0o644, Typeflag: tar.TypeReg, Size: int64(len(entry))}
+		if strings.HasSuffix(entry, "/") {
+			header = &tar.Header{Name: entry, Mode: 0o755, Typeflag: tar.TypeDir}
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(entry)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestCheckKumaArchive(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive func(t *testing.T) string
+		wantErr string
+	}{
+		{
+			name: "backup of the data volume",
+			archive: func(t *testing.T) string {
+				return writeKumaArchive(t, "data/", "data/kuma.db", "data/upload/", "data/upload/logo1.png")
+			},
+		},
+		{
+			name:    "entries are cleaned before they are checked",
+			archive: func(t *testing.T) string { return writeKumaArchive(t, "./data/", "data//kuma.db") },
+		},
+		{
+			name:    "foreign entry",
+			archive: func(t *testing.T) string { return writeKumaArchive(t, "data/", "data/kuma.db", "etc/passwd") },
+			wantErr: "it contains etc/passwd",
+		},
+		{
+			name: "entry escaping the data directory",
+			archive: func(t *testing.T) string {
+				return writeKumaArchive(t, "data/", "data/kuma.db", "data/../root/.profile")
+			},
+			wantErr: "it contains data/../root/.profile",
+		},
+		{
+			name:    "missing database",
+			archive: func(t *testing.T) string { return writeKumaArchive(t, "data/", "data/upload/") },
+			wantErr: "it has no data/kuma.db",
+		},
+		{
+			name:    "database is not a regular file",
+			archive: func(t *testing.T) string { return writeKumaArchive(t, "data/", "data/kuma.db/") },
+			wantErr: "it has no data/kuma.db",
+		},
+		{
+			name: "not gzipped",
+			archive: func(t *testing.T) string {
+				name := filepath.Join(t.TempDir(), "kuma.tar.gz")
+				if err := os.WriteFile(name, []byte("data/kuma.db"), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				return name
+			},
+			wantErr: "is not a gzipped tarball",
+		},
+		{
+			name:    "missing file",
+			archive: func(t *testing.T) string { return filepath.Join(t.TempDir(), "missing.tar.gz") },
+			wantErr: "failed to open backup",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkKumaArchive(tt.archive(t))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("checkKumaArchive() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,356 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caner-cetin/oblivion/internal"
+	"github.com/caner-cetin/oblivion/internal/config"
+	"github.com/fatih/color"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+const (
+	kumaAdminUsernameRef = "/Kuma/Admin/Username"
+	kumaAdminPasswordRef = "/Kuma/Admin/Password"
+	// marks the monitors kuma sync owns, monitors without it are never touched
+	kumaManagedDescription = "managed by oblivion, edits are overwritten on kuma sync"
+	kumaDefaultInterval    = 60
+	// where kumaMounts mounts a unix:// docker_daemon
+	kumaDockerSocket = "/var/run/docker.sock"
+	kumaDockerHost   = "oblivion"
+)
+
+// kumaChange is a monitor or docker host kuma sync adds, updates or deletes
+type kumaChange struct {
+	action syncAction
+	name   string
+}
+
+var (
+	kumaSyncCmd = &cobra.Command{
+		Use:   "sync",
+		Short: "create, update and delete Uptime Kuma monitors to match [[Kuma.Monitors]] and the containers oblivion manages",
+		Run:   WrapCommandWithResources(kumaSync, ResourceConfig{Resources: []ResourceType{ResourceOnePassword}}),
+	}
+	kumaSyncDryRun bool
+)
+
+// kumaManagedMonitors returns a tcp monitor for every postgres, redis and observer container, addressed by
+// container name on the networks kuma shares with them
+func kumaManagedMonitors() []config.KumaMonitorConfig {
+	type managed struct {
+		container string
+		port      string
+		enabled   bool
+	}
+	containers := []managed{
+		{cfg.Postgres.Primary.Name, "5432", true},
+		{cfg.Postgres.Replica.Name, "5432", true},
+		{cfg.Postgres.Bouncer.Name, pgbouncerInternalPort, true},
+		{cfg.Dragonfly.ContainerName, redisInternalPort, true},
+		{cfg.Observer.ContainerNames.Prometheus, prometheusInternalPort, cfg.Observer.Enabled.Prometheus},
+		{cfg.Observer.ContainerNames.Grafana, grafanaInternalPort, cfg.Observer.Enabled.Grafana},
+		{cfg.Observer.ContainerNames.Loki, lokiInternalPort, cfg.Observer.Enabled.Loki},
+		{cfg.Observer.ContainerNames.Alertmanager, alertmanagerInternalPort, cfg.Observer.Enabled.Alertmanager},
+		{cfg.Observer.ContainerNames.Cadvisor, cadvisorInternalPort, cfg.Observer.Enabled.Cadvisor},
+		{cfg.Observer.ContainerNames.NodeExporter, nodeExporterInternalPort, cfg.Observer.Enabled.NodeExporter},
+	}
+	monitors := make([]config.KumaMonitorConfig, 0, len(containers))
+	for _, c := range containers {
+		if !c.enabled {
+			continue
+		}
+		port, _ := strconv.Atoi(c.port)
+		monitors = append(monitors, config.KumaMonitorConfig{Name: c.container, Type: "port", Hostname: c.container, Port: port})
+	}
+	return monitors
+}
+
+// kumaDesiredMonitors are the configured monitors followed by the managed ones, configured monitors win on name clashes
+func kumaDesiredMonitors() []config.KumaMonitorConfig {
+	monitors := append([]config.KumaMonitorConfig{}, cfg.Kuma.Monitors...)
+	if !cfg.Kuma.ManagedMonitors {
+		return monitors
+	}
+	names := make(map[string]bool, len(monitors))
+	for _, m := range monitors {
+		names[m.Name] = true
+	}
+	for _, m := range kumaManagedMonitors() {
+		if !names[m.Name] {
+			monitors = append(monitors, m)
+		}
+	}
+	return monitors
+}
+
+func kumaNeedsDocker() bool {
+	for _, m := range cfg.Kuma.Monitors {
+		if m.Type == "docker" {
+			return true
+		}
+	}
+	return false
+}
+
+// kumaMonitorFields are the fields kuma sync owns, everything else keeps what kuma or its UI set
+func kumaMonitorFields(m config.KumaMonitorConfig, dockerHost int) map[string]any {
+	interval := m.Interval
+	if interval == 0 {
+		interval = kumaDefaultInterval
+	}
+	fields := map[string]any{
+		"name":        m.Name,
+		"type":        m.Type,
+		"description": kumaManagedDescription,
+		"interval":    interval,
+	}
+	switch m.Type {
+	case "http", "keyword":
+		fields["url"] = m.URL
+		if m.Type == "keyword" {
+			fields["keyword"] = m.Keyword
+		}
+	case "port":
+		fields["hostname"] = m.Hostname
+		fields["port"] = m.Port
+	case "docker":
+		fields["docker_container"] = m.Container
+		fields["docker_host"] = dockerHost
+	}
+	return fields
+}
+
+// defaults of the add monitor form, kuma's add handler expects all of them
+func kumaNewMonitor(fields map[string]any) map[string]any {
+	monitor := map[string]any{
+		"url":                  "https://",
+		"method":               "GET",
+		"retryInterval":        fields["interval"],
+		"resendInterval":       0,
+		"maxretries":           1,
+		"timeout":              48,
+		"maxredirects":         10,
+		"accepted_statuscodes": []string{"200-299"},
+		"notificationIDList":   map[string]bool{},
+		"ignoreTls":            false,
+		"upsideDown":           false,
+		"expiryNotification":   false,
+	}
+	for k, v := range fields {
+		monitor[k] = v
+	}
+	return monitor
+}
+
+// kumaFieldsDiffer compares through JSON, kuma sends every number as a float
+func kumaFieldsDiffer(current map[string]any, fields map[string]any) bool {
+	for k, v := range fields {
+		want, err := json.Marshal(v)
+		if err != nil {
+			return true
+		}
+		var normalized any
+		if err := json.Unmarshal(want, &normalized); err != nil {
+			return true
+		}
+		if !reflect.DeepEqual(current[k], normalized) {
+			return true
+		}
+	}
+	return false
+}
+
+type kumaResponse struct {
+	OK            bool   `json:"ok"`
+	Msg           string `json:"msg"`
+	TokenRequired bool   `json:"tokenRequired"`
+	ID            int    `json:"id"`
+}
+
+// kumaCall emits event and fails unless kuma acknowledges it with ok
+func kumaCall(client *socketIOClient, event string, args ...any) (*kumaResponse, error) {
+	ack, err := client.call(event, args...)
+	if err != nil {
+		return nil, err
+	}
+	var resp kumaResponse
+	if err := json.Unmarshal(ack, &resp); err != nil {
+		return nil, fmt.Errorf("malformed %s response: %w", event, err)
+	}
+	if !resp.OK {
+		return &resp, fmt.Errorf("%s failed: %s", event, resp.Msg)
+	}
+	return &resp, nil
+}
+
+func (a *AppCtx) kumaLogin(client *socketIOClient) error {
+	username, err := a.resolveSecret(kumaAdminUsernameRef)
+	if err != nil {
+		return err
+	}
+	password, err := a.resolveSecret(kumaAdminPasswordRef)
+	if err != nil {
+		return err
+	}
+	resp, err := kumaCall(client, "login", map[string]string{"username": username, "password": password, "token": ""})
+	if resp != nil && resp.TokenRequired {
+		return fmt.Errorf("the kuma admin has two factor authentication enabled, kuma sync cannot log in")
+	}
+	if err != nil {
+		return internal.RedactError(err)
+	}
+	return nil
+}
+
+// kumaDockerDaemon returns the docker host type and daemon kuma reaches [Kuma] docker_daemon through
+func kumaDockerDaemon() (string, string) {
+	if strings.HasPrefix(cfg.Kuma.DockerDaemon, "unix://") {
+		return "socket", kumaDockerSocket
+	}
+	return "tcp", cfg.Kuma.DockerDaemon
+}
+
+// kumaDockerHostID returns the docker host backed by [Kuma] docker_daemon, adding it if kuma does not know it yet.
+// A dry run adds nothing, found is false when the host would be added.
+func kumaDockerHostID(client *socketIOClient, dryRun bool) (id int, found bool, err error) {
+	payload, err := client.event("dockerHostList")
+	if err != nil {
+		return 0, false, err
+	}
+	var hosts []struct {
+		ID           int    `json:"id"`
+		DockerType   string `json:"dockerType"`
+		DockerDaemon string `json:"dockerDaemon"`
+	}
+	if err := json.Unmarshal(payload, &hosts); err != nil {
+		return 0, false, fmt.Errorf("malformed docker host list: %w", err)
+	}
+	dockerType, daemon := kumaDockerDaemon()
+	for _, host := range hosts {
+		if host.DockerType == dockerType && host.DockerDaemon == daemon {
+			return host.ID, true, nil
+		}
+	}
+	if dryRun {
+		return 0, false, nil
+	}
+	resp, err := kumaCall(client, "addDockerHost", map[string]string{"name": kumaDockerHost, "dockerType": dockerType, "dockerDaemon": daemon}, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	return resp.ID, false, nil
+}
+
+func kumaSync(cmd *cobra.Command, args []string) {
+	app := GetApp(cmd)
+	app.Spinner.Prefix = "connecting to uptime kuma"
+	// kuma publishes its port on the docker host, which is not this machine when [Docker] Socket is remote
+	client, err := dialSocketIO(dockerHostname(), cfg.Kuma.Port, 30*time.Second)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to connect to uptime kuma, is it up?")
+		return
+	}
+	defer client.Close()
+	app.Spinner.Prefix = "logging in to uptime kuma"
+	if err := app.kumaLogin(client); err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	payload, err := client.event("monitorList")
+	if err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	var list map[string]map[string]any
+	if err := json.Unmarshal(payload, &list); err != nil {
+		log.Error().Err(err).Msg("malformed monitor list")
+		return
+	}
+	managed := make(map[string]map[string]any)
+	for _, monitor := range list {
+		if description, _ := monitor["description"].(string); description == kumaManagedDescription {
+			name, _ := monitor["name"].(string)
+			managed[name] = monitor
+		}
+	}
+	var changes []kumaChange
+	dockerHost, dockerHostFound := 0, true
+	if kumaNeedsDocker() {
+		if dockerHost, dockerHostFound, err = kumaDockerHostID(client, kumaSyncDryRun); err != nil {
+			log.Error().Err(err).Msg("failed to set up the docker host for docker monitors")
+			return
+		}
+		if !dockerHostFound {
+			_, daemon := kumaDockerDaemon()
+			changes = append(changes, kumaChange{syncAdd, "docker host " + daemon})
+		}
+	}
+
+	desired := kumaDesiredMonitors()
+	for _, m := range desired {
+		current, ok := managed[m.Name]
+		delete(managed, m.Name)
+		fields := kumaMonitorFields(m, dockerHost)
+		if kumaSyncDryRun && !dockerHostFound {
+			// the host a dry run would add has no id yet, moving monitors to it is covered by the docker host change
+			delete(fields, "docker_host")
+		}
+		switch {
+		case !ok:
+			changes = append(changes, kumaChange{syncAdd, m.Name})
+			if !kumaSyncDryRun {
+				_, err = kumaCall(client, "add", kumaNewMonitor(fields))
+			}
+		case kumaFieldsDiffer(current, fields):
+			changes = append(changes, kumaChange{syncUpdate, m.Name})
+			if !kumaSyncDryRun {
+				for k, v := range fields {
+					current[k] = v
+				}
+				_, err = kumaCall(client, "editMonitor", current)
+			}
+		}
+		if err != nil {
+			log.Error().Err(err).Str("monitor", m.Name).Msgf("sync stopped after %d change(s)", len(changes)-1)
+			return
+		}
+	}
+	// managed monitors left over are no longer configured
+	stale := make([]string, 0, len(managed))
+	for name := range managed {
+		stale = append(stale, name)
+	}
+	sort.Strings(stale)
+	for _, name := range stale {
+		changes = append(changes, kumaChange{syncDelete, name})
+		if kumaSyncDryRun {
+			continue
+		}
+		id, _ := managed[name]["id"].(float64)
+		if _, err := kumaCall(client, "deleteMonitor", int(id)); err != nil {
+			log.Error().Err(err).Str("monitor", name).Msgf("sync stopped after %d change(s)", len(changes)-1)
+			return
+		}
+	}
+	app.Spinner.Stop()
+	for _, change := range changes {
+		fmt.Printf("%s %s\n", change.action, change.name)
+	}
+	unchanged := len(desired) + len(stale) - len(changes)
+	if !dockerHostFound {
+		unchanged++
+	}
+	if kumaSyncDryRun {
+		color.Cyan("dry run, %d change(s) and %d unchanged monitor(s)", len(changes), unchanged)
+		return
+	}
+	color.Green("%d monitor(s) synced, %d unchanged", len(changes), unchanged)
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/caner-cetin/oblivion/internal/config"
+)
+
+func TestDockerHostname(t *testing.T) {
+	tests := []struct {
+		socket string
+		want   string
+	}{
+		{socket: "unix:///var/run/docker.sock", want: "localhost"},
+		{socket: "", want: "localhost"},
+		{socket: "tcp://10.0.0.5:2376", want: "10.0.0.5"},
+		{socket: "ssh://deploy@cansu.dev", want: "cansu.dev"},
+		{socket: "tcp://[fd00::5]:2375", want: "fd00::5"},
+	}
+	for _, tt := range tests {
+		setTestConfig(t, func(c *config.Root) { c.Docker.Socket = tt.socket })
+		if got := dockerHostname(); got != tt.want {
+			t.Errorf("dockerHostname() with %q = %q, want %q", tt.socket, got, tt.want)
+		}
+	}
+}
+
+func TestKumaDockerHostID(t *testing.T) {
+	handshake := []string{`0{"sid":"abc","pingInterval":25000,"pingTimeout":20000}`, `<40`, `40{"sid":"def"}`}
+	tests := []struct {
+		name      string
+		hosts     string
+		dryRun    bool
+		script    []string
+		wantID    int
+		wantFound bool
+	}{
+		{
+			name:      "known host",
+			hosts:     `[{"id":3,"dockerType":"tcp","dockerDaemon":"tcp://docker-socket-proxy:2375"}]`,
+			wantID:    3,
+			wantFound: true,
+		},
+		{
+			name:   "dry run adds nothing",
+			hosts:  `[{"id":3,"dockerType":"tcp","dockerDaemon":"tcp://other:2375"}]`,
+			dryRun: true,
+		},
+		{
+			name:  "missing host is added",
+			hosts: `[]`,
+			script: []string{
+				`<420["addDockerHost",{"dockerDaemon":"tcp://docker-socket-proxy:2375","dockerType":"tcp","name":"oblivion"},null]`,
+				`430[{"ok":true,"id":7}]`,
+			},
+			wantID: 7,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestConfig(t, func(c *config.Root) { c.Kuma.DockerDaemon = "tcp://docker-socket-proxy:2375" })
+			script := append(append([]string{}, handshake...), `42["dockerHostList",`+tt.hosts+`]`)
+			host, port := fakeSocketIOServer(t, append(script, tt.script...))
+			client, err := dialSocketIO(host, port, 5*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			id, found, err := kumaDockerHostID(client, tt.dryRun)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != tt.wantID || found != tt.wantFound {
+				t.Errorf("kumaDockerHostID() = %d, %v, want %d, %v", id, found, tt.wantID, tt.wantFound)
+			}
+		})
+	}
+}
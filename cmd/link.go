@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caner-cetin/oblivion/internal"
+	"github.com/fatih/color"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	staticLinkCmd = &cobra.Command{
+		Use:   "link <path>",
+		Short: "print an expiring signed url for a file below [Static.Nginx.SecureLink] path",
+		Args:  cobra.ExactArgs(1),
+		Run:   WrapCommandWithResources(staticLink, ResourceConfig{Resources: []ResourceType{ResourceOnePassword}}),
+	}
+	staticLinkExpires time.Duration
+)
+
+// secureLinkURI maps a path relative to the secure link prefix, or one that already starts with it, to the uri nginx signs
+func secureLinkURI(p string) (string, error) {
+	prefix := cfg.Static.Nginx.SecureLink.Path
+	// compared without the trailing slash, so /private/ covers /private/a but not /privatefoo
+	dir := strings.TrimSuffix(prefix, "/")
+	rel := p
+	if strings.HasPrefix(p, "/") {
+		if p != dir && !strings.HasPrefix(p, dir+"/") {
+			return "", fmt.Errorf("%s is not below %s, only files there are served through signed links", p, prefix)
+		}
+		rel = strings.TrimPrefix(strings.TrimPrefix(p, dir), "/")
+	}
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("%s must name a file below %s", p, prefix)
+	}
+	return path.Join(prefix, rel), nil
+}
+
+func secureLinkBaseURL() (string, error) {
+	if base := cfg.Static.Nginx.SecureLink.BaseURL; base != "" {
+		return strings.TrimSuffix(base, "/"), nil
+	}
+	name := cfg.Static.Nginx.ServerNames[0]
+	if name == "_" || strings.HasPrefix(name, "*.") {
+		return "", fmt.Errorf("first server name %s is not a host, set [Static.Nginx.SecureLink] base_url", name)
+	}
+	return "https://" + name, nil
+}
+
+func staticLink(cmd *cobra.Command, args []string) {
+	app := GetApp(cmd)
+	if !secureLinksEnabled() {
+		log.Error().Msg("signed links are disabled, set [Static.Nginx.SecureLink] secret_ref and run static up")
+		return
+	}
+	if staticLinkExpires <= 0 {
+		log.Error().Dur("expires", staticLinkExpires).Msg("--expires must be positive")
+		return
+	}
+	uri, err := secureLinkURI(args[0])
+	if err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	base, err := secureLinkBaseURL()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	// nginx only serves files, a directory would answer 403 even with a valid signature
+	info, err := os.Stat(filepath.Join(cfg.Static.StaticPath, uri))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		log.Warn().Str("path", filepath.Join(cfg.Static.StaticPath, uri)).Msg("file does not exist yet, the link fails until it is uploaded")
+	case err != nil:
+		log.Error().Err(err).Send()
+		return
+	case info.IsDir():
+		log.Error().Str("path", uri).Msg("signed links point at files, not directories")
+		return
+	}
+	app.Spinner.Prefix = "resolving secure link secret"
+	secret, err := app.secureLinkSecret()
+	if err != nil {
+		log.Error().Err(internal.RedactError(err)).Send()
+		return
+	}
+	expires := time.Now().Add(staticLinkExpires)
+	query := url.Values{}
+	query.Set("md5", secureLinkHash(expires.Unix(), uri, secret))
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	app.Spinner.Stop()
+	fmt.Printf("%s%s?%s\n", base, (&url.URL{Path: uri}).EscapedPath(), query.Encode())
+	color.Cyan("expires %s", expires.Local().Format(time.DateTime))
+}
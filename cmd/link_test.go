@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/caner-cetin/oblivion/internal/config"
+)
+
+func TestSecureLinkURI(t *testing.T) {
+	setTestConfig(t, func(c *config.Root) {})
+	tests := []struct {
+		path    string
+		prefix  string
+		want    string
+		wantErr bool
+	}{
+		{path: "/privatefoo/a.pdf", wantErr: true},
+		{path: "/privatefoo", wantErr: true},
+		{path: "/private", wantErr: true},
+		{path: "/private/", wantErr: true},
+		{path: "/private/a.pdf", prefix: "/private", want: "/private/a.pdf"},
+		{path: "/privatefoo/a.pdf", prefix: "/private", wantErr: true},
+		{path: "report.pdf", want: "/private/report.pdf"},
+		{path: "a/b.pdf", want: "/private/a/b.pdf"},
+		{path: "/private/a/b.pdf", want: "/private/a/b.pdf"},
+		{path: "/public/a.pdf", wantErr: true},
+		{path: "../a.pdf", wantErr: true},
+		{path: "/private/../a.pdf", wantErr: true},
+		{path: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		cfg.Static.Nginx.SecureLink.Path = "/private/"
+		if tt.prefix != "" {
+			cfg.Static.Nginx.SecureLink.Path = tt.prefix
+		}
+		got, err := secureLinkURI(tt.path)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("secureLinkURI(%q) = %q, expected an error", tt.path, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("secureLinkURI(%q) = %q, %v, want %q", tt.path, got, err, tt.want)
+		}
+	}
+}
+
+func TestSecureLinkBaseURL(t *testing.T) {
+	tests := []struct {
+		serverNames []string
+		baseURL     string
+		want        string
+		wantErr     bool
+	}{
+		{serverNames: []string{"static.example.com", "cdn.example.com"}, want: "https://static.example.com"},
+		{serverNames: []string{"_"}, baseURL: "https://files.example.com/", want: "https://files.example.com"},
+		{serverNames: []string{"_"}, wantErr: true},
+		{serverNames: []string{"*.example.com"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		setTestConfig(t, func(c *config.Root) {
+			c.Static.Nginx.ServerNames = tt.serverNames
+			c.Static.Nginx.SecureLink.BaseURL = tt.baseURL
+		})
+		got, err := secureLinkBaseURL()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("secureLinkBaseURL() with %v = %q, expected an error", tt.serverNames, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("secureLinkBaseURL() with %v = %q, %v, want %q", tt.serverNames, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/caner-cetin/oblivion/internal"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+	"github.com/fatih/color"
+	"github.com/rs/zerolog/log"
+)
+
+// labels every container oblivion creates carries, the collector turns them into loki labels
+const (
+	serviceLabel   = "dev.cansu.oblivion.service"
+	componentLabel = "dev.cansu.oblivion.component"
+
+	promtailInternalPort = 9080
+)
+
+// [Observer.Logs] shipping modes
+const (
+	logShippingCollector = "collector"
+	logShippingDriver    = "driver"
+	logShippingOff       = "off"
+)
+
+func managedLabels(service string, component string) map[string]string {
+	return map[string]string{
+		serviceLabel:   service,
+		componentLabel: component,
+	}
+}
+
+// managedLogConfig returns the loki logging driver config in driver mode, otherwise docker's default.
+// Loki and the collector always log locally, shipping their own logs to themselves would hide their failures.
+func managedLogConfig(service string, component string) container.LogConfig {
+	if cfg.Observer.Logs.Shipping != logShippingDriver || (service == "observer" && (component == "loki" || component == "promtail")) {
+		return container.LogConfig{}
+	}
+	return container.LogConfig{
+		Type: "loki",
+		Config: map[string]string{
+			// the driver runs in the docker daemon, not on a container network
+			"loki-url":             "http://" + net.JoinHostPort("localhost", cfg.Observer.Ports.Loki) + "/loki/api/v1/push",
+			"loki-external-labels": fmt.Sprintf("service=%s,component=%s,container={{.Name}}", service, component),
+			"loki-retries":         "2",
+			"loki-batch-size":      "400",
+			// keep containers running when loki is down instead of blocking on stdout
+			"mode": "non-blocking",
+		},
+	}
+}
+
+// lokiPluginInstall is printed when driver mode finds no loki plugin
+const lokiPluginInstall = "docker plugin install grafana/loki-docker-driver:latest --alias loki --grant-all-permissions"
+
+// checkLokiPlugin fails in driver mode when the loki logging driver is not installed and enabled. Docker only
+// rejects the log driver when a container is created, halfway through bringing a stack up.
+func (a *AppCtx) checkLokiPlugin() error {
+	if cfg.Observer.Logs.Shipping != logShippingDriver {
+		return nil
+	}
+	plugins, err := a.Docker.Client.PluginList(a.Context, filters.Args{})
+	if err != nil {
+		return fmt.Errorf("failed to list docker plugins: %w", err)
+	}
+	for _, plugin := range plugins {
+		name, _, _ := strings.Cut(plugin.Name, ":")
+		if name != "loki" {
+			continue
+		}
+		if !plugin.Enabled {
+			return fmt.Errorf("[Observer.Logs] shipping is driver but the loki plugin is disabled, enable it with `docker plugin enable %s`", plugin.Name)
+		}
+		return nil
+	}
+	return fmt.Errorf("[Observer.Logs] shipping is driver but the loki logging driver is not installed, install it with `%s`", lokiPluginInstall)
+}
+
+type promtailConfig struct {
+	Server        promtailServer      `yaml:"server"`
+	Positions     promtailPositions   `yaml:"positions"`
+	Clients       []promtailClient    `yaml:"clients"`
+	ScrapeConfigs []promtailScrapeJob `yaml:"scrape_configs"`
+}
+
+type promtailServer struct {
+	HTTPListenPort int `yaml:"http_listen_port"`
+	GRPCListenPort int `yaml:"grpc_listen_port"`
+}
+
+type promtailPositions struct {
+	Filename string `yaml:"filename"`
+}
+
+type promtailClient struct {
+	URL string `yaml:"url"`
+}
+
+type promtailScrapeJob struct {
+	JobName         string             `yaml:"job_name"`
+	DockerSDConfigs []promtailDockerSD `yaml:"docker_sd_configs"`
+	RelabelConfigs  []promtailRelabel  `yaml:"relabel_configs"`
+}
+
+type promtailDockerSD struct {
+	Host            string           `yaml:"host"`
+	RefreshInterval string           `yaml:"refresh_interval"`
+	Filters         []promtailFilter `yaml:"filters"`
+}
+
+type promtailFilter struct {
+	Name   string   `yaml:"name"`
+	Values []string `yaml:"values"`
+}
+
+type promtailRelabel struct {
+	SourceLabels []string `yaml:"source_labels"`
+	Regex        string   `yaml:"regex,omitempty"`
+	TargetLabel  string   `yaml:"target_label,omitempty"`
+	Action       string   `yaml:"action,omitempty"`
+}
+
+// docker service discovery exposes container labels with every character outside [a-zA-Z0-9_] replaced by _
+func dockerLabelMeta(label string) string {
+	sanitized := []byte(label)
+	for i, c := range sanitized {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			sanitized[i] = '_'
+		}
+	}
+	return "__meta_docker_container_label_" + string(sanitized)
+}
+
+// renderPromtailConfig generates a promtail config that tails every container carrying the oblivion labels.
+func renderPromtailConfig() ([]byte, error) {
+	ptCfg := promtailConfig{
+		Server:    promtailServer{HTTPListenPort: promtailInternalPort, GRPCListenPort: 0},
+		Positions: promtailPositions{Filename: "/promtail/positions.yaml"},
+		Clients: []promtailClient{{
+			URL: "http://" + containerTarget(cfg.Observer.ContainerNames.Loki, lokiInternalPort) + "/loki/api/v1/push",
+		}},
+		ScrapeConfigs: []promtailScrapeJob{{
+			JobName: "oblivion",
+			DockerSDConfigs: []promtailDockerSD{{
+				Host:            "unix:///var/run/docker.sock",
+				RefreshInterval: "5s",
+				Filters:         []promtailFilter{{Name: "label", Values: []string{serviceLabel}}},
+			}},
+			RelabelConfigs: []promtailRelabel{
+				// loki and promtail log locally like in driver mode, tailing them would ship every push error
+				// back to the loki that caused it and the collector's own output about shipping it
+				{
+					SourceLabels: []string{dockerLabelMeta(serviceLabel), dockerLabelMeta(componentLabel)},
+					Regex:        "observer;(loki|promtail)",
+					Action:       "drop",
+				},
+				{SourceLabels: []string{dockerLabelMeta(serviceLabel)}, TargetLabel: "service"},
+				{SourceLabels: []string{dockerLabelMeta(componentLabel)}, TargetLabel: "component"},
+				{SourceLabels: []string{"__meta_docker_container_name"}, Regex: "/(.*)", TargetLabel: "container"},
+				{SourceLabels: []string{"__meta_docker_container_log_stream"}, TargetLabel: "stream"},
+			},
+		}},
+	}
+	out, err := internal.MarshalYAML(&ptCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal promtail config: %w", err)
+	}
+	return append([]byte("# generated by oblivion from [Observer] config, edits are overwritten on observer up\n"), out...), nil
+}
+
+func (a *AppCtx) promtailUp() error {
+	if cfg.Observer.Logs.Shipping != logShippingCollector {
+		return nil
+	}
+	if err := a.pullImageIfNotExists(cfg.Observer.Images.Promtail); err != nil {
+		return fmt.Errorf("failed to pull promtail image: %w", err)
+	}
+	if err := a.warnUnlabeledContainers(); err != nil {
+		return err
+	}
+	exists, err := a.containerExists(cfg.Observer.ContainerNames.Promtail)
+	if err != nil {
+		return fmt.Errorf("failed to check existence of promtail container: %w", err)
+	}
+	promtailYml, err := renderPromtailConfig()
+	if err != nil {
+		return err
+	}
+	generated := map[string][]byte{"config.yml": promtailYml}
+	if exists {
+		changed, err := a.renderObserverConfig(cfg.Observer.ContainerNames.Promtail, cfg.Observer.Binds.Promtail, "", "/etc/promtail/", generated)
+		if err != nil {
+			return err
+		}
+		if changed {
+			// promtail only re-reads its config on restart
+			a.Spinner.Prefix = "restarting promtail"
+			if err := a.Docker.Client.ContainerRestart(a.Context, cfg.Observer.ContainerNames.Promtail, container.StopOptions{}); err != nil {
+				return fmt.Errorf("failed to restart promtail: %w", err)
+			}
+		}
+		color.Cyan("promtail running")
+		return nil
+	}
+	if err := a.createVolumeIfNotExists(cfg.Observer.Volumes.Promtail, nil); err != nil {
+		return fmt.Errorf("failed to create promtail volume: %w", err)
+	}
+	if cfg.Observer.Binds.Promtail == "" {
+		if err := a.recreateVolume(cfg.Observer.ConfigVolumes.Promtail); err != nil {
+			return fmt.Errorf("failed to create promtail config volume: %w", err)
+		}
+	}
+	promtailPort := nat.Port(fmt.Sprintf("%d/tcp", promtailInternalPort))
+	hostConfig := &container.HostConfig{
+		RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyAlways},
+		Mounts: []mount.Mount{
+			observerConfigMount(cfg.Observer.Binds.Promtail, cfg.Observer.ConfigVolumes.Promtail, "/etc/promtail/"),
+			{
+				Type:   mount.TypeVolume,
+				Source: cfg.Observer.Volumes.Promtail,
+				Target: "/promtail",
+			},
+			{
+				Type:     mount.TypeBind,
+				Source:   "/var/run/docker.sock",
+				Target:   "/var/run/docker.sock",
+				ReadOnly: true,
+			},
+		},
+		LogConfig: managedLogConfig("observer", "promtail"),
+	}
+	if cfg.Observer.Ports.Promtail != "" {
+		hostConfig.PortBindings = nat.PortMap{
+			promtailPort: []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: cfg.Observer.Ports.Promtail}},
+		}
+	}
+	a.Spinner.Prefix = "creating promtail container"
+	resp, err := a.Docker.Client.ContainerCreate(a.Context,
+		&container.Config{
+			Image:        cfg.Observer.Images.Promtail,
+			Cmd:          []string{"-config.file=/etc/promtail/config.yml"},
+			ExposedPorts: nat.PortSet{promtailPort: struct{}{}},
+			Labels:       managedLabels("observer", "promtail"),
+		},
+		hostConfig,
+		&network.NetworkingConfig{
+			EndpointsConfig: a.getNetworks(cfg.Networks.LokiNetworkName, cfg.Networks.GrafanaNetworkName),
+		},
+		nil,
+		cfg.Observer.ContainerNames.Promtail,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create promtail container: %w", err)
+	}
+	if _, err := a.renderObserverConfig(resp.ID, cfg.Observer.Binds.Promtail, "", "/etc/promtail/", generated); err != nil {
+		return err
+	}
+	a.Spinner.Prefix = "starting promtail"
+	if err := a.Docker.Client.ContainerStart(a.Context, resp.ID, container.StartOptions{}); err != nil {
+		return fmt.Errorf("failed to start promtail: %w", err)
+	}
+	return nil
+}
+
+// names of the containers oblivion creates with managedLabels
+func managedContainerNames() []string {
+	o := cfg.Observer.ContainerNames
+	return []string{
+		cfg.Postgres.Primary.Name, cfg.Postgres.Replica.Name, cfg.Postgres.Bouncer.Name,
+		cfg.Dragonfly.ContainerName,
+		cfg.Static.ContainerName, cfg.Static.Proxy.ContainerName, cfg.Static.FTP.ContainerName,
+		cfg.Kuma.ContainerName,
+		cfg.Playground.Backend.ContainerName,
+		o.Grafana, o.Prometheus, o.NodeExporter, o.Alertmanager, o.Cadvisor, o.Loki,
+		o.PostgresExporter, o.PgbouncerExporter, o.RedisExporter, o.Promtail, o.BlackboxExporter,
+	}
+}
+
+// warnUnlabeledContainers reports containers oblivion manages that were created before they carried its labels,
+// the collector only discovers labelled containers so their logs never reach loki until they are recreated
+func (a *AppCtx) warnUnlabeledContainers() error {
+	containers, err := a.Docker.Client.ContainerList(a.Context, container.ListOptions{All: true})
+	if err != nil {
+		return fmt.Errorf("failed to list containers: %w", err)
+	}
+	managed := managedContainerNames()
+	for _, c := range containers {
+		if _, ok := c.Labels[serviceLabel]; ok {
+			continue
+		}
+		for _, name := range c.Names {
+			if name = strings.TrimPrefix(name, "/"); name != "" && slices.Contains(managed, name) {
+				log.Warn().Str("container", name).Msg("container has no oblivion labels, its logs are not shipped until it is recreated")
+				break
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caner-cetin/oblivion/internal/config"
+	"github.com/docker/docker/client"
+)
+
+func TestCheckLokiPlugin(t *testing.T) {
+	tests := []struct {
+		name     string
+		shipping string
+		plugins  string
+		wantErr  string
+	}{
+		{
+			name:     "collector does not ask docker",
+			shipping: "collector",
+		},
+		{
+			name:     "installed",
+			shipping: "driver",
+			plugins:  `[{"Name":"loki:latest","Enabled":true}]`,
+		},
+		{
+			name:     "missing",
+			shipping: "driver",
+			plugins:  `[{"Name":"vieux/sshfs:latest","Enabled":true}]`,
+			wantErr:  lokiPluginInstall,
+		},
+		{
+			name:     "disabled",
+			shipping: "driver",
+			plugins:  `[{"Name":"loki:latest","Enabled":false}]`,
+			wantErr:  "docker plugin enable loki:latest",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r.URL.Path)
+				w.Header().Set("Content-Type", "application/json")
+				io.WriteString(w, tt.plugins)
+			}))
+			defer server.Close()
+			docker, err := client.NewClientWithOpts(client.WithHost("tcp://"+strings.TrimPrefix(server.URL, "http://")), client.WithVersion("1.47"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer docker.Close()
+			setTestConfig(t, func(c *config.Root) { c.Observer.Logs.Shipping = tt.shipping })
+			app := AppCtx{Context: context.Background()}
+			app.Docker.Client = docker
+			err = app.checkLokiPlugin()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("checkLokiPlugin() = %v, want an error containing %q", err, tt.wantErr)
+			}
+			if tt.shipping != "driver" && len(requests) != 0 {
+				t.Errorf("docker was asked outside driver mode: %v", requests)
+			}
+		})
+	}
+}
@@ -43,6 +43,10 @@ func networkUp(cmd *cobra.Command, args []string) {
 		log.Error().Err(err).Str("network_name", cfg.Networks.LokiNetworkName).Msg("failed to create network")
 		return
 	}
+	if err := app.createNetworkIfNotExists(cfg.Networks.StaticNetworkName, nil); err != nil {
+		log.Error().Err(err).Str("network_name", cfg.Networks.StaticNetworkName).Msg("failed to create network")
+		return
+	}
 
 	color.Green("created required networks")
 }
@@ -0,0 +1,250 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/caner-cetin/oblivion/internal"
+	"github.com/docker/docker/api/types/container"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	nginxConfDir = "/etc/nginx"
+	// rendered files are tested here before they replace the running configuration
+	nginxStagingDir = "/tmp/oblivion-nginx"
+	// mime types worth compressing, nginx always compresses text/html
+	nginxCompressTypes = "text/plain text/css text/xml text/javascript application/javascript application/json application/xml application/rss+xml image/svg+xml"
+)
+
+var nginxConfTemplate = template.Must(template.New("nginx.conf.tmpl").Funcs(template.FuncMap{
+	"join": strings.Join,
+	"onoff": func(b bool) string {
+		if b {
+			return "on"
+		}
+		return "off"
+	},
+}).ParseFS(staticBuildFiles, "config/static/nginx.conf.tmpl"))
+
+type nginxAuth struct {
+	Realm    string
+	UserFile string
+}
+
+// a url prefix that lists its directories, needs basic auth, a signed link or a combination
+type nginxLocation struct {
+	Path       string
+	Listing    bool
+	Auth       *nginxAuth
+	SecureLink bool
+}
+
+func htpasswdFile(i int) string {
+	return fmt.Sprintf("htpasswd/%d", i)
+}
+
+func secureLinksEnabled() bool {
+	return cfg.Static.Nginx.SecureLink.SecretRef != ""
+}
+
+// nginxLocations merges listing, protected and signed prefixes. Nginx only applies the longest matching prefix,
+// so every location inherits the listing of the prefixes above it, the auth of the closest protected one and
+// the signature check of the secure link prefix. Signed prefixes never list, a listing would leak the file names.
+func nginxLocations() []nginxLocation {
+	n := cfg.Static.Nginx
+	paths := make(map[string]bool)
+	for _, prefix := range n.ListingPaths {
+		paths[prefix] = true
+	}
+	for _, protected := range n.Protected {
+		paths[protected.Path] = true
+	}
+	if secureLinksEnabled() {
+		paths[n.SecureLink.Path] = true
+	}
+	locations := make([]nginxLocation, 0, len(paths))
+	for prefix := range paths {
+		location := nginxLocation{Path: prefix}
+		location.SecureLink = secureLinksEnabled() && strings.HasPrefix(prefix, n.SecureLink.Path)
+		for _, listing := range n.ListingPaths {
+			if strings.HasPrefix(prefix, listing) && !location.SecureLink {
+				location.Listing = true
+			}
+		}
+		closest := ""
+		for i, protected := range n.Protected {
+			if strings.HasPrefix(prefix, protected.Path) && len(protected.Path) > len(closest) {
+				closest = protected.Path
+				realm := protected.Realm
+				if realm == "" {
+					realm = "restricted"
+				}
+				location.Auth = &nginxAuth{Realm: realm, UserFile: path.Join(nginxConfDir, htpasswdFile(i))}
+			}
+		}
+		locations = append(locations, location)
+	}
+	sort.Slice(locations, func(i, j int) bool { return locations[i].Path < locations[j].Path })
+	return locations
+}
+
+// secureLinkSecret is empty while secure links are disabled
+func renderNginxConf(secureLinkSecret string) ([]byte, error) {
+	n := cfg.Static.Nginx
+	var buf bytes.Buffer
+	if err := nginxConfTemplate.Execute(&buf, map[string]any{
+		"ServerNames":          n.ServerNames,
+		"Root":                 cfg.Static.StaticPath,
+		"Fancyindex":           n.Fancyindex,
+		"FancyindexExactSize":  n.FancyindexExactSize,
+		"FancyindexShowPath":   n.FancyindexShowPath,
+		"FancyindexTimeFormat": n.FancyindexTimeFormat,
+		"Gzip":                 n.Gzip,
+		"Brotli":               n.Brotli,
+		"CompressTypes":        nginxCompressTypes,
+		"Cache":                n.Cache,
+		"Locations":            nginxLocations(),
+		"SecureLinkSecret":     secureLinkSecret,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to render nginx.conf: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// secureLinkSecret resolves the secret links are signed with. It is written into nginx.conf as part of a quoted
+// string, where nginx would expand $ and choke on quotes.
+func (a *AppCtx) secureLinkSecret() (string, error) {
+	secret, err := a.resolveSecret(cfg.Static.Nginx.SecureLink.SecretRef)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve secure link secret: %w", err)
+	}
+	if secret == "" || strings.ContainsAny(secret, "\"\\$;{}\n") {
+		return "", fmt.Errorf("secure link secret must not be empty or contain quotes, backslashes, $, ;, braces or newlines")
+	}
+	return secret, nil
+}
+
+// secureLinkHash is nginx's secure_link_md5 "$secure_link_expires$uri <secret>", base64url encoded without padding.
+// uri is the decoded path, the same as nginx's $uri.
+func secureLinkHash(expires int64, uri string, secret string) string {
+	sum := md5.Sum([]byte(fmt.Sprintf("%d%s %s", expires, uri, secret)))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// parses name:hash lines of an htpasswd file
+func parseHtpasswd(contents []byte) map[string]string {
+	hashes := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		if name, hash, ok := strings.Cut(scanner.Text(), ":"); ok {
+			hashes[name] = hash
+		}
+	}
+	return hashes
+}
+
+// renderStaticNginx renders nginx.conf and one htpasswd file per protected prefix, keyed by their path relative to /etc/nginx.
+// Bcrypt hashes the container already has are kept while they match the password, so an unchanged
+// vault does not show up as a changed configuration. containerID may be empty for a new container.
+func (a *AppCtx) renderStaticNginx(containerID string) (map[string][]byte, error) {
+	secret := ""
+	if secureLinksEnabled() {
+		var err error
+		if secret, err = a.secureLinkSecret(); err != nil {
+			return nil, err
+		}
+	}
+	conf, err := renderNginxConf(secret)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string][]byte{"nginx.conf": conf}
+	for i, protected := range cfg.Static.Nginx.Protected {
+		name := htpasswdFile(i)
+		current := make(map[string]string)
+		if containerID != "" {
+			if contents, err := a.readContainerFile(containerID, path.Join(nginxConfDir, name)); err == nil {
+				current = parseHtpasswd(contents)
+			}
+		}
+		var htpasswd strings.Builder
+		for _, user := range protected.Users {
+			password, err := a.resolveSecret(user.PasswordRef)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve password of %s for %s: %w", user.Name, protected.Path, err)
+			}
+			hash, ok := current[user.Name]
+			if !ok || bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+				generated, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+				if err != nil {
+					return nil, fmt.Errorf("failed to hash password of %s for %s: %w", user.Name, protected.Path, internal.RedactError(err))
+				}
+				hash = string(generated)
+			}
+			fmt.Fprintf(&htpasswd, "%s:%s\n", user.Name, hash)
+		}
+		files[name] = []byte(htpasswd.String())
+	}
+	return files, nil
+}
+
+// testNginxConf runs nginx -t against files in a throwaway container of image, before any container is created from it
+func (a *AppCtx) testNginxConf(image string, files map[string][]byte) error {
+	output, exitCode, err := a.runContainerOnce(&container.Config{
+		Image: image,
+		Cmd:   []string{"nginx", "-t"},
+	}, files, nginxConfDir)
+	if err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("rendered nginx.conf is invalid: %s", strings.TrimSpace(output))
+	}
+	return nil
+}
+
+// reloadStaticNginx replaces the configuration of the running static container if it differs from files.
+// The files are tested with nginx -t from a staging directory first, a failing test leaves the running configuration alone.
+func (a *AppCtx) reloadStaticNginx(containerID string, files map[string][]byte) (bool, error) {
+	changed := false
+	for name, contents := range files {
+		current, err := a.readContainerFile(containerID, path.Join(nginxConfDir, name))
+		if err != nil || !bytes.Equal(current, contents) {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	staged := make(map[string][]byte, len(files))
+	for name, contents := range files {
+		staged[path.Join(path.Base(nginxStagingDir), name)] = contents
+	}
+	a.Spinner.Prefix = "testing nginx.conf"
+	if err := a.copyFilesToContainer(containerID, staged, path.Dir(nginxStagingDir)); err != nil {
+		return false, err
+	}
+	defer func() {
+		_, _ = a.execInContainer(containerID, []string{"rm", "-rf", nginxStagingDir}, nil, nil)
+	}()
+	if _, err := a.execInContainer(containerID, []string{"nginx", "-t", "-c", path.Join(nginxStagingDir, "nginx.conf")}, nil, nil); err != nil {
+		return false, fmt.Errorf("rendered nginx.conf is invalid, the running configuration was kept: %w", err)
+	}
+	a.Spinner.Prefix = "reloading nginx"
+	if err := a.copyFilesToContainer(containerID, files, nginxConfDir); err != nil {
+		return false, err
+	}
+	if _, err := a.execInContainer(containerID, []string{"nginx", "-s", "reload"}, nil, nil); err != nil {
+		return false, fmt.Errorf("failed to reload nginx: %w", err)
+	}
+	return true, nil
+}
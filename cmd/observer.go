@@ -1,21 +1,58 @@
 package cmd
 
 import (
+	"bytes"
+	"embed"
 	"fmt"
+	"io/fs"
+	"net"
+	"path"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/caner-cetin/oblivion/internal"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
 	"github.com/docker/go-connections/nat"
 	"github.com/fatih/color"
+	v1 "github.com/moby/docker-image-spec/specs-go/v1"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
 
+// rendered into the config volumes on every observer up unless the matching [Observer.Binds] key is set,
+// prometheus and alertmanager configuration is generated entirely from the config
+//
+//go:embed config/grafana config/loki
+var observerConfigFiles embed.FS
+
+// observer components in the order observer up starts them, down stops them in reverse
+var observerComponentNames = []string{"cadvisor", "node-exporter", "alertmanager", "exporters", "blackbox-exporter", "prometheus", "loki", "promtail", "grafana"}
+
 var (
 	observerUpCmd = &cobra.Command{
-		Use: "up",
-		Run: WrapCommandWithResources(observerUp, ResourceConfig{Resources: []ResourceType{ResourceDocker, ResourceOnePassword}, Networks: []Network{NetworkDatabase, NetworkUptime, NetworkGrafana, NetworkLoki}}),
+		Use:       "up [component]...",
+		Short:     "create or update observer components and wait until they are healthy, defaults to every enabled component",
+		ValidArgs: observerComponentNames,
+		Args:      cobra.OnlyValidArgs,
+		Run:       WrapCommandWithResources(observerUp, ResourceConfig{Resources: []ResourceType{ResourceDocker, ResourceOnePassword}, Networks: []Network{NetworkDatabase, NetworkUptime, NetworkGrafana, NetworkLoki}}),
+	}
+	observerDownCmd = &cobra.Command{
+		Use:       "down [component]...",
+		Short:     "stop and remove observer containers, volumes are kept. Defaults to every component",
+		ValidArgs: observerComponentNames,
+		Args:      cobra.OnlyValidArgs,
+		Run:       WrapCommandWithResources(observerDown, ResourceConfig{Resources: []ResourceType{ResourceDocker}}),
+	}
+	observerRestartCmd = &cobra.Command{
+		Use:       "restart [component]...",
+		Short:     "restart observer containers and wait until they are healthy, defaults to every enabled component",
+		ValidArgs: observerComponentNames,
+		Args:      cobra.OnlyValidArgs,
+		Run:       WrapCommandWithResources(observerRestart, ResourceConfig{Resources: []ResourceType{ResourceDocker}}),
 	}
 	observerCmd = &cobra.Command{
 		Use: "observer",
@@ -56,43 +93,169 @@ Alien observer in a world that isn't mine
 
 func getObserverCmd() *cobra.Command {
 	observerCmd.AddCommand(observerUpCmd)
+	observerCmd.AddCommand(observerDownCmd)
+	observerCmd.AddCommand(observerRestartCmd)
+	observerCmd.AddCommand(getObserverAlertsCmd())
+	observerCmd.AddCommand(getObserverRulesCmd())
+	observerCmd.AddCommand(getObserverDashboardsCmd())
+	observerCmd.AddCommand(getObserverPrometheusCmd())
 	return observerCmd
 }
 
-func observerUp(cmd *cobra.Command, args []string) {
-	app := GetApp(cmd)
-	if err := app.createVolumeIfNotExists(cfg.Observer.Volumes.Grafana, nil); err != nil {
-		log.Error().Err(err).Msg("failed to create grafana volume")
-		return
-	}
-	if err := app.createVolumeIfNotExists(cfg.Observer.Volumes.Prometheus, nil); err != nil {
-		log.Error().Err(err).Msg("failed to create prometheus volume")
-		return
+type observerComponent struct {
+	name       string
+	containers []string
+	enabled    bool
+	up         func(a *AppCtx) error
+	// nil for components whose images have no http client to probe with
+	healthcheck *v1.HealthcheckConfig
+}
+
+func observerComponents() []observerComponent {
+	return []observerComponent{
+		{"cadvisor", []string{cfg.Observer.ContainerNames.Cadvisor}, cfg.Observer.Enabled.Cadvisor, (*AppCtx).cadvisorUp, cadvisorHealthcheck},
+		{"node-exporter", []string{cfg.Observer.ContainerNames.NodeExporter}, cfg.Observer.Enabled.NodeExporter, (*AppCtx).nodeExporterUp, nodeExporterHealthcheck},
+		{"alertmanager", []string{cfg.Observer.ContainerNames.Alertmanager}, cfg.Observer.Enabled.Alertmanager, (*AppCtx).alertmanagerUp, alertmanagerHealthcheck},
+		// exporters and the blackbox exporter only feed prometheus
+		{"exporters", []string{cfg.Observer.ContainerNames.PostgresExporter, cfg.Observer.ContainerNames.PgbouncerExporter, cfg.Observer.ContainerNames.RedisExporter}, cfg.Observer.Enabled.Prometheus, (*AppCtx).exportersUp, nil},
+		{"blackbox-exporter", []string{cfg.Observer.ContainerNames.BlackboxExporter}, cfg.Observer.Enabled.Prometheus && probesEnabled(), (*AppCtx).blackboxExporterUp, blackboxExporterHealthcheck},
+		{"prometheus", []string{cfg.Observer.ContainerNames.Prometheus}, cfg.Observer.Enabled.Prometheus, (*AppCtx).prometheusUp, prometheusHealthcheck},
+		{"loki", []string{cfg.Observer.ContainerNames.Loki}, cfg.Observer.Enabled.Loki, (*AppCtx).lokiUp, lokiHealthcheck},
+		{"promtail", []string{cfg.Observer.ContainerNames.Promtail}, cfg.Observer.Logs.Shipping == logShippingCollector, (*AppCtx).promtailUp, nil},
+		{"grafana", []string{cfg.Observer.ContainerNames.Grafana}, cfg.Observer.Enabled.Grafana, (*AppCtx).grafanaUp, grafanaHealthcheck},
 	}
-	if err := app.cadvisorUp(); err != nil {
-		log.Error().Err(err).Send()
-		return
+}
+
+// selectObserverComponents returns the named components, or every component when none is named.
+// Disabled components are only left out when nothing is named and includeDisabled is false.
+func selectObserverComponents(args []string, includeDisabled bool) []observerComponent {
+	components := observerComponents()
+	selected := make([]observerComponent, 0, len(components))
+	for _, c := range components {
+		if len(args) == 0 && (c.enabled || includeDisabled) || slices.Contains(args, c.name) {
+			selected = append(selected, c)
+		}
+	}
+	return selected
+}
+
+// healthchecks observer up and restart wait for
+var (
+	cadvisorHealthcheck         = httpHealthcheck(cadvisorInternalPort, "/healthz")
+	nodeExporterHealthcheck     = httpHealthcheck(nodeExporterInternalPort, "/")
+	alertmanagerHealthcheck     = httpHealthcheck(alertmanagerInternalPort, "/-/ready")
+	blackboxExporterHealthcheck = httpHealthcheck(blackboxExporterInternalPort, "/-/healthy")
+	prometheusHealthcheck       = httpHealthcheck(prometheusInternalPort, "/-/ready")
+	lokiHealthcheck             = httpHealthcheck(lokiInternalPort, "/ready")
+	grafanaHealthcheck          = httpHealthcheck(grafanaInternalPort, "/api/health")
+)
+
+// probes the component over http from inside its own container, busybox wget is in every image it is used for
+func httpHealthcheck(port string, path string) *v1.HealthcheckConfig {
+	return &v1.HealthcheckConfig{
+		Test:        []string{"CMD", "wget", "-q", "-O", "/dev/null", "http://" + net.JoinHostPort("127.0.0.1", port) + path},
+		Interval:    5 * time.Second,
+		Timeout:     5 * time.Second,
+		StartPeriod: 10 * time.Second,
+		Retries:     24,
 	}
-	if err := app.alertmanagerUp(); err != nil {
-		log.Error().Err(err).Send()
-		return
+}
+
+// waitForObserverComponent blocks until every container of the component reports healthy.
+// Containers created before healthchecks were added have none, they are only reported.
+func (a *AppCtx) waitForObserverComponent(c observerComponent) error {
+	if c.healthcheck == nil {
+		return nil
 	}
-	if err := app.nodeExporterUp(); err != nil {
-		log.Error().Err(err).Send()
-		return
+	for _, name := range c.containers {
+		inspect, err := a.Docker.Client.ContainerInspect(a.Context, name)
+		if err != nil {
+			return fmt.Errorf("failed to inspect %s: %w", name, err)
+		}
+		if inspect.Config.Healthcheck == nil || len(inspect.Config.Healthcheck.Test) == 0 {
+			log.Warn().Str("container", name).Msg("container has no healthcheck, recreate it with observer down and up to wait for it")
+			continue
+		}
+		if err := a.waitForContainerHealthWithConfig(inspect.ID, c.healthcheck); err != nil {
+			return fmt.Errorf("%s is not healthy: %w", c.name, err)
+		}
 	}
+	return nil
+}
 
-	if err := app.prometheusUp(); err != nil {
-		log.Error().Err(err).Send()
-		return
-	}
-	if err := app.grafanaUp(); err != nil {
-		log.Error().Err(err).Send()
-		return
+func observerUp(cmd *cobra.Command, args []string) {
+	app := GetApp(cmd)
+	components := selectObserverComponents(args, false)
+	for _, c := range components {
+		if !c.enabled {
+			log.Warn().Msgf("%s is disabled in [Observer.Enabled] or by its feature settings, skipping", c.name)
+			continue
+		}
+		if err := c.up(&app); err != nil {
+			log.Error().Err(err).Send()
+			return
+		}
+	}
+	for _, c := range components {
+		if !c.enabled {
+			continue
+		}
+		if err := app.waitForObserverComponent(c); err != nil {
+			log.Error().Err(err).Send()
+			return
+		}
+	}
+	app.Spinner.Stop()
+	color.Green("observer components up")
+}
+
+func observerDown(cmd *cobra.Command, args []string) {
+	app := GetApp(cmd)
+	components := selectObserverComponents(args, true)
+	for i := len(components) - 1; i >= 0; i-- {
+		for _, name := range components[i].containers {
+			app.Spinner.Prefix = fmt.Sprintf("removing %s", name)
+			if err := app.Docker.Client.ContainerRemove(app.Context, name, container.RemoveOptions{Force: true}); err != nil {
+				if errdefs.IsNotFound(err) {
+					continue
+				}
+				log.Error().Err(err).Msgf("failed to remove %s", name)
+				return
+			}
+			color.Cyan("removed %s", name)
+		}
 	}
-	if err := app.lokiUp(); err != nil {
-		log.Error().Err(err).Send()
-		return
+}
+
+func observerRestart(cmd *cobra.Command, args []string) {
+	app := GetApp(cmd)
+	components := selectObserverComponents(args, false)
+	restarted := make([]observerComponent, 0, len(components))
+	for _, c := range components {
+		found := false
+		for _, name := range c.containers {
+			app.Spinner.Prefix = fmt.Sprintf("restarting %s", name)
+			if err := app.Docker.Client.ContainerRestart(app.Context, name, container.StopOptions{}); err != nil {
+				if errdefs.IsNotFound(err) {
+					continue
+				}
+				log.Error().Err(err).Msgf("failed to restart %s", name)
+				return
+			}
+			found = true
+		}
+		if !found {
+			log.Warn().Msgf("%s has no containers, create them with observer up", c.name)
+			continue
+		}
+		restarted = append(restarted, c)
+	}
+	for _, c := range restarted {
+		if err := app.waitForObserverComponent(c); err != nil {
+			log.Error().Err(err).Send()
+			return
+		}
+		color.Cyan("%s restarted", c.name)
 	}
 }
 
@@ -111,11 +274,14 @@ func (a *AppCtx) cadvisorUp() error {
 	a.Spinner.Prefix = "creating cadvisor"
 	resp, err := a.Docker.Client.ContainerCreate(a.Context,
 		&container.Config{
-			Image: cfg.Observer.Images.Cadvisor,
+			Image:       cfg.Observer.Images.Cadvisor,
+			Labels:      managedLabels("observer", "cadvisor"),
+			Healthcheck: cadvisorHealthcheck,
 		},
 		&container.HostConfig{
+			LogConfig: managedLogConfig("observer", "cadvisor"),
 			PortBindings: nat.PortMap{
-				nat.Port("8080/tcp"): []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: cfg.Observer.Ports.Cadvisor}},
+				nat.Port(cadvisorInternalPort + "/tcp"): []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: cfg.Observer.Ports.Cadvisor}},
 			},
 			Mounts: []mount.Mount{
 				{
@@ -177,37 +343,70 @@ func (a *AppCtx) prometheusUp() error {
 	if err != nil {
 		return fmt.Errorf("failed to check existence of prometheus container: %w", err)
 	}
+	var generated map[string][]byte
+	if cfg.Observer.Binds.Prometheus == "" {
+		rules, err := prometheusRuleFiles()
+		if err != nil {
+			return err
+		}
+		if _, err := checkRules(rules); err != nil {
+			return err
+		}
+		exporters, err := a.existingExporters()
+		if err != nil {
+			return err
+		}
+		if generated, err = prometheusFiles(rules, exporters); err != nil {
+			return err
+		}
+	}
 	if exists {
+		changed, err := a.renderObserverConfig(cfg.Observer.ContainerNames.Prometheus, cfg.Observer.Binds.Prometheus, "", "/etc/prometheus/", generated)
+		if err != nil {
+			return err
+		}
+		if changed {
+			a.Spinner.Prefix = "reloading prometheus"
+			if err := a.reloadPrometheus(); err != nil {
+				return fmt.Errorf("prometheus config changed but reload failed: %w", err)
+			}
+			color.Green("prometheus config reloaded")
+		}
+		if err := a.checkPrometheusArgs(); err != nil {
+			return err
+		}
 		color.Cyan("prometheus running")
 		return nil
 	}
+	if err := a.createVolumeIfNotExists(cfg.Observer.Volumes.Prometheus, nil); err != nil {
+		return fmt.Errorf("failed to create prometheus volume: %w", err)
+	}
+	if cfg.Observer.Binds.Prometheus == "" {
+		if err := a.recreateVolume(cfg.Observer.ConfigVolumes.Prometheus); err != nil {
+			return fmt.Errorf("failed to create prometheus config volume: %w", err)
+		}
+	}
 	a.Spinner.Prefix = "creating prometheus container"
 	resp, err := a.Docker.Client.ContainerCreate(a.Context,
 		&container.Config{
-			Image: cfg.Observer.Images.Prometheus,
-			Cmd: []string{
-				"--config.file=/etc/prometheus/prometheus.yml",
-				"--storage.tsdb.path=/prometheus",
-				"--web.console.libraries=/usr/share/prometheus/console_libraries",
-				"--web.console.templates=/usr/share/prometheus/consoles",
-			},
+			Image:       cfg.Observer.Images.Prometheus,
+			Labels:      managedLabels("observer", "prometheus"),
+			Healthcheck: prometheusHealthcheck,
+			Cmd:         prometheusArgs(),
 		},
 		&container.HostConfig{
+			LogConfig:     managedLogConfig("observer", "prometheus"),
 			RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyAlways},
 			PortBindings: nat.PortMap{
-				nat.Port("9090/tcp"): []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: cfg.Observer.Ports.Prometheus}},
+				nat.Port(prometheusInternalPort + "/tcp"): []nat.PortBinding{{HostIP: prometheusHostIP, HostPort: cfg.Observer.Ports.Prometheus}},
 			},
 			Mounts: []mount.Mount{
 				{
 					Type:   mount.TypeVolume,
 					Source: cfg.Observer.Volumes.Prometheus,
-					Target: "/prometheus",
-				},
-				{
-					Type:   mount.TypeBind,
-					Source: cfg.Observer.Binds.Prometheus,
-					Target: "/etc/prometheus/",
+					Target: prometheusDataDir,
 				},
+				observerConfigMount(cfg.Observer.Binds.Prometheus, cfg.Observer.ConfigVolumes.Prometheus, "/etc/prometheus/"),
 			},
 		},
 		&network.NetworkingConfig{
@@ -218,6 +417,9 @@ func (a *AppCtx) prometheusUp() error {
 	if err != nil {
 		return fmt.Errorf("failed to create prometheus container: %w", err)
 	}
+	if _, err := a.renderObserverConfig(resp.ID, cfg.Observer.Binds.Prometheus, "", "/etc/prometheus/", generated); err != nil {
+		return err
+	}
 	a.Spinner.Prefix = "starting prometheus"
 	if err := a.Docker.Client.ContainerStart(a.Context, resp.ID, container.StartOptions{}); err != nil {
 		return fmt.Errorf("failed to start prometheus: %w", err)
@@ -233,31 +435,54 @@ func (a *AppCtx) alertmanagerUp() error {
 	if err != nil {
 		return fmt.Errorf("failed to check existence of alertmanager container: %w", err)
 	}
+	var generated map[string][]byte
+	if cfg.Observer.Binds.Alertmanager == "" {
+		if generated, err = a.alertmanagerFiles(); err != nil {
+			return err
+		}
+	}
 	if exists {
+		changed, err := a.renderObserverConfig(cfg.Observer.ContainerNames.Alertmanager, cfg.Observer.Binds.Alertmanager, "", "/etc/alertmanager/", generated)
+		if err != nil {
+			return err
+		}
+		if changed {
+			a.Spinner.Prefix = "reloading alertmanager"
+			if err := a.reloadAlertmanager(); err != nil {
+				return fmt.Errorf("alertmanager config changed but reload failed: %w", err)
+			}
+			color.Green("alertmanager config reloaded")
+		}
 		color.Cyan("alertmanager running")
 		return nil
 	}
+	if cfg.Observer.Binds.Alertmanager == "" {
+		if err := a.recreateVolume(cfg.Observer.ConfigVolumes.Alertmanager); err != nil {
+			return fmt.Errorf("failed to create alertmanager config volume: %w", err)
+		}
+	}
 	a.Spinner.Prefix = "creating alertmanager container"
 	resp, err := a.Docker.Client.ContainerCreate(a.Context,
 		&container.Config{
-			Image: cfg.Observer.Images.Alertmanager,
+			Image:       cfg.Observer.Images.Alertmanager,
+			Labels:      managedLabels("observer", "alertmanager"),
+			Healthcheck: alertmanagerHealthcheck,
 			Cmd: []string{
 				"--config.file=/etc/alertmanager/config.yml",
 				"--storage.path=/alertmanager",
 			},
 		},
 		&container.HostConfig{
+			LogConfig:     managedLogConfig("observer", "alertmanager"),
 			RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyAlways},
 			PortBindings: nat.PortMap{
-				nat.Port("9093/tcp"): []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: cfg.Observer.Ports.Alertmanager}},
+				nat.Port(alertmanagerInternalPort + "/tcp"): []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: cfg.Observer.Ports.Alertmanager}},
 			},
 			Mounts: []mount.Mount{
-				{
-					Type:   mount.TypeBind,
-					Source: cfg.Observer.Binds.Alertmanager,
-					Target: "/etc/alertmanager/",
-				},
+				observerConfigMount(cfg.Observer.Binds.Alertmanager, cfg.Observer.ConfigVolumes.Alertmanager, "/etc/alertmanager/"),
 			},
+			// observer alerts test listens on the host for the stand-in webhook
+			ExtraHosts: []string{alertTestHost + ":host-gateway"},
 		},
 		&network.NetworkingConfig{
 			EndpointsConfig: a.getNetworks(cfg.Networks.GrafanaNetworkName),
@@ -267,6 +492,9 @@ func (a *AppCtx) alertmanagerUp() error {
 	if err != nil {
 		return fmt.Errorf("failed to create alrtmanager container: %w", err)
 	}
+	if _, err := a.renderObserverConfig(resp.ID, cfg.Observer.Binds.Alertmanager, "", "/etc/alertmanager/", generated); err != nil {
+		return err
+	}
 	a.Spinner.Prefix = "starting alertmanager"
 	if err := a.Docker.Client.ContainerStart(a.Context, resp.ID, container.StartOptions{}); err != nil {
 		return fmt.Errorf("failed to start alertmanager: %w", err)
@@ -289,7 +517,9 @@ func (a *AppCtx) nodeExporterUp() error {
 	a.Spinner.Prefix = "creating node exporter container"
 	resp, err := a.Docker.Client.ContainerCreate(a.Context,
 		&container.Config{
-			Image: cfg.Observer.Images.NodeExporter,
+			Image:       cfg.Observer.Images.NodeExporter,
+			Labels:      managedLabels("observer", "node-exporter"),
+			Healthcheck: nodeExporterHealthcheck,
 			Cmd: []string{
 				"--path.rootfs=/host",
 				"--collector.filesystem.ignored-mount-points",
@@ -297,8 +527,9 @@ func (a *AppCtx) nodeExporterUp() error {
 			},
 		},
 		&container.HostConfig{
+			LogConfig: managedLogConfig("observer", "node-exporter"),
 			PortBindings: nat.PortMap{
-				nat.Port("9100/tcp"): []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: cfg.Observer.Ports.NodeExporter}},
+				nat.Port(nodeExporterInternalPort + "/tcp"): []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: cfg.Observer.Ports.NodeExporter}},
 			},
 			RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyAlways},
 			Mounts: []mount.Mount{
@@ -338,22 +569,47 @@ func (a *AppCtx) grafanaUp() error {
 	if err != nil {
 		return fmt.Errorf("failed to check existence of grafana container: %w", err)
 	}
+	generated, err := grafanaFiles()
+	if err != nil {
+		return err
+	}
 	if exists {
+		changed, err := a.renderObserverConfig(cfg.Observer.ContainerNames.Grafana, cfg.Observer.Binds.Grafana, "config/grafana", "/etc/grafana/", generated)
+		if err != nil {
+			return err
+		}
+		if changed {
+			a.Spinner.Prefix = "reloading grafana provisioning"
+			if err := a.reloadGrafanaProvisioning(); err != nil {
+				return fmt.Errorf("grafana provisioning changed but reload failed: %w", err)
+			}
+			color.Green("grafana provisioning reloaded")
+		}
 		color.Cyan("grafana running")
 		return nil
 	}
-	admin_username, err := a.Vault.Client.Secrets().Resolve(a.Context, a.Vault.Prefix+"/Grafana/Admin/Username")
+	if err := a.createVolumeIfNotExists(cfg.Observer.Volumes.Grafana, nil); err != nil {
+		return fmt.Errorf("failed to create grafana volume: %w", err)
+	}
+	if cfg.Observer.Binds.Grafana == "" {
+		if err := a.recreateVolume(cfg.Observer.ConfigVolumes.Grafana); err != nil {
+			return fmt.Errorf("failed to create grafana config volume: %w", err)
+		}
+	}
+	admin_username, err := a.resolveSecret("/Grafana/Admin/Username")
 	if err != nil {
 		return fmt.Errorf("failed to resolve grafana username password: %w", err)
 	}
-	admin_password, err := a.Vault.Client.Secrets().Resolve(a.Context, a.Vault.Prefix+"/Grafana/Admin/Password")
+	admin_password, err := a.resolveSecret("/Grafana/Admin/Password")
 	if err != nil {
 		return fmt.Errorf("failed to resolve grafana admin password: %w", err)
 	}
 	a.Spinner.Prefix = "creating grafana container"
 	resp, err := a.Docker.Client.ContainerCreate(a.Context,
 		&container.Config{
-			Image: cfg.Observer.Images.Grafana,
+			Image:       cfg.Observer.Images.Grafana,
+			Labels:      managedLabels("observer", "grafana"),
+			Healthcheck: grafanaHealthcheck,
 			Env: []string{
 				"GF_USERS_ALLOW_SIGN_UP=false",
 				"GF_SECURITY_ADMIN_USER=" + admin_username,
@@ -361,8 +617,9 @@ func (a *AppCtx) grafanaUp() error {
 			},
 		},
 		&container.HostConfig{
+			LogConfig: managedLogConfig("observer", "grafana"),
 			PortBindings: nat.PortMap{
-				nat.Port("3000/tcp"): []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: cfg.Observer.Ports.Grafana}},
+				nat.Port(grafanaInternalPort + "/tcp"): []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: cfg.Observer.Ports.Grafana}},
 			},
 			RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyAlways},
 			Mounts: []mount.Mount{
@@ -371,11 +628,7 @@ func (a *AppCtx) grafanaUp() error {
 					Source: cfg.Observer.Volumes.Grafana,
 					Target: "/var/lib/grafana",
 				},
-				{
-					Type:   mount.TypeBind,
-					Source: cfg.Observer.Binds.Grafana,
-					Target: "/etc/grafana/",
-				},
+				observerConfigMount(cfg.Observer.Binds.Grafana, cfg.Observer.ConfigVolumes.Grafana, "/etc/grafana/"),
 			},
 		},
 		&network.NetworkingConfig{
@@ -385,7 +638,10 @@ func (a *AppCtx) grafanaUp() error {
 		cfg.Observer.ContainerNames.Grafana,
 	)
 	if err != nil {
-		return fmt.Errorf("failed to create grafana container: %w", err)
+		return fmt.Errorf("failed to create grafana container: %w", internal.RedactError(err))
+	}
+	if _, err := a.renderObserverConfig(resp.ID, cfg.Observer.Binds.Grafana, "config/grafana", "/etc/grafana/", generated); err != nil {
+		return err
 	}
 	a.Spinner.Prefix = "starting grafana"
 	if err := a.Docker.Client.ContainerStart(a.Context, resp.ID, container.StartOptions{}); err != nil {
@@ -395,33 +651,49 @@ func (a *AppCtx) grafanaUp() error {
 }
 
 func (a *AppCtx) lokiUp() error {
+	if err := a.pullImageIfNotExists(cfg.Observer.Images.Loki); err != nil {
+		return fmt.Errorf("failed to pull loki image: %w", err)
+	}
 	exists, err := a.containerExists(cfg.Observer.ContainerNames.Loki)
 	if err != nil {
 		return fmt.Errorf("failed to check existence of loki container: %w", err)
 	}
 	if exists {
-		color.Cyan("loki container running")
+		changed, err := a.renderObserverConfig(cfg.Observer.ContainerNames.Loki, cfg.Observer.Binds.Loki, "config/loki", "/etc/loki/", nil)
+		if err != nil {
+			return err
+		}
+		if changed {
+			// loki only reads its config file on startup
+			a.Spinner.Prefix = "restarting loki"
+			if err := a.Docker.Client.ContainerRestart(a.Context, cfg.Observer.ContainerNames.Loki, container.StopOptions{}); err != nil {
+				return fmt.Errorf("failed to restart loki: %w", err)
+			}
+		}
+		color.Cyan("loki running")
 		return nil
 	}
-	if err := a.pullImageIfNotExists(cfg.Observer.Images.Loki); err != nil {
-		return fmt.Errorf("failed to pull image: %w", err)
+	if cfg.Observer.Binds.Loki == "" {
+		if err := a.recreateVolume(cfg.Observer.ConfigVolumes.Loki); err != nil {
+			return fmt.Errorf("failed to create loki config volume: %w", err)
+		}
 	}
+	a.Spinner.Prefix = "creating loki container"
 	resp, err := a.Docker.Client.ContainerCreate(a.Context,
 		&container.Config{
 			Image:        cfg.Observer.Images.Loki,
-			ExposedPorts: nat.PortSet{nat.Port("3169/tcp"): struct{}{}},
+			Labels:       managedLabels("observer", "loki"),
+			Healthcheck:  lokiHealthcheck,
+			ExposedPorts: nat.PortSet{nat.Port(lokiInternalPort + "/tcp"): struct{}{}},
 			Cmd:          []string{"-config.file=/etc/loki/config.yaml"},
 		},
 		&container.HostConfig{
+			LogConfig: managedLogConfig("observer", "loki"),
 			Mounts: []mount.Mount{
-				{
-					Type:   mount.TypeBind,
-					Source: cfg.Observer.Binds.Loki,
-					Target: "/etc/loki/",
-				},
+				observerConfigMount(cfg.Observer.Binds.Loki, cfg.Observer.ConfigVolumes.Loki, "/etc/loki/"),
 			},
 			PortBindings: nat.PortMap{
-				nat.Port("3169/tcp"): []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: cfg.Observer.Ports.Loki}},
+				nat.Port(lokiInternalPort + "/tcp"): []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: cfg.Observer.Ports.Loki}},
 			},
 		},
 		&network.NetworkingConfig{
@@ -433,8 +705,102 @@ func (a *AppCtx) lokiUp() error {
 	if err != nil {
 		return fmt.Errorf("failed to create loki container: %w", err)
 	}
+	if _, err := a.renderObserverConfig(resp.ID, cfg.Observer.Binds.Loki, "config/loki", "/etc/loki/", nil); err != nil {
+		return err
+	}
+	a.Spinner.Prefix = "starting loki"
 	if err := a.Docker.Client.ContainerStart(a.Context, resp.ID, container.StartOptions{}); err != nil {
 		return fmt.Errorf("failed to start loki container: %w", err)
 	}
 	return nil
 }
+
+// host bind if the user overrides the configuration, otherwise the volume renderObserverConfig writes into
+func observerConfigMount(bind string, volume string, target string) mount.Mount {
+	if bind != "" {
+		return mount.Mount{Type: mount.TypeBind, Source: bind, Target: target}
+	}
+	return mount.Mount{Type: mount.TypeVolume, Source: volume, Target: target}
+}
+
+// copies the embedded configuration under dir into the container's config volume, generated files replace
+// embedded ones with the same name. An empty dir renders only the generated files.
+// Reports whether anything differed from what the container had before. No-op when a bind overrides the configuration.
+func (a *AppCtx) renderObserverConfig(containerID string, bind string, dir string, target string, generated map[string][]byte) (bool, error) {
+	if bind != "" {
+		return false, nil
+	}
+	files := make(map[string][]byte, len(generated))
+	if dir != "" {
+		sub, err := fs.Sub(observerConfigFiles, dir)
+		if err != nil {
+			return false, fmt.Errorf("failed to open embedded %s: %w", dir, err)
+		}
+		if files, err = readFiles(sub); err != nil {
+			return false, fmt.Errorf("failed to read embedded %s: %w", dir, err)
+		}
+	}
+	for name, contents := range generated {
+		files[name] = contents
+	}
+	// a missing manifest means the volume was just created, or rendered by a version that did not write one
+	previous, _ := a.readContainerFile(containerID, path.Join(target, renderedManifest))
+	stale := staleRenderedFiles(previous, files)
+	files[renderedManifest] = renderedManifestOf(files)
+	changed := false
+	for name, contents := range files {
+		current, err := a.readContainerFile(containerID, path.Join(target, name))
+		if err != nil || !bytes.Equal(current, contents) {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	if len(stale) > 0 {
+		// removed before the new manifest is written, so a failed removal is retried on the next run
+		a.Spinner.Prefix = fmt.Sprintf("removing stale files from %s", target)
+		rm := []string{"rm", "-f", "--"}
+		for _, name := range stale {
+			rm = append(rm, path.Join(target, name))
+		}
+		if _, err := a.execInContainer(containerID, rm, nil, nil); err != nil {
+			return false, fmt.Errorf("failed to remove stale files %v from %s, recreate the container with observer down and observer up: %w", stale, target, err)
+		}
+	}
+	a.Spinner.Prefix = fmt.Sprintf("rendering %s", target)
+	if err := a.copyFilesToContainer(containerID, files, target); err != nil {
+		return false, fmt.Errorf("failed to render %s: %w", target, err)
+	}
+	return true, nil
+}
+
+// written next to the rendered files, lists them so the next render can remove the ones it no longer produces
+const renderedManifest = ".oblivion-rendered"
+
+// sorted file names of a render, one per line
+func renderedManifestOf(files map[string][]byte) []byte {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		if name != renderedManifest {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return []byte(strings.Join(names, "\n") + "\n")
+}
+
+// files listed in the previous manifest that the current render does not produce, in manifest order
+func staleRenderedFiles(manifest []byte, files map[string][]byte) []string {
+	var stale []string
+	for _, name := range strings.Split(string(manifest), "\n") {
+		if !fs.ValidPath(name) || name == "." || name == renderedManifest {
+			continue
+		}
+		if _, ok := files[name]; !ok {
+			stale = append(stale, name)
+		}
+	}
+	return stale
+}
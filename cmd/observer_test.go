@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/caner-cetin/oblivion/internal/config"
+)
+
+func TestStaleRenderedFiles(t *testing.T) {
+	files := map[string][]byte{
+		"prometheus.yml":     []byte("global: {}"),
+		"rules/probes.yml":   []byte("groups: []"),
+		"rules/postgres.yml": []byte("groups: []"),
+	}
+	tests := []struct {
+		name     string
+		manifest string
+		want     []string
+	}{
+		{name: "first render", manifest: ""},
+		{name: "nothing removed", manifest: "prometheus.yml\nrules/postgres.yml\nrules/probes.yml\n"},
+		{name: "rule file dropped", manifest: "prometheus.yml\nrules/old.yml\nrules/probes.yml\n", want: []string{"rules/old.yml"}},
+		{name: "manifest order kept", manifest: "z.yml\nprometheus.yml\na.yml\n", want: []string{"z.yml", "a.yml"}},
+		{name: "manifest never listed", manifest: renderedManifest + "\nprometheus.yml\n"},
+		{name: "paths outside the target ignored", manifest: "../etc/passwd\n/etc/passwd\nrules/../../x\n."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := staleRenderedFiles([]byte(tt.manifest), files); !slices.Equal(got, tt.want) {
+				t.Errorf("staleRenderedFiles(%q) = %q, want %q", tt.manifest, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderedManifestRoundTrip(t *testing.T) {
+	files := map[string][]byte{
+		"provisioning/datasources/datasources.yml": nil,
+		"grafana.ini":    nil,
+		renderedManifest: nil,
+	}
+	manifest := renderedManifestOf(files)
+	if want := "grafana.ini\nprovisioning/datasources/datasources.yml\n"; string(manifest) != want {
+		t.Errorf("renderedManifestOf() = %q, want %q", manifest, want)
+	}
+	if stale := staleRenderedFiles(manifest, files); len(stale) != 0 {
+		t.Errorf("a render is stale against its own manifest: %q", stale)
+	}
+	delete(files, "grafana.ini")
+	if stale := staleRenderedFiles(manifest, files); !slices.Equal(stale, []string{"grafana.ini"}) {
+		t.Errorf("staleRenderedFiles() after removing grafana.ini = %q", stale)
+	}
+}
+
+func TestSelectObserverComponents(t *testing.T) {
+	every := []string{"cadvisor", "node-exporter", "alertmanager", "exporters", "blackbox-exporter", "prometheus", "loki", "promtail", "grafana"}
+	tests := []struct {
+		name            string
+		modify          func(c *config.Root)
+		args            []string
+		includeDisabled bool
+		want            []string
+	}{
+		{
+			name:   "defaults",
+			modify: func(c *config.Root) {},
+			want:   []string{"cadvisor", "node-exporter", "alertmanager", "exporters", "prometheus", "loki", "grafana"},
+		},
+		{
+			name: "probes and the collector",
+			modify: func(c *config.Root) {
+				c.Observer.Probes.Targets = []config.ProbeConfig{{Name: "site", URL: "https://example.com"}}
+				c.Observer.Logs.Shipping = logShippingCollector
+			},
+			want: every,
+		},
+		{
+			name: "prometheus disabled takes its feeders",
+			modify: func(c *config.Root) {
+				c.Observer.Enabled.Prometheus = false
+				c.Observer.Probes.Targets = []config.ProbeConfig{{Name: "site", URL: "https://example.com"}}
+			},
+			want: []string{"cadvisor", "node-exporter", "alertmanager", "loki", "grafana"},
+		},
+		{
+			name:            "include disabled",
+			modify:          func(c *config.Root) { c.Observer.Enabled.Grafana = false },
+			includeDisabled: true,
+			want:            every,
+		},
+		{
+			name:   "named in start order",
+			modify: func(c *config.Root) {},
+			args:   []string{"grafana", "prometheus"},
+			want:   []string{"prometheus", "grafana"},
+		},
+		{
+			name:   "named even when disabled",
+			modify: func(c *config.Root) { c.Observer.Enabled.Loki = false },
+			args:   []string{"loki", "promtail"},
+			want:   []string{"loki", "promtail"},
+		},
+		{
+			name:   "unknown name",
+			modify: func(c *config.Root) {},
+			args:   []string{"jaeger"},
+			want:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestConfig(t, tt.modify)
+			got := []string{}
+			for _, c := range selectObserverComponents(tt.args, tt.includeDisabled) {
+				got = append(got, c.name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("selectObserverComponents(%q, %v) = %q, want %q", tt.args, tt.includeDisabled, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestObserverComponentsContainers(t *testing.T) {
+	setTestConfig(t, func(c *config.Root) {})
+	seen := map[string]string{}
+	for _, c := range observerComponents() {
+		if len(c.containers) == 0 {
+			t.Errorf("%s has no containers", c.name)
+		}
+		for _, name := range c.containers {
+			if name == "" {
+				t.Errorf("%s has a container without a name", c.name)
+			}
+			if other, ok := seen[name]; ok {
+				t.Errorf("container %s belongs to both %s and %s", name, other, c.name)
+			}
+			seen[name] = c.name
+		}
+	}
+}
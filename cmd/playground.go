@@ -1,92 +1,206 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/caner-cetin/oblivion/internal"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
 	"github.com/docker/go-connections/nat"
 	"github.com/fatih/color"
+	git "github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	v1 "github.com/moby/docker-image-spec/specs-go/v1"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
 
+const (
+	// build context of the backend inside the repository
+	playgroundBackendDir = "backend"
+)
+
 var (
 	playgroundUpCmd = &cobra.Command{
 		Use: "up",
 		Run: WrapCommandWithResources(playgroundUp, ResourceConfig{Resources: []ResourceType{ResourceDocker, ResourceOnePassword}, Networks: []Network{NetworkDatabase, NetworkLoki}}),
 	}
+	playgroundDeployCmd = &cobra.Command{
+		Use:   "deploy",
+		Short: "build the backend at a git ref unless an image of that commit exists, and replace the running backend with it",
+		Run:   WrapCommandWithResources(playgroundDeploy, ResourceConfig{Resources: []ResourceType{ResourceDocker, ResourceOnePassword}, Networks: []Network{NetworkDatabase, NetworkLoki}}),
+	}
 
 	playgroundCmd = &cobra.Command{
 		Use: "playground",
 	}
+	playgroundDeployRef string
 )
 
 func getPlaygroundCmd() *cobra.Command {
 	playgroundCmd.AddCommand(playgroundUpCmd)
+	playgroundDeployCmd.Flags().StringVar(&playgroundDeployRef, "ref", "", "branch, tag or commit to deploy, defaults to the repository's default branch")
+	playgroundCmd.AddCommand(playgroundDeployCmd)
 	return playgroundCmd
 }
 
-func playgroundUp(cmd *cobra.Command, args []string) {
-	app := GetApp(cmd)
-	exists, err := app.containerExists(cfg.Playground.Backend.ContainerName)
+func playgroundRepoDir() string {
+	return filepath.Join(os.TempDir(), "code.cansu.dev")
+}
+
+// images are tagged with the commit they are built from, deploying a commit again reuses its image
+func playgroundImage(commit plumbing.Hash) string {
+	return fmt.Sprintf("%s:%s", cfg.Playground.Backend.ImageName, commit.String()[:12])
+}
+
+// openPlaygroundRepo opens the clone of the backend repository, cloning it again if it is missing,
+// broken or a clone of another repository than the configured one
+func (a *AppCtx) openPlaygroundRepo() (*git.Repository, error) {
+	dir := playgroundRepoDir()
+	repo, err := git.PlainOpen(dir)
+	if err == nil {
+		if remote, err := repo.Remote(git.DefaultRemoteName); err == nil && len(remote.Config().URLs) > 0 && remote.Config().URLs[0] == cfg.Playground.Backend.Repository {
+			return repo, nil
+		}
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("failed to remove existing repository directory: %w", err)
+	}
+	a.Spinner.Prefix = fmt.Sprintf("cloning %s", cfg.Playground.Backend.Repository)
+	repo, err = git.PlainCloneContext(a.Context, dir, false, &git.CloneOptions{
+		URL:        cfg.Playground.Backend.Repository,
+		NoCheckout: true,
+	})
 	if err != nil {
-		log.Error().Err(err).Send()
-		return
+		return nil, fmt.Errorf("failed to clone repository: %w", err)
 	}
-	if exists {
-		color.Cyan("playground backend running")
-		return
+	return repo, nil
+}
+
+// resolvePlaygroundRef looks ref up as a remote branch first, a local branch of the clone may be behind it.
+// Tags and full or abbreviated commit hashes are resolved after that. An empty ref is the remote's default branch.
+func (a *AppCtx) resolvePlaygroundRef(repo *git.Repository, ref string) (plumbing.Hash, error) {
+	if ref == "" {
+		remote, err := repo.Remote(git.DefaultRemoteName)
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("failed to get remote: %w", err)
+		}
+		refs, err := remote.ListContext(a.Context, &git.ListOptions{})
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("failed to list remote refs: %w", err)
+		}
+		for _, r := range refs {
+			if r.Name() == plumbing.HEAD && r.Type() == plumbing.SymbolicReference {
+				ref = r.Target().Short()
+			}
+		}
+		if ref == "" {
+			return plumbing.ZeroHash, fmt.Errorf("remote does not tell its default branch, pass --ref")
+		}
 	}
-	tmp_repo_dir := filepath.Join(os.TempDir(), "code.cansu.dev")
-	if err := app.pullRepo(tmp_repo_dir); err != nil {
-		log.Error().Err(err).Send()
-		return
+	if r, err := repo.Reference(plumbing.NewRemoteReferenceName(git.DefaultRemoteName, ref), true); err == nil {
+		return r.Hash(), nil
 	}
-	backend_dir := filepath.Join(tmp_repo_dir, "backend")
-	repo_fs := os.DirFS(backend_dir)
-	if err := app.buildImage(repo_fs, backend_dir, cfg.Playground.Backend.ImageName, "Dockerfile"); err != nil {
-		log.Error().Err(err).Msg("failed to build image")
-		return
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("%s is not a branch, tag or commit of %s: %w", ref, cfg.Playground.Backend.Repository, err)
 	}
+	return *hash, nil
+}
 
-	pg_secrets, err := app.loadPostgresSecrets(internal.Ptr("/Postgres/Playground/username"), internal.Ptr("/Postgres/Playground/password"))
+// checkoutPlayground fetches the repository and checks out ref, returning the commit it points at
+func (a *AppCtx) checkoutPlayground(ref string) (plumbing.Hash, error) {
+	repo, err := a.openPlaygroundRepo()
 	if err != nil {
-		log.Error().Err(err).Msg("failed to get postgres secrets")
-		return
+		return plumbing.ZeroHash, err
 	}
-	redis_ref := app.Vault.Prefix + "/Redis/password"
-	hf_key_ref := app.Vault.Prefix + "/Hugging Face/API Key"
-	secrets, err := app.Vault.Client.Secrets().ResolveAll(app.Context, []string{redis_ref, hf_key_ref})
+	a.Spinner.Prefix = "fetching playground repository"
+	err = repo.FetchContext(a.Context, &git.FetchOptions{
+		RefSpecs: []gitconfig.RefSpec{"+refs/heads/*:refs/remotes/origin/*"},
+		Tags:     git.AllTags,
+		Force:    true,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return plumbing.ZeroHash, fmt.Errorf("failed to fetch repository: %w", err)
+	}
+	commit, err := a.resolvePlaygroundRef(repo, ref)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to get secrets")
-		return
+		return plumbing.ZeroHash, err
+	}
+	w, err := repo.Worktree()
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to get worktree: %w", err)
+	}
+	if err := w.Checkout(&git.CheckoutOptions{Hash: commit, Force: true}); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to check out %s: %w", commit, err)
+	}
+	// leftovers of an earlier checkout would end up in the build context
+	if err := w.Clean(&git.CleanOptions{Dir: true}); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to clean worktree: %w", err)
+	}
+	return commit, nil
+}
+
+// playgroundHealthcheck runs [Playground.Backend] healthcheck, a deploy gives the backend about two minutes
+func playgroundHealthcheck() *v1.HealthcheckConfig {
+	return &v1.HealthcheckConfig{
+		Test:        cfg.Playground.Backend.Healthcheck,
+		Interval:    5 * time.Second,
+		Timeout:     5 * time.Second,
+		StartPeriod: 10 * time.Second,
+		Retries:     24,
+	}
+}
+
+func (a *AppCtx) createPlaygroundContainer(name string, image string) (string, error) {
+	pg_secrets, err := a.loadPostgresSecrets(internal.Ptr("/Postgres/Playground/username"), internal.Ptr("/Postgres/Playground/password"))
+	if err != nil {
+		return "", fmt.Errorf("failed to get postgres secrets: %w", err)
 	}
-	resp, err := app.Docker.Client.ContainerCreate(app.Context,
+	const (
+		redis_ref  = "/Redis/password"
+		hf_key_ref = "/Hugging Face/API Key"
+	)
+	prefixed_keys, secrets, err := a.resolveSecrets([]string{redis_ref, hf_key_ref})
+	if err != nil {
+		return "", fmt.Errorf("failed to get secrets: %w", err)
+	}
+	resp, err := a.Docker.Client.ContainerCreate(a.Context,
 		&container.Config{
-			Image:        cfg.Playground.Backend.ImageName,
+			Image:        image,
+			Labels:       managedLabels("playground", "backend"),
 			ExposedPorts: nat.PortSet{nat.Port("6767/tcp"): struct{}{}},
 			Env: []string{
 				// sorry for this sequence
-				"HF_TOKEN=" + secrets.IndividualResponses[hf_key_ref].Content.Secret,
+				"HF_TOKEN=" + secrets[prefixed_keys[hf_key_ref]].Content.Secret,
 				"HF_MODEL_URL=" + cfg.Playground.Backend.HFModelUrl,
 				"REDIS_URL=" + fmt.Sprintf("redis://%s:%s/2", cfg.Dragonfly.ContainerName, cfg.Dragonfly.Port),
-				"REDIS_PASSWORD=" + secrets.IndividualResponses[redis_ref].Content.Secret,
+				"REDIS_PASSWORD=" + secrets[prefixed_keys[redis_ref]].Content.Secret,
 				"DATABASE_URL=" + fmt.Sprintf("postgres://%s:%s@%s:%s/playground?sslmode=disable",
 					pg_secrets.Role.User,
 					pg_secrets.Role.Password,
 					cfg.Postgres.Primary.Name,
 					cfg.Postgres.Primary.Port),
-				"LOKI_URL=" + fmt.Sprintf("http://%s:%s/loki/api/v1/push", cfg.Observer.ContainerNames.Loki, cfg.Observer.Ports.Loki),
+				"LOKI_URL=" + fmt.Sprintf("http://%s:%s/loki/api/v1/push", cfg.Observer.ContainerNames.Loki, lokiInternalPort),
 			},
 
-			Cmd: []string{"/app"},
+			Cmd:         []string{"/app"},
+			Healthcheck: playgroundHealthcheck(),
 		},
 		&container.HostConfig{
+			LogConfig: managedLogConfig("playground", "backend"),
 			Mounts: []mount.Mount{
 				{
 					Type:   mount.TypeBind,
@@ -118,17 +232,203 @@ func playgroundUp(cmd *cobra.Command, args []string) {
 			PidMode:     container.PidMode("host"),
 		},
 		&network.NetworkingConfig{
-			EndpointsConfig: app.getNetworks(cfg.Networks.LokiNetworkName, cfg.Networks.DatabaseNetworkName),
+			EndpointsConfig: a.getNetworks(cfg.Networks.LokiNetworkName, cfg.Networks.DatabaseNetworkName),
 		},
 		nil,
-		cfg.Playground.Backend.ContainerName,
+		name,
 	)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to create container")
+		return "", fmt.Errorf("failed to create playground container: %w", internal.RedactError(err))
+	}
+	return resp.ID, nil
+}
+
+// startPlaygroundContainer starts the container and waits until its healthcheck passes, its logs are streamed meanwhile
+func (a *AppCtx) startPlaygroundContainer(id string) error {
+	if err := a.Docker.Client.ContainerStart(a.Context, id, container.StartOptions{}); err != nil {
+		return fmt.Errorf("failed to start playground container: %w", err)
+	}
+	a.Spinner.Prefix = "waiting for playground backend"
+	cancel := a.spawnLogs(id)
+	defer cancel()
+	if err := a.waitForContainerHealthWithConfig(id, playgroundHealthcheck()); err != nil {
+		return fmt.Errorf("playground backend is not healthy: %w", err)
+	}
+	return nil
+}
+
+func (a *AppCtx) removePlaygroundContainer(id string) {
+	if err := a.Docker.Client.ContainerRemove(context.Background(), id, container.RemoveOptions{Force: true}); err != nil && !errdefs.IsNotFound(err) {
+		log.Warn().Err(err).Str("container", id).Msg("failed to remove playground container")
+	}
+}
+
+// swapPlaygroundContainer replaces the running backend with one from image. The replacement is created beforehand,
+// so the port is only unbound between stopping the old container and the new one passing its healthcheck.
+// If the replacement does not become healthy, the old container is started again.
+func (a *AppCtx) swapPlaygroundContainer(old string, image string) error {
+	// left behind by a deploy that was interrupted
+	a.removePlaygroundContainer(cfg.Playground.Backend.ContainerName + "-next")
+	next, err := a.createPlaygroundContainer(cfg.Playground.Backend.ContainerName+"-next", image)
+	if err != nil {
+		return err
+	}
+	a.Spinner.Prefix = "swapping playground containers"
+	timeout := 10
+	if err := a.Docker.Client.ContainerStop(a.Context, old, container.StopOptions{Timeout: &timeout}); err != nil {
+		a.removePlaygroundContainer(next)
+		return fmt.Errorf("failed to stop running playground container: %w", err)
+	}
+	if err := a.startPlaygroundContainer(next); err != nil {
+		a.removePlaygroundContainer(next)
+		if startErr := a.Docker.Client.ContainerStart(a.Context, old, container.StartOptions{}); startErr != nil {
+			return fmt.Errorf("failed to start the previous playground container after %w: %w", err, startErr)
+		}
+		return fmt.Errorf("new playground backend failed, rolled back to the previous one: %w", err)
+	}
+	a.removePlaygroundContainer(old)
+	if err := a.Docker.Client.ContainerRename(a.Context, next, cfg.Playground.Backend.ContainerName); err != nil {
+		return fmt.Errorf("failed to rename playground container: %w", err)
+	}
+	return nil
+}
+
+// stalePlaygroundTags returns the playground tags among images that are not the running one or one of the newest keep,
+// oldest last. Images of the same age are ordered by tag so the result does not depend on the listing order.
+func stalePlaygroundTags(images []image.Summary, running string, keep int) []string {
+	type tagged struct {
+		tag     string
+		created int64
+	}
+	var tags []tagged
+	for _, img := range images {
+		for _, tag := range img.RepoTags {
+			if strings.HasPrefix(tag, cfg.Playground.Backend.ImageName+":") {
+				tags = append(tags, tagged{tag, img.Created})
+			}
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].created != tags[j].created {
+			return tags[i].created > tags[j].created
+		}
+		return tags[i].tag < tags[j].tag
+	})
+	var stale []string
+	kept := 0
+	for _, t := range tags {
+		if t.tag == running {
+			continue
+		}
+		// the running image takes one of the slots
+		if kept < keep-1 {
+			kept++
+			continue
+		}
+		stale = append(stale, t.tag)
+	}
+	return stale
+}
+
+// prunePlaygroundImages removes all but the newest Playground.Backend.keep_images images, the running one is never removed
+func (a *AppCtx) prunePlaygroundImages(running string) error {
+	images, err := a.Docker.Client.ImageList(a.Context, image.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("reference", cfg.Playground.Backend.ImageName)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list playground images: %w", err)
+	}
+	for _, tag := range stalePlaygroundTags(images, running, cfg.Playground.Backend.KeepImages) {
+		if _, err := a.Docker.Client.ImageRemove(a.Context, tag, image.RemoveOptions{PruneChildren: true}); err != nil {
+			log.Warn().Err(err).Str("image", tag).Msg("failed to remove old playground image")
+			continue
+		}
+		log.Info().Str("image", tag).Msg("removed old playground image")
+	}
+	return nil
+}
+
+// deployPlayground builds ref unless its image exists and makes sure the backend runs it.
+// Returns the deployed image and whether the backend already ran it.
+func (a *AppCtx) deployPlayground(ref string) (string, bool, error) {
+	commit, err := a.checkoutPlayground(ref)
+	if err != nil {
+		return "", false, err
+	}
+	tag := playgroundImage(commit)
+	exists, err := a.imageExists(tag)
+	if err != nil {
+		return "", false, err
+	}
+	if exists {
+		log.Info().Str("image", tag).Msg("image of this commit exists, skipping build")
+	} else {
+		a.Spinner.Prefix = fmt.Sprintf("building %s", tag)
+		backendDir := filepath.Join(playgroundRepoDir(), playgroundBackendDir)
+		if err := a.buildImage(os.DirFS(backendDir), tag, "Dockerfile"); err != nil {
+			return "", false, err
+		}
+	}
+
+	inspect, err := a.Docker.Client.ContainerInspect(a.Context, cfg.Playground.Backend.ContainerName)
+	switch {
+	case errdefs.IsNotFound(err):
+		id, err := a.createPlaygroundContainer(cfg.Playground.Backend.ContainerName, tag)
+		if err != nil {
+			return "", false, err
+		}
+		if err := a.startPlaygroundContainer(id); err != nil {
+			return "", false, err
+		}
+	case err != nil:
+		return "", false, fmt.Errorf("failed to inspect playground container: %w", err)
+	case inspect.Config.Image == tag:
+		if _, err := a.containerExists(cfg.Playground.Backend.ContainerName); err != nil {
+			return "", false, err
+		}
+		return tag, true, nil
+	default:
+		if err := a.swapPlaygroundContainer(inspect.ID, tag); err != nil {
+			return "", false, err
+		}
+	}
+	if err := a.prunePlaygroundImages(tag); err != nil {
+		log.Warn().Err(err).Msg("failed to prune old playground images")
+	}
+	return tag, false, nil
+}
+
+func playgroundUp(cmd *cobra.Command, args []string) {
+	app := GetApp(cmd)
+	exists, err := app.containerExists(cfg.Playground.Backend.ContainerName)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	if exists {
+		color.Cyan("playground backend running")
+		return
+	}
+	tag, _, err := app.deployPlayground("")
+	if err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	app.Spinner.Stop()
+	color.Green("playground backend is up with %s", tag)
+}
+
+func playgroundDeploy(cmd *cobra.Command, args []string) {
+	app := GetApp(cmd)
+	tag, running, err := app.deployPlayground(playgroundDeployRef)
+	if err != nil {
+		log.Error().Err(err).Send()
 		return
 	}
-	if err := app.Docker.Client.ContainerStart(app.Context, resp.ID, container.StartOptions{}); err != nil {
-		log.Error().Err(err).Msg("failed to start container")
+	app.Spinner.Stop()
+	if running {
+		color.Cyan("playground backend already runs %s", tag)
 		return
 	}
+	color.Green("deployed %s", tag)
 }
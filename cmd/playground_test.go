@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/caner-cetin/oblivion/internal/config"
+	"github.com/docker/docker/api/types/image"
+)
+
+func TestStalePlaygroundTags(t *testing.T) {
+	setTestConfig(t, func(c *config.Root) {})
+	images := []image.Summary{
+		{Created: 200, RepoTags: []string{"playground-backend:bbbbbbbbbbbb"}},
+		{Created: 400, RepoTags: []string{"playground-backend:dddddddddddd"}},
+		{Created: 100, RepoTags: []string{"playground-backend:aaaaaaaaaaaa"}},
+		// the same image under another name is not the playground's to remove
+		{Created: 300, RepoTags: []string{"playground-backend:cccccccccccc", "backup/playground-backend:cccccccccccc"}},
+		{Created: 50, RepoTags: []string{"playground-backend-old:zzzzzzzzzzzz"}},
+	}
+	tests := []struct {
+		name    string
+		images  []image.Summary
+		running string
+		keep    int
+		want    []string
+	}{
+		{
+			name:    "newest are kept while running the newest",
+			images:  images,
+			running: "playground-backend:dddddddddddd",
+			keep:    3,
+			want:    []string{"playground-backend:aaaaaaaaaaaa"},
+		},
+		{
+			name:    "running an old image keeps it and fewer new ones",
+			images:  images,
+			running: "playground-backend:aaaaaaaaaaaa",
+			keep:    3,
+			want:    []string{"playground-backend:bbbbbbbbbbbb"},
+		},
+		{
+			name:    "keeping one leaves only the running image",
+			images:  images,
+			running: "playground-backend:bbbbbbbbbbbb",
+			keep:    1,
+			want:    []string{"playground-backend:dddddddddddd", "playground-backend:cccccccccccc", "playground-backend:aaaaaaaaaaaa"},
+		},
+		{
+			name:   "nothing running still reserves its slot",
+			images: images,
+			keep:   2,
+			want:   []string{"playground-backend:cccccccccccc", "playground-backend:bbbbbbbbbbbb", "playground-backend:aaaaaaaaaaaa"},
+		},
+		{
+			name:    "images of the same age are ordered by tag",
+			images:  []image.Summary{{Created: 100, RepoTags: []string{"playground-backend:bbbbbbbbbbbb"}}, {Created: 100, RepoTags: []string{"playground-backend:aaaaaaaaaaaa"}}, {Created: 100, RepoTags: []string{"playground-backend:cccccccccccc"}}},
+			running: "playground-backend:cccccccccccc",
+			keep:    2,
+			want:    []string{"playground-backend:bbbbbbbbbbbb"},
+		},
+		{
+			name:    "fewer images than slots",
+			images:  images[:2],
+			running: "playground-backend:dddddddddddd",
+			keep:    3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stalePlaygroundTags(tt.images, tt.running, tt.keep); !slices.Equal(got, tt.want) {
+				t.Fatalf("stalePlaygroundTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
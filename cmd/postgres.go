@@ -2,8 +2,10 @@ package cmd
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/caner-cetin/oblivion/internal"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
@@ -94,7 +96,7 @@ func (a *AppCtx) loadPostgresSecrets(user_ref *string, password_ref *string) (*p
 
 	prefixed_keys, secrets, err := a.resolveSecrets(keys)
 	if err != nil {
-		return nil, fmt.Errorf("failed to resolve postgres credentials: %w", err)
+		return nil, fmt.Errorf("failed to resolve postgres credentials: %w", internal.RedactError(err))
 	}
 
 	var credentials = postgresCredentials{
@@ -138,6 +140,7 @@ func (c *postgresCredentials) startPrimary(app *AppCtx) error {
 			AttachStdin:  false,
 			OpenStdin:    false,
 			Image:        cfg.Postgres.Primary.Image,
+			Labels:       managedLabels("postgres", "primary"),
 			Cmd: []string{
 				"-c",
 				"wal_level=replica",
@@ -157,6 +160,7 @@ func (c *postgresCredentials) startPrimary(app *AppCtx) error {
 			Healthcheck: postgres_healthcheck,
 		},
 		&container.HostConfig{
+			LogConfig: managedLogConfig("postgres", "primary"),
 			PortBindings: nat.PortMap{
 				nat.Port("5432/tcp"): []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: cfg.Postgres.Primary.Port}},
 			},
@@ -174,7 +178,7 @@ func (c *postgresCredentials) startPrimary(app *AppCtx) error {
 		nil,
 		cfg.Postgres.Primary.Name)
 	if err != nil {
-		return fmt.Errorf("failed to create primary postgres container: %w", err)
+		return fmt.Errorf("failed to create primary postgres container: %w", internal.RedactError(err))
 	}
 	log.Info().Str("id", response.ID).Msg("created primary postgres container")
 	err = app.Docker.Client.ContainerStart(app.Context, response.ID, container.StartOptions{})
@@ -205,6 +209,7 @@ func (c *postgresCredentials) startReplica(app *AppCtx) error {
 			AttachStdin:  false,
 			OpenStdin:    false,
 			Image:        cfg.Postgres.Replica.Image,
+			Labels:       managedLabels("postgres", "replica"),
 			Cmd: []string{
 				"-c",
 				"wal_level=replica",
@@ -223,6 +228,7 @@ func (c *postgresCredentials) startReplica(app *AppCtx) error {
 			Healthcheck: postgres_healthcheck,
 		},
 		&container.HostConfig{
+			LogConfig: managedLogConfig("postgres", "replica"),
 			Mounts: []mount.Mount{
 				{
 					Type:   mount.TypeVolume,
@@ -237,7 +243,7 @@ func (c *postgresCredentials) startReplica(app *AppCtx) error {
 		nil,
 		cfg.Postgres.Replica.Name)
 	if err != nil {
-		return fmt.Errorf("failed to create replica postgres container: %w", err)
+		return fmt.Errorf("failed to create replica postgres container: %w", internal.RedactError(err))
 	}
 
 	err = app.Docker.Client.ContainerStart(app.Context, response.ID, container.StartOptions{})
@@ -252,12 +258,45 @@ func (c *postgresCredentials) startReplica(app *AppCtx) error {
 	return nil
 }
 
+// hasStatsUser reports whether a pgbouncer container environment lets user run SHOW commands on the admin console
+func hasStatsUser(env []string, user string) bool {
+	for _, kv := range env {
+		name, value, _ := strings.Cut(kv, "=")
+		if name != "STATS_USERS" {
+			continue
+		}
+		for _, u := range strings.Split(value, ",") {
+			if strings.TrimSpace(u) == user {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkBouncerStatsUser fails when the running bouncer predates STATS_USERS. Environment variables are fixed when
+// the container is created, so only recreating it lets the pgbouncer exporter in.
+func (c *postgresCredentials) checkBouncerStatsUser(app *AppCtx) error {
+	inspect, err := app.Docker.Client.ContainerInspect(app.Context, cfg.Postgres.Bouncer.Name)
+	if err != nil {
+		return fmt.Errorf("failed to inspect bouncer container: %w", err)
+	}
+	if hasStatsUser(inspect.Config.Env, c.Bouncer.User) {
+		return nil
+	}
+	return fmt.Errorf("%s was created without STATS_USERS=%s, the pgbouncer exporter cannot read its stats. Recreate it with `docker rm -f %s && oblivion postgres up`, clients are disconnected while it restarts",
+		cfg.Postgres.Bouncer.Name, c.Bouncer.User, cfg.Postgres.Bouncer.Name)
+}
+
 func (c *postgresCredentials) startBouncer(app *AppCtx) error {
 	exists, err := app.containerExists(cfg.Postgres.Bouncer.Name)
 	if err != nil {
 		return fmt.Errorf("failed to check if bouncer container exists: %w", err)
 	}
 	if exists {
+		if err := c.checkBouncerStatsUser(app); err != nil {
+			log.Warn().Err(err).Send()
+		}
 		color.Green("pgbouncer container running")
 		return nil
 	}
@@ -266,14 +305,17 @@ func (c *postgresCredentials) startBouncer(app *AppCtx) error {
 			AttachStdout: true,
 			AttachStderr: true,
 			Image:        cfg.Postgres.Bouncer.Image,
+			Labels:       managedLabels("postgres", "pgbouncer"),
 			Env: []string{
 				fmt.Sprintf("DB_HOST=%s", cfg.Postgres.Primary.Name),
 				"DB_PORT=5432",
 				"AUTH_USER=" + c.Bouncer.User,
+				// lets the pgbouncer exporter run SHOW commands against the admin console
+				"STATS_USERS=" + c.Bouncer.User,
 				"AUTH_FILE=/etc/pgbouncer/userlist.txt",
 				"AUTH_TYPE=scram-sha-256",
 				"AUTH_QUERY='SELECT p_user, p_password FROM public.lookup($1)'",
-				fmt.Sprintf("LISTEN_PORT=%s", cfg.Postgres.Bouncer.Port),
+				"LISTEN_PORT=" + pgbouncerInternalPort,
 				"LISTEN_ADDR=0.0.0.0",
 				"POOL_MODE=session",
 				"MAX_CLIENT_CONN=250",
@@ -286,12 +328,13 @@ func (c *postgresCredentials) startBouncer(app *AppCtx) error {
 				"IGNORE_STARTUP_PARAMETERS=extra_float_digits",
 			},
 			ExposedPorts: nat.PortSet{
-				"6432/tcp": struct{}{},
+				nat.Port(pgbouncerInternalPort + "/tcp"): struct{}{},
 			},
 		},
 		&container.HostConfig{
+			LogConfig: managedLogConfig("postgres", "pgbouncer"),
 			PortBindings: nat.PortMap{
-				nat.Port("6432/tcp"): []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: cfg.Postgres.Bouncer.Port}},
+				nat.Port(pgbouncerInternalPort + "/tcp"): []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: cfg.Postgres.Bouncer.Port}},
 			},
 		},
 		&network.NetworkingConfig{
@@ -301,7 +344,7 @@ func (c *postgresCredentials) startBouncer(app *AppCtx) error {
 		cfg.Postgres.Bouncer.Name,
 	)
 	if err != nil {
-		return fmt.Errorf("failed to create bouncer container: %w", err)
+		return fmt.Errorf("failed to create bouncer container: %w", internal.RedactError(err))
 	}
 	err = app.Docker.Client.ContainerStart(app.Context, response.ID, container.StartOptions{})
 	if err != nil {
@@ -311,11 +354,11 @@ func (c *postgresCredentials) startBouncer(app *AppCtx) error {
 		Cmd: []string{"/bin/sh", "-c", fmt.Sprintf("echo '%s %s' > /etc/pgbouncer/userlist.txt", c.Bouncer.User, c.Bouncer.Password)},
 	})
 	if err != nil {
-		return fmt.Errorf("failed to create exec command for bouncer: %w", err)
+		return fmt.Errorf("failed to create exec command for bouncer: %w", internal.RedactError(err))
 	}
 	err = app.Docker.Client.ContainerExecStart(app.Context, save_user_list.ID, container.ExecStartOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to start exec command for bouncer: %w", err)
+		return fmt.Errorf("failed to start exec command for bouncer: %w", internal.RedactError(err))
 	}
 	return nil
 }
@@ -0,0 +1,24 @@
+package cmd
+
+import "testing"
+
+func TestHasStatsUser(t *testing.T) {
+	tests := []struct {
+		name string
+		env  []string
+		want bool
+	}{
+		{name: "created by this version", env: []string{"AUTH_USER=bouncer", "STATS_USERS=bouncer"}, want: true},
+		{name: "one of several", env: []string{"STATS_USERS=admin, bouncer"}, want: true},
+		{name: "created before stats users", env: []string{"AUTH_USER=bouncer", "POOL_MODE=session"}, want: false},
+		{name: "another user", env: []string{"STATS_USERS=admin"}, want: false},
+		{name: "user only in another variable", env: []string{"ADMIN_USERS=bouncer"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasStatsUser(tt.env, "bouncer"); got != tt.want {
+				t.Errorf("hasStatsUser(%q) = %v, want %v", tt.env, got, tt.want)
+			}
+		})
+	}
+}
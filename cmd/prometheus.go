@@ -0,0 +1,379 @@
+package cmd
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caner-cetin/oblivion/internal"
+	"github.com/docker/go-connections/nat"
+	"github.com/fatih/color"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// ports the observer components listen on inside their containers, host ports are in [Observer.Ports]
+const (
+	prometheusInternalPort   = "9090"
+	cadvisorInternalPort     = "8080"
+	nodeExporterInternalPort = "9100"
+	alertmanagerInternalPort = "9093"
+	grafanaInternalPort      = "3000"
+	lokiInternalPort         = "3169"
+
+	// lifecycle and admin endpoints take no credentials, so prometheus is only published on the host's loopback.
+	// grafana reaches it over the docker network
+	prometheusHostIP = "127.0.0.1"
+	// prometheus_data is mounted here, snapshots are written to its snapshots directory
+	prometheusDataDir = "/prometheus"
+)
+
+type prometheusConfig struct {
+	Global        prometheusGlobal      `yaml:"global"`
+	RuleFiles     []string              `yaml:"rule_files"`
+	Alerting      prometheusAlerting    `yaml:"alerting"`
+	ScrapeConfigs []prometheusScrapeJob `yaml:"scrape_configs"`
+}
+
+type prometheusGlobal struct {
+	ScrapeInterval     string            `yaml:"scrape_interval"`
+	EvaluationInterval string            `yaml:"evaluation_interval"`
+	ExternalLabels     map[string]string `yaml:"external_labels"`
+}
+
+type prometheusAlerting struct {
+	Alertmanagers []prometheusAlertmanager `yaml:"alertmanagers"`
+}
+
+type prometheusAlertmanager struct {
+	Scheme        string                   `yaml:"scheme"`
+	StaticConfigs []prometheusStaticConfig `yaml:"static_configs"`
+}
+
+type prometheusScrapeJob struct {
+	JobName        string                   `yaml:"job_name"`
+	ScrapeInterval string                   `yaml:"scrape_interval,omitempty"`
+	MetricsPath    string                   `yaml:"metrics_path,omitempty"`
+	Scheme         string                   `yaml:"scheme,omitempty"`
+	Params         map[string][]string      `yaml:"params,omitempty"`
+	StaticConfigs  []prometheusStaticConfig `yaml:"static_configs"`
+	RelabelConfigs []prometheusRelabel      `yaml:"relabel_configs,omitempty"`
+}
+
+type prometheusRelabel struct {
+	SourceLabels []string `yaml:"source_labels,omitempty"`
+	TargetLabel  string   `yaml:"target_label"`
+	Replacement  string   `yaml:"replacement,omitempty"`
+}
+
+type prometheusStaticConfig struct {
+	Targets []string          `yaml:"targets"`
+	Labels  map[string]string `yaml:"labels,omitempty"`
+}
+
+func containerTarget(name string, port string) string {
+	return net.JoinHostPort(name, port)
+}
+
+// prometheusScrapeJobs returns a job for every container oblivion manages that exposes metrics,
+// addressed by container name on the grafana network, followed by [[Observer.Prometheus.ExtraJobs]].
+// Exporters are skipped with the service they watch, so only those named in exporters are scraped.
+func prometheusScrapeJobs(exporters map[string]bool) []prometheusScrapeJob {
+	type managedTarget struct {
+		job     string
+		target  string
+		enabled bool
+	}
+	managed := []managedTarget{
+		{"prometheus", containerTarget(cfg.Observer.ContainerNames.Prometheus, prometheusInternalPort), cfg.Observer.Enabled.Prometheus},
+		{"cadvisor", containerTarget(cfg.Observer.ContainerNames.Cadvisor, cadvisorInternalPort), cfg.Observer.Enabled.Cadvisor},
+		{"node-exporter", containerTarget(cfg.Observer.ContainerNames.NodeExporter, nodeExporterInternalPort), cfg.Observer.Enabled.NodeExporter},
+		{"alertmanager", containerTarget(cfg.Observer.ContainerNames.Alertmanager, alertmanagerInternalPort), cfg.Observer.Enabled.Alertmanager},
+		{"grafana", containerTarget(cfg.Observer.ContainerNames.Grafana, grafanaInternalPort), cfg.Observer.Enabled.Grafana},
+		{"loki", containerTarget(cfg.Observer.ContainerNames.Loki, lokiInternalPort), cfg.Observer.Enabled.Loki},
+		{"postgres", containerTarget(cfg.Observer.ContainerNames.PostgresExporter, postgresExporterInternalPort), exporters[cfg.Observer.ContainerNames.PostgresExporter]},
+		{"pgbouncer", containerTarget(cfg.Observer.ContainerNames.PgbouncerExporter, pgbouncerExporterInternalPort), exporters[cfg.Observer.ContainerNames.PgbouncerExporter]},
+		{"redis", containerTarget(cfg.Observer.ContainerNames.RedisExporter, redisExporterInternalPort), exporters[cfg.Observer.ContainerNames.RedisExporter]},
+		{"promtail", containerTarget(cfg.Observer.ContainerNames.Promtail, strconv.Itoa(promtailInternalPort)), cfg.Observer.Logs.Shipping == logShippingCollector},
+		{"blackbox", containerTarget(cfg.Observer.ContainerNames.BlackboxExporter, blackboxExporterInternalPort), probesEnabled()},
+	}
+	jobs := make([]prometheusScrapeJob, 0, len(managed)+len(cfg.Observer.Prometheus.ExtraJobs))
+	for _, m := range managed {
+		if !m.enabled {
+			continue
+		}
+		jobs = append(jobs, prometheusScrapeJob{
+			JobName:       m.job,
+			StaticConfigs: []prometheusStaticConfig{{Targets: []string{m.target}}},
+		})
+	}
+	jobs = append(jobs, probeScrapeJobs()...)
+	for _, extra := range cfg.Observer.Prometheus.ExtraJobs {
+		jobs = append(jobs, prometheusScrapeJob{
+			JobName:        extra.Name,
+			ScrapeInterval: extra.ScrapeInterval,
+			MetricsPath:    extra.MetricsPath,
+			Scheme:         extra.Scheme,
+			StaticConfigs:  []prometheusStaticConfig{{Targets: extra.Targets}},
+		})
+	}
+	return jobs
+}
+
+// renderPrometheusConfig generates prometheus.yml from the current config, ruleFiles are relative to /etc/prometheus/.
+// exporters are the exporter containers that exist, see existingExporters.
+func renderPrometheusConfig(ruleFiles []string, exporters map[string]bool) ([]byte, error) {
+	promCfg := prometheusConfig{
+		Global: prometheusGlobal{
+			ScrapeInterval:     cfg.Observer.Prometheus.ScrapeInterval,
+			EvaluationInterval: cfg.Observer.Prometheus.EvaluationInterval,
+			ExternalLabels:     map[string]string{"monitor": "cansu.dev"},
+		},
+		RuleFiles: ruleFiles,
+		Alerting: prometheusAlerting{
+			Alertmanagers: []prometheusAlertmanager{},
+		},
+		ScrapeConfigs: prometheusScrapeJobs(exporters),
+	}
+	// rules are still evaluated without alertmanager, firing alerts are only visible in prometheus
+	if cfg.Observer.Enabled.Alertmanager {
+		promCfg.Alerting.Alertmanagers = append(promCfg.Alerting.Alertmanagers, prometheusAlertmanager{
+			Scheme: "http",
+			StaticConfigs: []prometheusStaticConfig{{
+				Targets: []string{containerTarget(cfg.Observer.ContainerNames.Alertmanager, alertmanagerInternalPort)},
+			}},
+		})
+	}
+	out, err := internal.MarshalYAML(&promCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal prometheus config: %w", err)
+	}
+	return append([]byte("# generated by oblivion from [Observer] config, edits are overwritten on observer up\n"), out...), nil
+}
+
+// prometheusFiles returns prometheus.yml together with the rule files it loads
+func prometheusFiles(rules map[string][]byte, exporters map[string]bool) (map[string][]byte, error) {
+	files := make(map[string][]byte, len(rules)+1)
+	names := make([]string, 0, len(rules))
+	for name, contents := range rules {
+		files[name] = contents
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	promYml, err := renderPrometheusConfig(names, exporters)
+	if err != nil {
+		return nil, err
+	}
+	files["prometheus.yml"] = promYml
+	return files, nil
+}
+
+// prometheusArgs returns the command line of the prometheus container, flags are fixed at creation
+func prometheusArgs() []string {
+	args := []string{
+		"--config.file=/etc/prometheus/prometheus.yml",
+		"--storage.tsdb.path=" + prometheusDataDir,
+		"--storage.tsdb.retention.time=" + cfg.Observer.Prometheus.RetentionTime,
+		"--web.console.libraries=/usr/share/prometheus/console_libraries",
+		"--web.console.templates=/usr/share/prometheus/consoles",
+		// lets observer up hot-reload the rendered prometheus.yml
+		"--web.enable-lifecycle",
+	}
+	if cfg.Observer.Prometheus.RetentionSize != "" {
+		args = append(args, "--storage.tsdb.retention.size="+cfg.Observer.Prometheus.RetentionSize)
+	}
+	if cfg.Observer.Prometheus.AdminAPI {
+		// observer prometheus snapshot
+		args = append(args, "--web.enable-admin-api")
+	}
+	return args
+}
+
+// checkPrometheusArgs warns when the running container was created with other flags than the config asks for now
+func (a *AppCtx) checkPrometheusArgs() error {
+	inspect, err := a.Docker.Client.ContainerInspect(a.Context, cfg.Observer.ContainerNames.Prometheus)
+	if err != nil {
+		return fmt.Errorf("failed to inspect prometheus container: %w", err)
+	}
+	if !slices.Equal(inspect.Config.Cmd, prometheusArgs()) {
+		log.Warn().
+			Strs("current", inspect.Config.Cmd).
+			Strs("wanted", prometheusArgs()).
+			Msg("prometheus flags differ from the config, recreate it with observer down prometheus && observer up prometheus, data is kept")
+	}
+	for _, binding := range inspect.HostConfig.PortBindings[nat.Port(prometheusInternalPort+"/tcp")] {
+		if binding.HostIP != prometheusHostIP {
+			log.Warn().
+				Str("address", binding.HostIP).
+				Msg("prometheus is published beyond localhost without authentication on /-/quit, recreate it with observer down prometheus && observer up prometheus, data is kept")
+		}
+	}
+	return nil
+}
+
+func prometheusURL() string {
+	return "http://" + net.JoinHostPort("localhost", cfg.Observer.Ports.Prometheus)
+}
+
+// asks a running prometheus to re-read its configuration, requires --web.enable-lifecycle
+func (a *AppCtx) reloadPrometheus() error {
+	if err := a.postReload(prometheusURL()); err != nil {
+		return fmt.Errorf("%w, containers created before lifecycle was enabled must be recreated", err)
+	}
+	return nil
+}
+
+// prometheus and alertmanager both re-read their configuration on POST /-/reload
+func (a *AppCtx) postReload(baseURL string) error {
+	req, err := http.NewRequestWithContext(a.Context, http.MethodPost, baseURL+"/-/reload", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create reload request: %w", err)
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s: %w", baseURL, err)
+	}
+	defer internal.CloseReader(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("reload returned %s", resp.Status)
+	}
+	return nil
+}
+
+var (
+	observerPrometheusSnapshotCmd = &cobra.Command{
+		Use:   "snapshot",
+		Short: "take a TSDB snapshot through the admin api and archive it on the host",
+		Run:   WrapCommandWithResources(observerPrometheusSnapshot, ResourceConfig{Resources: []ResourceType{ResourceDocker}}),
+	}
+	observerPrometheusCmd = &cobra.Command{
+		Use: "prometheus",
+	}
+	snapshotOutputDir string
+	snapshotSkipHead  bool
+	snapshotKeep      bool
+)
+
+func getObserverPrometheusCmd() *cobra.Command {
+	observerPrometheusSnapshotCmd.Flags().StringVar(&snapshotOutputDir, "output", ".", "directory the archive is written to")
+	observerPrometheusSnapshotCmd.Flags().BoolVar(&snapshotSkipHead, "skip-head", false, "leave out samples that are still in memory and not yet compacted to disk")
+	observerPrometheusSnapshotCmd.Flags().BoolVar(&snapshotKeep, "keep", false, "keep the snapshot in prometheus_data after archiving it")
+	observerPrometheusCmd.AddCommand(observerPrometheusSnapshotCmd)
+	return observerPrometheusCmd
+}
+
+type prometheusSnapshotResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		Name string `json:"name"`
+	} `json:"data"`
+}
+
+// takeSnapshot asks prometheus for a TSDB snapshot and returns its name under the snapshots directory, requires --web.enable-admin-api
+func (a *AppCtx) takeSnapshot(skipHead bool) (string, error) {
+	if !cfg.Observer.Prometheus.AdminAPI {
+		return "", fmt.Errorf("snapshots need the admin api, set [Observer.Prometheus] admin_api = true and recreate prometheus")
+	}
+	endpoint := prometheusURL() + "/api/v1/admin/tsdb/snapshot?skip_head=" + strconv.FormatBool(skipHead)
+	req, err := http.NewRequestWithContext(a.Context, http.MethodPost, endpoint, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create snapshot request: %w", err)
+	}
+	// snapshots hard link blocks but the head block is written out, that takes a while on large instances
+	client := http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to reach prometheus: %w", err)
+	}
+	defer internal.CloseReader(resp.Body)
+	var snapshot prometheusSnapshotResponse
+	if err := json.NewDecoder(resp.Body).Decode(&snapshot); err != nil {
+		return "", fmt.Errorf("failed to decode snapshot response: %w", err)
+	}
+	if snapshot.Status != "success" {
+		if strings.Contains(snapshot.Error, "admin APIs disabled") {
+			return "", fmt.Errorf("%s, containers created before it was enabled must be recreated", snapshot.Error)
+		}
+		return "", fmt.Errorf("snapshot failed with %s: %s", resp.Status, snapshot.Error)
+	}
+	return snapshot.Data.Name, nil
+}
+
+// archiveSnapshot streams a snapshot out of the prometheus container into a gzipped tar at dest.
+// The archive is written next to dest first, so an interrupted copy never leaves a truncated file behind.
+func (a *AppCtx) archiveSnapshot(name string, dest string) (int64, error) {
+	reader, _, err := a.Docker.Client.CopyFromContainer(a.Context, cfg.Observer.ContainerNames.Prometheus, path.Join(prometheusDataDir, "snapshots", name))
+	if err != nil {
+		return 0, fmt.Errorf("failed to copy snapshot from container: %w", err)
+	}
+	defer internal.CloseReader(reader)
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".prometheus-snapshot-*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create archive: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	gz := gzip.NewWriter(tmp)
+	if _, err := io.Copy(gz, reader); err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("failed to finish archive: %w", err)
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("failed to stat archive: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, fmt.Errorf("failed to close archive: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return 0, fmt.Errorf("failed to move archive into place: %w", err)
+	}
+	return info.Size(), nil
+}
+
+func observerPrometheusSnapshot(cmd *cobra.Command, args []string) {
+	app := GetApp(cmd)
+	if err := os.MkdirAll(snapshotOutputDir, 0755); err != nil {
+		log.Error().Err(err).Msg("failed to create output directory")
+		return
+	}
+	app.Spinner.Prefix = "taking snapshot"
+	name, err := app.takeSnapshot(snapshotSkipHead)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	dest := filepath.Join(snapshotOutputDir, fmt.Sprintf("prometheus-snapshot-%s.tar.gz", time.Now().Format("20060102-150405")))
+	app.Spinner.Prefix = fmt.Sprintf("archiving snapshot %s", name)
+	size, err := app.archiveSnapshot(name, dest)
+	if err != nil {
+		log.Error().Err(err).Str("snapshot", name).Msg("snapshot is left in prometheus_data")
+		return
+	}
+	if !snapshotKeep {
+		// hard links keep the blocks alive on disk after compaction deletes them, so snapshots are not left around
+		app.Spinner.Prefix = "removing snapshot from volume"
+		if _, err := app.execInContainer(cfg.Observer.ContainerNames.Prometheus, []string{"rm", "-rf", path.Join(prometheusDataDir, "snapshots", name)}, nil, nil); err != nil {
+			log.Warn().Err(err).Str("snapshot", name).Msg("failed to remove snapshot from prometheus_data")
+		}
+	}
+	app.Spinner.Stop()
+	color.Green("snapshot %s written to %s (%.1f MiB)", name, dest, float64(size)/(1<<20))
+}
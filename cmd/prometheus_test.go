@@ -0,0 +1,255 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/caner-cetin/oblivion/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRenderPrometheusConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		modify    func(c *config.Root)
+		ruleFiles []string
+		// jobs of the exporter containers that exist
+		exporters     []string
+		jobs          []string
+		alertmanagers int
+	}{
+		{
+			name:          "defaults",
+			modify:        func(c *config.Root) {},
+			ruleFiles:     []string{"rules/oblivion.yml"},
+			exporters:     []string{"postgres", "pgbouncer", "redis"},
+			jobs:          []string{"prometheus", "cadvisor", "node-exporter", "alertmanager", "grafana", "loki", "postgres", "pgbouncer", "redis"},
+			alertmanagers: 1,
+		},
+		{
+			name:          "collector",
+			modify:        func(c *config.Root) { c.Observer.Logs.Shipping = "collector" },
+			exporters:     []string{"postgres", "pgbouncer", "redis"},
+			jobs:          []string{"prometheus", "cadvisor", "node-exporter", "alertmanager", "grafana", "loki", "postgres", "pgbouncer", "redis", "promtail"},
+			alertmanagers: 1,
+		},
+		{
+			name:          "exporters that were skipped are not scraped",
+			modify:        func(c *config.Root) {},
+			exporters:     []string{"redis"},
+			jobs:          []string{"prometheus", "cadvisor", "node-exporter", "alertmanager", "grafana", "loki", "redis"},
+			alertmanagers: 1,
+		},
+		{
+			name: "disabled components are not scraped",
+			modify: func(c *config.Root) {
+				c.Observer.Enabled.Cadvisor = false
+				c.Observer.Enabled.Alertmanager = false
+				c.Observer.Enabled.Loki = false
+				c.Observer.Logs.Shipping = "off"
+			},
+			exporters: []string{"postgres", "pgbouncer", "redis"},
+			jobs:      []string{"prometheus", "node-exporter", "grafana", "postgres", "pgbouncer", "redis"},
+		},
+		{
+			name: "probes and extra jobs come last",
+			modify: func(c *config.Root) {
+				c.Observer.Logs.Shipping = "driver"
+				c.Observer.Probes.Targets = []config.ProbeConfig{{Name: "site", URL: "https://cansu.dev"}}
+				c.Observer.Prometheus.ExtraJobs = []config.ScrapeJobConfig{{Name: "app", Targets: []string{"app:9000"}, MetricsPath: "/stats"}}
+			},
+			exporters:     []string{"postgres", "pgbouncer", "redis"},
+			jobs:          []string{"prometheus", "cadvisor", "node-exporter", "alertmanager", "grafana", "loki", "postgres", "pgbouncer", "redis", "blackbox", "blackbox-http_200", "app"},
+			alertmanagers: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestConfig(t, tt.modify)
+			names := map[string]string{
+				"postgres":  cfg.Observer.ContainerNames.PostgresExporter,
+				"pgbouncer": cfg.Observer.ContainerNames.PgbouncerExporter,
+				"redis":     cfg.Observer.ContainerNames.RedisExporter,
+			}
+			exporters := make(map[string]bool)
+			for _, job := range tt.exporters {
+				exporters[names[job]] = true
+			}
+			out, err := renderPrometheusConfig(tt.ruleFiles, exporters)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(string(out), "# generated by oblivion") {
+				t.Errorf("missing generated header:\n%s", out)
+			}
+			var got prometheusConfig
+			if err := yaml.Unmarshal(out, &got); err != nil {
+				t.Fatalf("rendered config is not valid yaml: %v\n%s", err, out)
+			}
+			var jobs []string
+			for _, job := range got.ScrapeConfigs {
+				jobs = append(jobs, job.JobName)
+				if len(job.StaticConfigs) == 0 || len(job.StaticConfigs[0].Targets) == 0 {
+					t.Errorf("job %s has no targets", job.JobName)
+				}
+			}
+			if !slices.Equal(jobs, tt.jobs) {
+				t.Errorf("jobs = %v, want %v", jobs, tt.jobs)
+			}
+			if !slices.Equal(got.RuleFiles, tt.ruleFiles) {
+				t.Errorf("rule_files = %v, want %v", got.RuleFiles, tt.ruleFiles)
+			}
+			if len(got.Alerting.Alertmanagers) != tt.alertmanagers {
+				t.Errorf("%d alertmanagers, want %d", len(got.Alerting.Alertmanagers), tt.alertmanagers)
+			}
+			if got.Global.ScrapeInterval != cfg.Observer.Prometheus.ScrapeInterval {
+				t.Errorf("scrape_interval = %q", got.Global.ScrapeInterval)
+			}
+		})
+	}
+}
+
+func TestPrometheusScrapeJobsTargets(t *testing.T) {
+	setTestConfig(t, func(c *config.Root) {
+		c.Observer.ContainerNames.RedisExporter = "redis-exporter"
+		c.Observer.Prometheus.ExtraJobs = []config.ScrapeJobConfig{{Name: "app", Targets: []string{"app:9000"}, Scheme: "https", ScrapeInterval: "30s"}}
+	})
+	jobs := prometheusScrapeJobs(map[string]bool{"redis-exporter": true})
+	byName := make(map[string]prometheusScrapeJob)
+	for _, job := range jobs {
+		byName[job.JobName] = job
+	}
+	if got := byName["redis"].StaticConfigs[0].Targets; !slices.Equal(got, []string{"redis-exporter:" + redisExporterInternalPort}) {
+		t.Errorf("redis targets = %v", got)
+	}
+	app := byName["app"]
+	if app.Scheme != "https" || app.ScrapeInterval != "30s" || !slices.Equal(app.StaticConfigs[0].Targets, []string{"app:9000"}) {
+		t.Errorf("app job = %+v", app)
+	}
+}
+
+func TestPrometheusArgs(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *config.Root)
+		want   []string
+		absent []string
+	}{
+		{
+			name:   "defaults",
+			modify: func(c *config.Root) {},
+			want:   []string{"--storage.tsdb.retention.time=15d", "--web.enable-lifecycle"},
+			absent: []string{"--web.enable-admin-api"},
+		},
+		{
+			name: "retention size and admin api",
+			modify: func(c *config.Root) {
+				c.Observer.Prometheus.RetentionTime = "1y"
+				c.Observer.Prometheus.RetentionSize = "10GB"
+				c.Observer.Prometheus.AdminAPI = true
+			},
+			want: []string{"--storage.tsdb.retention.time=1y", "--storage.tsdb.retention.size=10GB", "--web.enable-admin-api"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestConfig(t, tt.modify)
+			args := prometheusArgs()
+			for _, arg := range tt.want {
+				if !slices.Contains(args, arg) {
+					t.Errorf("%s missing from %v", arg, args)
+				}
+			}
+			for _, arg := range tt.absent {
+				if slices.Contains(args, arg) {
+					t.Errorf("%s in %v", arg, args)
+				}
+			}
+			if !slices.Equal(args, prometheusArgs()) {
+				t.Errorf("prometheusArgs() is not stable, containers would always look outdated")
+			}
+		})
+	}
+}
+
+func TestTakeSnapshot(t *testing.T) {
+	tests := []struct {
+		name     string
+		adminAPI bool
+		status   int
+		body     string
+		want     string
+		wantErr  string
+	}{
+		{
+			name:     "snapshot",
+			adminAPI: true,
+			status:   http.StatusOK,
+			body:     `{"status":"success","data":{"name":"20261019T120000Z-6b0f0a1c"}}`,
+			want:     "20261019T120000Z-6b0f0a1c",
+		},
+		{
+			name:     "container created without the admin api",
+			adminAPI: true,
+			status:   http.StatusUnavailableForLegalReasons,
+			body:     `{"status":"error","errorType":"unavailable","error":"admin APIs disabled"}`,
+			wantErr:  "must be recreated",
+		},
+		{
+			name:     "prometheus error",
+			adminAPI: true,
+			status:   http.StatusInternalServerError,
+			body:     `{"status":"error","error":"create snapshot: no space left on device"}`,
+			wantErr:  "no space left on device",
+		},
+		{
+			name:    "admin api disabled in config",
+			wantErr: "admin_api = true",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r.Method+" "+r.URL.RequestURI())
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}))
+			defer server.Close()
+			_, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			setTestConfig(t, func(c *config.Root) {
+				c.Observer.Ports.Prometheus = port
+				c.Observer.Prometheus.AdminAPI = tt.adminAPI
+			})
+			app := AppCtx{Context: context.Background()}
+			got, err := app.takeSnapshot(true)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("takeSnapshot() = %q, %v, want an error containing %q", got, err, tt.wantErr)
+				}
+				if !tt.adminAPI && len(requests) != 0 {
+					t.Errorf("prometheus was asked without the admin api: %v", requests)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("takeSnapshot() = %q, want %q", got, tt.want)
+			}
+			if want := []string{"POST /api/v1/admin/tsdb/snapshot?skip_head=true"}; !slices.Equal(requests, want) {
+				t.Errorf("requests = %v, want %v", requests, want)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"github.com/caner-cetin/oblivion/internal"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
@@ -35,20 +36,22 @@ func redisUp(cmd *cobra.Command, args []string) {
 		color.Cyan("redis running")
 		return
 	}
-	password, err := app.Vault.Client.Secrets().Resolve(app.Context, app.Vault.Prefix+"/Redis/password")
+	password, err := app.resolveSecret("/Redis/password")
 	if err != nil {
 		log.Error().Err(err).Msg("failed to get redis password")
 		return
 	}
 	resp, err := app.Docker.Client.ContainerCreate(app.Context,
 		&container.Config{
-			Image: cfg.Dragonfly.Image,
-			Cmd:   []string{"dragonfly", "--requirepass", password},
+			Image:  cfg.Dragonfly.Image,
+			Labels: managedLabels("redis", "dragonfly"),
+			Cmd:    []string{"dragonfly", "--requirepass", password},
 			Env: []string{
 				"REDIS_PASSWORD=" + password,
 			},
 		},
 		&container.HostConfig{
+			LogConfig:     managedLogConfig("redis", "dragonfly"),
 			RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyAlways},
 			Mounts: []mount.Mount{
 				{
@@ -67,7 +70,7 @@ func redisUp(cmd *cobra.Command, args []string) {
 		nil,
 		cfg.Dragonfly.ContainerName)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to create redis container")
+		log.Error().Err(internal.RedactError(err)).Msg("failed to create redis container")
 		return
 	}
 	if err := app.Docker.Client.ContainerStart(app.Context, resp.ID, container.StartOptions{}); err != nil {
@@ -3,9 +3,11 @@ package cmd
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 
 	"github.com/caner-cetin/oblivion/internal"
 	"github.com/caner-cetin/oblivion/internal/config"
@@ -17,10 +19,99 @@ import (
 var cfg = &config.Config
 var cfgPath string
 
+// which layer every config key came from, filled by initConfig
+var cfgProvenance config.Provenance
+
+// set by commands that fail without an error to return, Execute exits with it once the
+// command, and with it the cleanup of WrapCommandWithResources, has returned
+var exitCode int
+
 var rootCmd = &cobra.Command{
 	Use:   "oblivion",
 	Short: "deployment setup for cansu.dev",
 	Long:  `A DEAD ROAD, A DARK SUN, NOW WAITS BEYOND OBLIIVIIOOOOOOOOOOOOOOOON`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if skipsValidation(cmd) {
+			return
+		}
+		checkConfigVersion()
+		if err := validateConfigFor(cmd); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintf(os.Stderr, "fix %s or run `oblivion config validate` for details\n", cfgPath)
+			os.Exit(1)
+		}
+	},
+}
+
+// read by every command that creates containers, their logging driver comes from [Observer.Logs] and the loki port
+var containerConfigKeys = []string{"Docker", "Networks", "Onepass", "Observer.Logs", "Observer.Ports.loki"}
+
+// config keys each top-level command reads. Problems elsewhere in the config are only warned about, so a typo in
+// [Kuma] does not stop static up. Commands missing here need the whole config to be valid.
+var commandConfigKeys = map[string][]string{
+	"version":    {},
+	"secrets":    {"Onepass"},
+	"network":    {"Docker", "Networks"},
+	"postgres":   slices.Concat(containerConfigKeys, []string{"Postgres"}),
+	"redis":      slices.Concat(containerConfigKeys, []string{"Dragonfly"}),
+	"kuma":       slices.Concat(containerConfigKeys, []string{"Kuma"}),
+	"static":     slices.Concat(containerConfigKeys, []string{"Static"}),
+	"playground": slices.Concat(containerConfigKeys, []string{"Playground", "Postgres", "Dragonfly"}),
+	// exporters log into postgres and redis
+	"observer": slices.Concat(containerConfigKeys, []string{"Observer", "Postgres", "Dragonfly"}),
+}
+
+// validateConfigFor fails on problems in the config keys cmd reads and warns about the rest
+func validateConfigFor(cmd *cobra.Command) error {
+	err := cfg.Validate(cfgProvenance)
+	var validationErr *config.ValidationError
+	if !errors.As(err, &validationErr) {
+		return err
+	}
+	top := cmd
+	// the command right below the root
+	for top.HasParent() && top.Parent().HasParent() {
+		top = top.Parent()
+	}
+	keys, ok := commandConfigKeys[top.Name()]
+	if !ok {
+		return err
+	}
+	problems, other := validationErr.Within(keys)
+	for _, p := range other {
+		log.Warn().Str("key", p.Key).Msgf("%s, not used by %s", p.Message, top.Name())
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return &config.ValidationError{Problems: problems}
+}
+
+// refuses to run on a config file older than this build, only config migrate rewrites the file
+func checkConfigVersion() {
+	contents, err := internal.ReadFile(cfgPath)
+	if err != nil {
+		log.Fatal().Err(err).Str("path", cfgPath).Msg("failed to read config file")
+	}
+	version, err := config.FileVersion(contents)
+	if err != nil {
+		log.Fatal().Err(err).Str("path", cfgPath).Send()
+	}
+	if version < config.CurrentVersion {
+		fmt.Fprintf(os.Stderr, "%s is at config version %d, this build needs version %d\n", cfgPath, version, config.CurrentVersion)
+		fmt.Fprintln(os.Stderr, "review the upgrade with `oblivion config migrate --dry-run` and apply it with `oblivion config migrate`")
+		os.Exit(1)
+	}
+}
+
+// config commands must work on a broken config, they are how you inspect and fix it
+func skipsValidation(cmd *cobra.Command) bool {
+	for c := cmd; c != nil; c = c.Parent() {
+		if c == configCmd || c.Name() == "help" || c.Name() == "completion" {
+			return true
+		}
+	}
+	return false
 }
 
 func Execute() {
@@ -28,12 +119,18 @@ func Execute() {
 	if err != nil {
 		os.Exit(1)
 	}
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
 }
 
 func init() {
-	initConfig()
-	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
-	rootCmd.Flags().StringVar(&cfgPath, "config", "", "toml path (default $HOME/.oblivion.toml)")
+	// every resolved secret is registered in internal, scrub them from anything we print
+	log.Logger = log.Output(internal.NewRedactWriter(os.Stderr))
+	rootCmd.SetErr(internal.NewRedactWriter(os.Stderr))
+	// flags are parsed by the time cobra runs initializers, so --config is honoured on every subcommand
+	cobra.OnInitialize(initConfig)
+	rootCmd.PersistentFlags().StringVar(&cfgPath, "config", "", "toml path (default $HOME/.oblivion.toml)")
 	rootCmd.AddCommand(getPostgresCmd())
 	rootCmd.AddCommand(getVersionCmd())
 	rootCmd.AddCommand(getKumaCmd())
@@ -42,8 +139,12 @@ func init() {
 	rootCmd.AddCommand(getObserverCmd())
 	rootCmd.AddCommand(getPlaygroundCmd())
 	rootCmd.AddCommand(getRedisCmd())
+	rootCmd.AddCommand(getSecretsCmd())
+	rootCmd.AddCommand(getConfigCmd())
 }
 
+// initConfig loads defaults, the config file, its per-host overlay and OBLIVION_* environment overrides.
+// The config file is created with default values if it does not exist.
 func initConfig() {
 	if cfgPath == "" {
 		home, err := os.UserHomeDir()
@@ -52,26 +153,38 @@ func initConfig() {
 		}
 		cfgPath = filepath.Join(home, ".oblivion.toml")
 	}
-	cfg.SetDefaults()
-	contents, err := internal.ReadFile(cfgPath)
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			var cfgBytes []byte
-			if cfgBytes, err = toml.Marshal(cfg); err != nil {
-				log.Fatal().Err(err).Msg("failed to marshal default config")
-			}
-			cfgFile, err := os.Create(cfgPath)
-			if err != nil {
-				log.Fatal().Str("path", cfgPath).Err(err).Msg("failed to create config file")
-			}
-			if _, err := io.Copy(cfgFile, bytes.NewReader(cfgBytes)); err != nil {
-				log.Fatal().Err(err).Msg("failed to save default config")
-			}
-		} else {
+	if _, err := os.Stat(cfgPath); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
 			log.Fatal().Err(err).Str("path", cfgPath).Msg("failed to read config file")
 		}
+		if err := writeDefaultConfig(cfgPath); err != nil {
+			log.Fatal().Err(err).Str("path", cfgPath).Msg("failed to save default config")
+		}
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to get hostname, skipping per-host config overlay")
+	}
+	loader := config.Loader{Path: cfgPath, Hostname: hostname}
+	if cfgProvenance, err = loader.Load(cfg); err != nil {
+		log.Fatal().Err(err).Msg("failed to load config")
+	}
+}
+
+func writeDefaultConfig(path string) error {
+	var defaults config.Root
+	defaults.SetDefaults()
+	cfgBytes, err := toml.Marshal(&defaults)
+	if err != nil {
+		return fmt.Errorf("failed to marshal default config: %w", err)
+	}
+	cfgFile, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create config file: %w", err)
 	}
-	if err := toml.Unmarshal(contents, cfg); err != nil {
-		log.Fatal().Err(err).Msg("failed to unmarshal config")
+	defer cfgFile.Close()
+	if _, err := io.Copy(cfgFile, bytes.NewReader(cfgBytes)); err != nil {
+		return fmt.Errorf("failed to write default config: %w", err)
 	}
+	return nil
 }
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/caner-cetin/oblivion/internal/config"
+	"github.com/spf13/cobra"
+)
+
+// setTestConfig replaces the loaded config with the defaults changed by modify for the rest of the test
+func setTestConfig(t *testing.T, modify func(c *config.Root)) {
+	t.Helper()
+	saved := *cfg
+	t.Cleanup(func() { *cfg = saved })
+	*cfg = config.Root{}
+	cfg.SetDefaults()
+	modify(cfg)
+}
+
+func TestValidateConfigFor(t *testing.T) {
+	root := &cobra.Command{Use: "oblivion"}
+	for _, name := range []string{"static", "kuma", "unscoped"} {
+		parent := &cobra.Command{Use: name}
+		parent.AddCommand(&cobra.Command{Use: "up"})
+		root.AddCommand(parent)
+	}
+	tests := []struct {
+		name   string
+		args   []string
+		modify func(c *config.Root)
+		want   []string
+	}{
+		{
+			name:   "valid config",
+			args:   []string{"static", "up"},
+			modify: func(c *config.Root) {},
+		},
+		{
+			name:   "problem in another section is a warning",
+			args:   []string{"static", "up"},
+			modify: func(c *config.Root) { c.Kuma.Monitors = []config.KumaMonitorConfig{{Name: "site", Type: "ping"}} },
+		},
+		{
+			name:   "problem in the command's section",
+			args:   []string{"kuma", "up"},
+			modify: func(c *config.Root) { c.Kuma.Monitors = []config.KumaMonitorConfig{{Name: "site", Type: "ping"}} },
+			want:   []string{"Kuma.Monitors[0].type"},
+		},
+		{
+			name:   "log shipping applies to every container",
+			args:   []string{"static", "up"},
+			modify: func(c *config.Root) { c.Observer.Logs.Shipping = "syslog" },
+			want:   []string{"Observer.Logs.shipping"},
+		},
+		{
+			name:   "commands without a scope need the whole config",
+			args:   []string{"unscoped", "up"},
+			modify: func(c *config.Root) { c.Kuma.Monitors = []config.KumaMonitorConfig{{Name: "site", Type: "ping"}} },
+			want:   []string{"Kuma.Monitors[0].type"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestConfig(t, func(c *config.Root) {
+				c.Static.StaticPath = t.TempDir()
+				tt.modify(c)
+			})
+			cmd, _, err := root.Find(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = validateConfigFor(cmd)
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var validationErr *config.ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("validateConfigFor() = %v, want a validation error", err)
+			}
+			var keys []string
+			for _, p := range validationErr.Problems {
+				keys = append(keys, p.Key)
+			}
+			if !slices.Equal(keys, tt.want) {
+				t.Errorf("problems = %v, want %v", keys, tt.want)
+			}
+		})
+	}
+}
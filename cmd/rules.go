@@ -0,0 +1,222 @@
+package cmd
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/caner-cetin/oblivion/internal"
+	"github.com/fatih/color"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// rule pack for the services oblivion manages, deployed unless [Observer.Prometheus] builtin_rules is false
+//
+//go:embed config/rules/oblivion.yml
+var builtinRules []byte
+
+const (
+	// relative to /etc/prometheus/
+	prometheusRulesDir = "rules"
+)
+
+var (
+	observerRulesListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "list the rules prometheus has loaded and the alerts currently pending or firing",
+		Run:   WrapCommandWithResources(observerRulesList, ResourceConfig{}),
+	}
+	observerRulesValidateCmd = &cobra.Command{
+		Use:   "validate [rule-file]...",
+		Short: "check rule files the way prometheus loads them, defaults to the rules observer up would deploy",
+		Run:   WrapCommandWithResources(observerRulesValidate, ResourceConfig{}),
+	}
+	observerRulesCmd = &cobra.Command{
+		Use: "rules",
+	}
+)
+
+func getObserverRulesCmd() *cobra.Command {
+	observerRulesCmd.AddCommand(observerRulesListCmd)
+	observerRulesCmd.AddCommand(observerRulesValidateCmd)
+	return observerRulesCmd
+}
+
+// rule files deployed into prometheus, keyed by their path relative to /etc/prometheus/
+func prometheusRuleFiles() (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	if cfg.Observer.Prometheus.BuiltinRules {
+		files[path.Join(prometheusRulesDir, "oblivion.yml")] = builtinRules
+	}
+	for _, p := range cfg.Observer.Prometheus.RuleFiles {
+		contents, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read rule file: %w", err)
+		}
+		files[path.Join(prometheusRulesDir, "custom", filepath.Base(p))] = contents
+	}
+	if probesEnabled() {
+		probeRules, err := renderProbeRules()
+		if err != nil {
+			return nil, err
+		}
+		files[probeRuleFile()] = probeRules
+	}
+	return files, nil
+}
+
+// checkRules parses files with the rule parser prometheus loads them with, so a file that passes here is accepted
+// by prometheus. Returns a summary per file, the error lists every problem.
+func checkRules(files map[string][]byte) (string, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var (
+		summary  []string
+		problems []string
+	)
+	for _, name := range names {
+		groups, errs := rulefmt.Parse(files[name], false)
+		for _, err := range errs {
+			problems = append(problems, fmt.Sprintf("%s: %s", name, err))
+		}
+		if len(errs) > 0 {
+			continue
+		}
+		rules := 0
+		for _, group := range groups.Groups {
+			rules += len(group.Rules)
+		}
+		summary = append(summary, fmt.Sprintf("%s: %d rules found", name, rules))
+	}
+	if len(problems) > 0 {
+		return "", fmt.Errorf("rule files failed validation:\n%s", strings.Join(problems, "\n"))
+	}
+	return strings.Join(summary, "\n"), nil
+}
+
+func observerRulesValidate(cmd *cobra.Command, args []string) {
+	app := GetApp(cmd)
+	files, err := prometheusRuleFiles()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	if len(args) > 0 {
+		files = make(map[string][]byte, len(args))
+		for _, p := range args {
+			contents, err := os.ReadFile(p)
+			if err != nil {
+				log.Error().Err(err).Msg("failed to read rule file")
+				return
+			}
+			files[path.Join(prometheusRulesDir, filepath.Base(p))] = contents
+		}
+	}
+	if len(files) == 0 {
+		color.Yellow("no rule files to validate, builtin_rules is off and rule_files is empty")
+		return
+	}
+	output, err := checkRules(files)
+	app.Spinner.Stop()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exitCode = 1
+		return
+	}
+	fmt.Println(output)
+	color.Green("%d rule file(s) valid", len(files))
+}
+
+type prometheusRulesResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		Groups []struct {
+			Name  string `json:"name"`
+			File  string `json:"file"`
+			Rules []struct {
+				Name   string `json:"name"`
+				Type   string `json:"type"`
+				State  string `json:"state"`
+				Health string `json:"health"`
+				Alerts []struct {
+					Labels   map[string]string `json:"labels"`
+					State    string            `json:"state"`
+					ActiveAt time.Time         `json:"activeAt"`
+				} `json:"alerts"`
+			} `json:"rules"`
+		} `json:"groups"`
+	} `json:"data"`
+}
+
+func observerRulesList(cmd *cobra.Command, args []string) {
+	app := GetApp(cmd)
+	req, err := http.NewRequestWithContext(app.Context, http.MethodGet, prometheusURL()+"/api/v1/rules", nil)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to create rules request")
+		return
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to reach prometheus")
+		return
+	}
+	defer internal.CloseReader(resp.Body)
+	var rules prometheusRulesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&rules); err != nil {
+		log.Error().Err(err).Msg("failed to decode prometheus rules")
+		return
+	}
+	if rules.Status != "success" {
+		log.Error().Str("status", resp.Status).Msgf("prometheus returned an error: %s", rules.Error)
+		return
+	}
+	app.Spinner.Stop()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "GROUP\tRULE\tTYPE\tSTATE\tHEALTH")
+	for _, group := range rules.Data.Groups {
+		for _, rule := range group.Rules {
+			state := rule.State
+			if rule.Type == "recording" {
+				state = "-"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", group.Name, rule.Name, rule.Type, state, rule.Health)
+			for _, alert := range rule.Alerts {
+				fmt.Fprintf(tw, "\t  %s since %s\t\t\t%s\n", alert.State, alert.ActiveAt.Local().Format(time.DateTime), formatLabels(alert.Labels))
+			}
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		log.Error().Err(err).Msg("failed to print rules")
+	}
+}
+
+func formatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		if k != "alertname" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", k, labels[k]))
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
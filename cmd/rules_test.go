@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/caner-cetin/oblivion/internal/config"
+)
+
+func TestCheckRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string][]byte
+		want    string
+		wantErr []string
+	}{
+		{
+			name: "valid",
+			files: map[string][]byte{"rules/custom/app.yml": []byte(`groups:
+  - name: app
+    rules:
+      - alert: AppDown
+        expr: up{job="app"} == 0
+        for: 5m
+        annotations:
+          summary: "{{ $labels.instance }} is down"
+      - record: job:up:sum
+        expr: sum by (job) (up)
+`)},
+			want: "rules/custom/app.yml: 2 rules found",
+		},
+		{
+			name: "every problem is listed",
+			files: map[string][]byte{
+				"rules/a.yml": []byte(`groups:
+  - name: a
+    rules:
+      - alert: Broken
+        expr: up ==
+`),
+				"rules/b.yml": []byte(`groups:
+  - name: b
+    rules:
+      - alert: Typo
+        expr: up == 0
+        labelz:
+          severity: page
+`),
+			},
+			wantErr: []string{"rules/a.yml:", "rules/b.yml:", "labelz"},
+		},
+		{
+			name: "alert and record are exclusive",
+			files: map[string][]byte{"rules/c.yml": []byte(`groups:
+  - name: c
+    rules:
+      - alert: Both
+        record: both
+        expr: up
+`)},
+			wantErr: []string{"rules/c.yml:", "only one of 'record' and 'alert'"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := checkRules(tt.files)
+			if len(tt.wantErr) > 0 {
+				if err == nil {
+					t.Fatalf("checkRules() = %q, want an error", got)
+				}
+				for _, want := range tt.wantErr {
+					if !strings.Contains(err.Error(), want) {
+						t.Errorf("error does not mention %q:\n%v", want, err)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("checkRules() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeployedRulesAreValid(t *testing.T) {
+	setTestConfig(t, func(c *config.Root) {
+		c.Observer.Probes.Targets = []config.ProbeConfig{{Name: "site", URL: "https://cansu.dev"}}
+	})
+	files, err := prometheusRuleFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := checkRules(files); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,31 +2,244 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/1password/onepassword-sdk-go"
+	"github.com/caner-cetin/oblivion/internal"
+	"github.com/caner-cetin/oblivion/internal/secretcache"
+	"github.com/fatih/color"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
-// todo: comment
+var (
+	secretsCacheClearCmd = &cobra.Command{
+		Use:   "clear",
+		Short: "remove the on-disk secret cache",
+		Run:   WrapCommandWithResources(secretsCacheClear, ResourceConfig{Resources: []ResourceType{}}),
+	}
+	secretsCacheCmd = &cobra.Command{
+		Use:   "cache",
+		Short: "manage the encrypted secret cache enabled with [Onepass.Cache]",
+	}
+	secretsCmd = &cobra.Command{
+		Use: "secrets",
+	}
+)
+
+func getSecretsCmd() *cobra.Command {
+	secretsCacheCmd.AddCommand(secretsCacheClearCmd)
+	secretsCmd.AddCommand(secretsCacheCmd)
+	return secretsCmd
+}
+
+func secretsCacheClear(cmd *cobra.Command, args []string) {
+	path, err := secretCachePath()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	if err := secretcache.Clear(path); err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	color.Green("cleared secret cache %s", path)
+}
+
+// resolveSecrets resolves every reference (without the vault prefix) in one call and registers them for redaction.
+// Returns the vault prefixed reference of every key and the responses keyed by the prefixed reference,
+// every response has Content set. A reference 1Password could not resolve fails the whole call.
 func (a *AppCtx) resolveSecrets(keys []string) (map[string]string, map[string]onepassword.Response[onepassword.ResolvedReference, onepassword.ResolveReferenceError], error) {
 	var prefixedKeys = make([]string, 0, len(keys))
 	for _, key := range keys {
 		prefixedKeys = append(prefixedKeys, a.Vault.Prefix+strings.TrimSpace(key))
 	}
-	secretsResponse, err := a.Vault.Client.Secrets().ResolveAll(a.Context, prefixedKeys)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to resolve secrets: %w", err)
+	responses := make(map[string]onepassword.Response[onepassword.ResolvedReference, onepassword.ResolveReferenceError], len(prefixedKeys))
+	var missing = make([]string, 0, len(prefixedKeys))
+	for _, k := range prefixedKeys {
+		if secret, ok := a.cachedSecret(k); ok {
+			responses[k] = onepassword.Response[onepassword.ResolvedReference, onepassword.ResolveReferenceError]{
+				Content: &onepassword.ResolvedReference{Secret: secret},
+			}
+			continue
+		}
+		missing = append(missing, k)
+	}
+	if len(missing) > 0 {
+		if err := a.ensureOnePass(); err != nil {
+			return nil, nil, err
+		}
+		secretsResponse, err := a.Vault.Client.Secrets().ResolveAll(a.Context, missing)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to resolve secrets: %w", err)
+		}
+		for k, v := range secretsResponse.IndividualResponses {
+			if v.Content != nil {
+				internal.RegisterSecret(v.Content.Secret)
+				v.Content.Secret = cleanSecret(v.Content.Secret)
+				internal.RegisterSecret(v.Content.Secret)
+				if a.Vault.Cache != nil {
+					a.Vault.Cache.Put(k, v.Content.Secret)
+				}
+			}
+			responses[k] = v
+		}
+		a.saveSecretCache()
 	}
-	for _, v := range secretsResponse.IndividualResponses {
-		secret := v.Content.Secret
-		secret = strings.TrimSpace(secret)
-		secret = strings.TrimFunc(secret, func(r rune) bool { return unicode.IsControl(r) })
-		v.Content.Secret = secret
+	if err := unresolvedSecrets(keys, prefixedKeys, responses); err != nil {
+		return nil, nil, err
 	}
 	var pfKeyMap = make(map[string]string)
 	for i, k := range prefixedKeys {
 		pfKeyMap[keys[i]] = k
 	}
-	return pfKeyMap, secretsResponse.IndividualResponses, nil
+	return pfKeyMap, responses, nil
+}
+
+// unresolvedSecrets names every key whose response carries no secret, 1Password reports those per reference
+// instead of failing the call
+func unresolvedSecrets(keys []string, prefixedKeys []string, responses map[string]onepassword.Response[onepassword.ResolvedReference, onepassword.ResolveReferenceError]) error {
+	var failed []string
+	for i, k := range prefixedKeys {
+		response, ok := responses[k]
+		switch {
+		case ok && response.Content != nil:
+			continue
+		case !ok:
+			failed = append(failed, fmt.Sprintf("%s (missing from the response)", keys[i]))
+		case response.Error != nil:
+			failed = append(failed, fmt.Sprintf("%s (%s)", keys[i], response.Error.Type))
+		default:
+			failed = append(failed, keys[i])
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to resolve secrets %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// resolves a single secret reference (without the vault prefix) and registers it for redaction
+func (a *AppCtx) resolveSecret(key string) (string, error) {
+	ref := a.Vault.Prefix + strings.TrimSpace(key)
+	if secret, ok := a.cachedSecret(ref); ok {
+		return secret, nil
+	}
+	if err := a.ensureOnePass(); err != nil {
+		return "", err
+	}
+	secret, err := a.Vault.Client.Secrets().Resolve(a.Context, ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve secret %s: %w", key, err)
+	}
+	internal.RegisterSecret(secret)
+	secret = cleanSecret(secret)
+	internal.RegisterSecret(secret)
+	if a.Vault.Cache != nil {
+		a.Vault.Cache.Put(ref, secret)
+		a.saveSecretCache()
+	}
+	return secret, nil
+}
+
+func (a *AppCtx) cachedSecret(ref string) (string, bool) {
+	if a.Vault.Cache == nil {
+		return "", false
+	}
+	secret, ok := a.Vault.Cache.Get(ref)
+	if ok {
+		internal.RegisterSecret(secret)
+	}
+	return secret, ok
+}
+
+func (a *AppCtx) ensureOnePass() error {
+	if a.Vault.Client != nil {
+		return nil
+	}
+	if err := a.connectOnePass(); err != nil {
+		return fmt.Errorf("failed to initialize onepassword: %w", err)
+	}
+	return nil
+}
+
+// failing to persist the cache should never fail the command, the secrets are already resolved
+func (a *AppCtx) saveSecretCache() {
+	if a.Vault.Cache == nil {
+		return
+	}
+	if err := a.Vault.Cache.Save(); err != nil {
+		log.Warn().Err(err).Msg("failed to save secret cache")
+	}
+}
+
+func openSecretCache() (*secretcache.Cache, error) {
+	ttl, err := time.ParseDuration(cfg.Onepass.Cache.TTL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid [Onepass.Cache] ttl %q: %w", cfg.Onepass.Cache.TTL, err)
+	}
+	path, err := secretCachePath()
+	if err != nil {
+		return nil, err
+	}
+	var key []byte
+	switch cfg.Onepass.Cache.KeySource {
+	case secretcache.KeySourcePassphrase:
+		passphrase, err := secretCachePassphrase()
+		if err != nil {
+			return nil, err
+		}
+		key = []byte(passphrase)
+	case secretcache.KeySourceMachine, "":
+		if key, err = secretcache.MachineKey(); err != nil {
+			return nil, fmt.Errorf("failed to derive machine key: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown [Onepass.Cache] key_source %q, expected %q or %q", cfg.Onepass.Cache.KeySource, secretcache.KeySourceMachine, secretcache.KeySourcePassphrase)
+	}
+	cache, err := secretcache.Open(path, ttl, key)
+	if err != nil {
+		// unreadable cache is overwritten on the next save
+		log.Warn().Err(err).Msg("ignoring secret cache")
+	}
+	return cache, nil
+}
+
+func secretCachePath() (string, error) {
+	if cfg.Onepass.Cache.Path != "" {
+		return cfg.Onepass.Cache.Path, nil
+	}
+	path, err := secretcache.DefaultPath()
+	if err != nil {
+		return "", fmt.Errorf("failed to get secret cache path: %w", err)
+	}
+	return path, nil
+}
+
+func secretCachePassphrase() (string, error) {
+	if passphrase := os.Getenv("OBLIVION_SECRET_CACHE_PASSPHRASE"); passphrase != "" {
+		return passphrase, nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("secret cache passphrase not set, export OBLIVION_SECRET_CACHE_PASSPHRASE")
+	}
+	fmt.Fprint(os.Stderr, "secret cache passphrase: ")
+	passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	if len(passphrase) == 0 {
+		return "", fmt.Errorf("empty secret cache passphrase")
+	}
+	return string(passphrase), nil
+}
+
+func cleanSecret(secret string) string {
+	secret = strings.TrimSpace(secret)
+	return strings.TrimFunc(secret, func(r rune) bool { return unicode.IsControl(r) })
 }
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/1password/onepassword-sdk-go"
+)
+
+type secretResponse = onepassword.Response[onepassword.ResolvedReference, onepassword.ResolveReferenceError]
+
+func TestUnresolvedSecrets(t *testing.T) {
+	keys := []string{"/Redis/password", "/Hugging Face/API Key"}
+	prefixed := []string{"op://Server/Redis/password", "op://Server/Hugging Face/API Key"}
+	resolved := secretResponse{Content: &onepassword.ResolvedReference{Secret: "secret"}}
+	tests := []struct {
+		name      string
+		responses map[string]secretResponse
+		wantErr   []string
+	}{
+		{
+			name:      "all resolved",
+			responses: map[string]secretResponse{prefixed[0]: resolved, prefixed[1]: resolved},
+		},
+		{
+			name: "per reference error",
+			responses: map[string]secretResponse{
+				prefixed[0]: resolved,
+				prefixed[1]: {Error: &onepassword.ResolveReferenceError{Type: onepassword.ResolveReferenceErrorTypeVariantFieldNotFound}},
+			},
+			wantErr: []string{"/Hugging Face/API Key (fieldNotFound)"},
+		},
+		{
+			name:      "missing response",
+			responses: map[string]secretResponse{prefixed[1]: resolved},
+			wantErr:   []string{"/Redis/password (missing from the response)"},
+		},
+		{
+			name:      "neither content nor error",
+			responses: map[string]secretResponse{prefixed[0]: {}, prefixed[1]: {}},
+			wantErr:   []string{"/Redis/password", "/Hugging Face/API Key"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := unresolvedSecrets(keys, prefixed, tt.responses)
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not name %q", err, want)
+				}
+			}
+		})
+	}
+}
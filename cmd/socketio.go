@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// socketIOClient speaks just enough socket.io 4 over engine.io's websocket transport to emit events with
+// acknowledgements and keep the last payload of every event the server pushes. It is not safe for concurrent use.
+type socketIOClient struct {
+	conn    *websocket.Conn
+	timeout time.Duration
+	nextID  int
+	// first argument of the last push of every event, by event name
+	events map[string]json.RawMessage
+}
+
+// dialSocketIO connects to the default namespace of the socket.io server at host:port. The origin matches the host,
+// servers that check it, like Uptime Kuma, would reject a connection from anywhere else.
+func dialSocketIO(host string, port string, timeout time.Duration) (*socketIOClient, error) {
+	address := net.JoinHostPort(host, port)
+	config, err := websocket.NewConfig("ws://"+address+"/socket.io/?EIO=4&transport=websocket", "http://"+address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure socket.io connection: %w", err)
+	}
+	config.Dialer = &net.Dialer{Timeout: timeout}
+	conn, err := websocket.DialConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	c := &socketIOClient{conn: conn, timeout: timeout, events: make(map[string]json.RawMessage)}
+	// engine.io open, then socket.io connect to the default namespace
+	if _, err := c.expect("0"); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if err := c.send("40"); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if _, err := c.expect("40"); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *socketIOClient) Close() error {
+	return c.conn.Close()
+}
+
+func (c *socketIOClient) send(packet string) error {
+	if err := c.conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+	if err := websocket.Message.Send(c.conn, packet); err != nil {
+		return fmt.Errorf("failed to send socket.io packet: %w", err)
+	}
+	return nil
+}
+
+// receive returns the next packet that is not a ping, pings are answered on the way
+func (c *socketIOClient) receive() (string, error) {
+	for {
+		if err := c.conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+			return "", err
+		}
+		var packet string
+		if err := websocket.Message.Receive(c.conn, &packet); err != nil {
+			return "", fmt.Errorf("failed to receive socket.io packet: %w", err)
+		}
+		if packet != "2" {
+			return packet, nil
+		}
+		if err := c.send("3"); err != nil {
+			return "", err
+		}
+	}
+}
+
+// expect reads until a packet with the given type prefix arrives, events pushed meanwhile are kept
+func (c *socketIOClient) expect(prefix string) (string, error) {
+	for {
+		packet, err := c.receive()
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(packet, prefix) {
+			return strings.TrimPrefix(packet, prefix), nil
+		}
+		if err := c.handle(packet); err != nil {
+			return "", err
+		}
+	}
+}
+
+// handle keeps pushed events and fails on disconnects, anything else is dropped
+func (c *socketIOClient) handle(packet string) error {
+	switch {
+	case strings.HasPrefix(packet, "42"):
+		var args []json.RawMessage
+		if err := json.Unmarshal([]byte(packet[2:]), &args); err != nil || len(args) == 0 {
+			return fmt.Errorf("malformed socket.io event %.64q", packet)
+		}
+		var name string
+		if err := json.Unmarshal(args[0], &name); err != nil {
+			return fmt.Errorf("malformed socket.io event name %.64q", packet)
+		}
+		if len(args) > 1 {
+			c.events[name] = args[1]
+		} else {
+			c.events[name] = nil
+		}
+	case packet == "1", strings.HasPrefix(packet, "41"):
+		return fmt.Errorf("socket.io server closed the connection")
+	case strings.HasPrefix(packet, "44"):
+		return fmt.Errorf("socket.io server refused the connection: %s", packet[2:])
+	}
+	return nil
+}
+
+// call emits an event and waits for its acknowledgement, returning the first acknowledged argument
+func (c *socketIOClient) call(event string, args ...any) (json.RawMessage, error) {
+	id := c.nextID
+	c.nextID++
+	payload, err := json.Marshal(append([]any{event}, args...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", event, err)
+	}
+	if err := c.send("42" + strconv.Itoa(id) + string(payload)); err != nil {
+		return nil, err
+	}
+	// calls never overlap, so the next acknowledgement is this one
+	body, err := c.expect("43" + strconv.Itoa(id))
+	if err != nil {
+		return nil, fmt.Errorf("no acknowledgement for %s: %w", event, err)
+	}
+	var acked []json.RawMessage
+	if err := json.Unmarshal([]byte(body), &acked); err != nil {
+		return nil, fmt.Errorf("malformed acknowledgement for %s: %w", event, err)
+	}
+	if len(acked) == 0 {
+		return nil, nil
+	}
+	return acked[0], nil
+}
+
+// event waits until the server has pushed the named event at least once and returns its payload
+func (c *socketIOClient) event(name string) (json.RawMessage, error) {
+	for {
+		if payload, ok := c.events[name]; ok {
+			return payload, nil
+		}
+		packet, err := c.receive()
+		if err != nil {
+			return nil, fmt.Errorf("never received %s: %w", name, err)
+		}
+		if err := c.handle(packet); err != nil {
+			return nil, err
+		}
+	}
+}
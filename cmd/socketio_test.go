@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestSocketIOHandle(t *testing.T) {
+	tests := []struct {
+		name    string
+		packet  string
+		events  map[string]string
+		wantErr string
+	}{
+		{name: "event with payload", packet: `42["monitorList",{"1":{"name":"site"}}]`, events: map[string]string{"monitorList": `{"1":{"name":"site"}}`}},
+		{name: "event without payload", packet: `42["autoLogin"]`, events: map[string]string{"autoLogin": ""}},
+		{name: "only the first argument is kept", packet: `42["info",{"version":"1"},"extra"]`, events: map[string]string{"info": `{"version":"1"}`}},
+		{name: "unrelated packets are dropped", packet: `3`, events: map[string]string{}},
+		{name: "malformed event", packet: `42{"monitorList"}`, wantErr: "malformed socket.io event"},
+		{name: "event without a name", packet: `42[]`, wantErr: "malformed socket.io event"},
+		{name: "event name is not a string", packet: `42[1,{}]`, wantErr: "malformed socket.io event name"},
+		{name: "engine.io close", packet: `1`, wantErr: "closed the connection"},
+		{name: "socket.io disconnect", packet: `41`, wantErr: "closed the connection"},
+		{name: "connect error", packet: `44{"message":"not authorized"}`, wantErr: `refused the connection: {"message":"not authorized"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &socketIOClient{events: make(map[string]json.RawMessage)}
+			err := c.handle(tt.packet)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("handle(%q) = %v, want an error containing %q", tt.packet, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(c.events) != len(tt.events) {
+				t.Fatalf("events = %v, want %v", c.events, tt.events)
+			}
+			for name, want := range tt.events {
+				if got, ok := c.events[name]; !ok || string(got) != want {
+					t.Errorf("events[%s] = %s, want %s", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+// fakeSocketIOServer plays script against the client, a packet starting with "<" is expected from the client and
+// anything else is sent to it. It returns the host and port to dial.
+func fakeSocketIOServer(t *testing.T, script []string) (string, string) {
+	t.Helper()
+	server := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+		for _, step := range script {
+			if want, ok := strings.CutPrefix(step, "<"); ok {
+				var got string
+				if err := websocket.Message.Receive(ws, &got); err != nil {
+					t.Errorf("server expected %q: %v", want, err)
+					return
+				}
+				if got != want {
+					t.Errorf("server received %q, want %q", got, want)
+					return
+				}
+				continue
+			}
+			if err := websocket.Message.Send(ws, step); err != nil {
+				t.Errorf("server failed to send %q: %v", step, err)
+				return
+			}
+		}
+		// keep the connection open until the client is done
+		var ignored string
+		_ = websocket.Message.Receive(ws, &ignored)
+	}))
+	t.Cleanup(server.Close)
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return host, port
+}
+
+func TestSocketIOClient(t *testing.T) {
+	host, port := fakeSocketIOServer(t, []string{
+		`0{"sid":"abc","pingInterval":25000,"pingTimeout":20000}`,
+		`<40`,
+		// a ping before the namespace is joined is answered and skipped
+		`2`,
+		`<3`,
+		`40{"sid":"def"}`,
+		`42["info",{"version":"2.0.0"}]`,
+		`<420["login",{"password":"x","username":"admin"}]`,
+		// pushes that arrive while waiting for the acknowledgement are kept
+		`42["monitorList",{"1":{"name":"site"}}]`,
+		`430[{"ok":true,"token":"t"}]`,
+		`<421["getMonitorBeats",1,24]`,
+		`431[]`,
+		`<422["logout"]`,
+		`41`,
+	})
+	c, err := dialSocketIO(host, port, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ack, err := c.call("login", map[string]string{"username": "admin", "password": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(ack) != `{"ok":true,"token":"t"}` {
+		t.Errorf("login acknowledged %s", ack)
+	}
+	for name, want := range map[string]string{"info": `{"version":"2.0.0"}`, "monitorList": `{"1":{"name":"site"}}`} {
+		payload, err := c.event(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(payload) != want {
+			t.Errorf("%s = %s, want %s", name, payload, want)
+		}
+	}
+	ack, err = c.call("getMonitorBeats", 1, 24)
+	if err != nil || ack != nil {
+		t.Errorf("empty acknowledgement = %s, %v", ack, err)
+	}
+	if _, err := c.call("logout"); err == nil || !strings.Contains(err.Error(), "closed the connection") {
+		t.Errorf("call after disconnect = %v, want a closed connection error", err)
+	}
+}
+
+func TestSocketIOClientTimeout(t *testing.T) {
+	// the server opens the engine.io session but never joins the namespace
+	host, port := fakeSocketIOServer(t, []string{`0{"sid":"abc"}`, `<40`})
+	start := time.Now()
+	if _, err := dialSocketIO(host, port, 200*time.Millisecond); err == nil {
+		t.Fatal("expected dialing to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("dial took %s", elapsed)
+	}
+}
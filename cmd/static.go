@@ -1,18 +1,26 @@
 package cmd
 
 import (
+	"context"
+	"crypto/sha256"
 	"embed"
 	"fmt"
+	"io/fs"
 	"os"
 	"os/exec"
 	"os/user"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/caner-cetin/oblivion/internal"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/mount"
-	"github.com/docker/go-connections/nat"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
 	"github.com/fatih/color"
 	v1 "github.com/moby/docker-image-spec/specs-go/v1"
 	"github.com/rs/zerolog/log"
@@ -27,7 +35,8 @@ var (
 		Use: "permissions",
 		Run: WrapCommandWithResources(staticChmod, ResourceConfig{Resources: []ResourceType{}}),
 	}
-	staticUpCmd = &cobra.Command{
+	staticPermissionsCheck bool
+	staticUpCmd            = &cobra.Command{
 		Use: "up",
 		Run: WrapCommandWithResources(staticUp, ResourceConfig{Resources: []ResourceType{ResourceDocker}}),
 	}
@@ -37,33 +46,60 @@ var (
 )
 
 func getStaticCmd() *cobra.Command {
+	staticPermissionsCmd.Flags().BoolVar(&staticPermissionsCheck, "check", false, "report ownership, modes and default ACLs that differ from the policy without changing anything, exits 1 on drift")
 	staticCmd.AddCommand(staticPermissionsCmd)
 	staticCmd.AddCommand(staticUpCmd)
+	staticSyncCmd.Flags().BoolVar(&staticSyncDelete, "delete", false, "remove files under the destination that are not in the local directory")
+	staticSyncCmd.Flags().BoolVar(&staticSyncDryRun, "dry-run", false, "list what would change without touching the static path")
+	staticCmd.AddCommand(staticSyncCmd)
+	staticCmd.AddCommand(getStaticFTPCmd())
+	staticStatsCmd.Flags().DurationVar(&staticStatsSince, "since", 24*time.Hour, "how far back to read the access log")
+	staticStatsCmd.Flags().IntVar(&staticStatsTop, "top", 10, "number of files and referrers to list")
+	staticStatsCmd.Flags().BoolVar(&staticStatsJSON, "json", false, "print the report as JSON")
+	staticCmd.AddCommand(staticStatsCmd)
+	staticLinkCmd.Flags().DurationVar(&staticLinkExpires, "expires", 24*time.Hour, "how long the link stays valid")
+	staticCmd.AddCommand(staticLinkCmd)
 	return staticCmd
 }
 
-func staticUp(cmd *cobra.Command, args []string) {
-	app := GetApp(cmd)
-	app.Spinner.Prefix = "building static"
-	app.Spinner.Start()
-	app.Spinner.Prefix = "checking for nginx image"
-	defer app.Spinner.Stop()
-	exists, err := app.imageExists(cfg.Static.ImageName)
+// staticBuildContext is the embedded build directory, rooted so nginx.Dockerfile sits at the top of the build context
+func staticBuildContext() fs.FS {
+	sub, err := fs.Sub(staticBuildFiles, "config/static")
 	if err != nil {
-		log.Error().Err(err).Msg("failed to check if volume exists")
-		return
+		// only fails for invalid paths, the path is a constant
+		panic(err)
 	}
-	if !exists {
-		app.Spinner.Prefix = "building image..."
-		if err := app.buildImage(staticBuildFiles, "config/static", cfg.Static.ImageName, "nginx.Dockerfile"); err != nil {
-			log.Error().Err(err).Send()
-			return
-		}
+	return sub
+}
+
+// staticImage is the static image tagged with a hash of the embedded build files, so changed files build a new image
+func staticImage() (string, error) {
+	files, err := readFiles(staticBuildContext())
+	if err != nil {
+		return "", err
 	}
-	app.Spinner.Prefix = "creating static container"
-	resp, err := app.Docker.Client.ContainerCreate(app.Context,
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s\x00%d\x00", name, len(files[name]))
+		h.Write(files[name])
+	}
+	return fmt.Sprintf("%s:%x", cfg.Static.ImageName, h.Sum(nil)[:6]), nil
+}
+
+// createStaticContainer creates a static container on the static network, reachable by the proxy under upstream,
+// and copies the rendered nginx configuration into it. The host port belongs to the proxy.
+func (a *AppCtx) createStaticContainer(name string, image string, upstream string, files map[string][]byte) (string, error) {
+	labels := managedLabels("static", "nginx")
+	labels[staticUpstreamLabel] = upstream
+	resp, err := a.Docker.Client.ContainerCreate(a.Context,
 		&container.Config{
-			Image:        cfg.Static.ImageName,
+			Image:        image,
+			Labels:       labels,
 			AttachStdout: true,
 			AttachStderr: true,
 			AttachStdin:  false,
@@ -71,9 +107,7 @@ func staticUp(cmd *cobra.Command, args []string) {
 			Healthcheck:  nginx_healthcheck,
 		},
 		&container.HostConfig{
-			PortBindings: nat.PortMap{
-				nat.Port("80/tcp"): []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: cfg.Static.Port}},
-			},
+			LogConfig: managedLogConfig("static", "nginx"),
 			Mounts: []mount.Mount{
 				{
 					Type:   mount.TypeBind,
@@ -83,41 +117,256 @@ func staticUp(cmd *cobra.Command, args []string) {
 			},
 			RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyAlways},
 		},
+		&network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{
+				cfg.Networks.StaticNetworkName: {Aliases: []string{upstream}},
+			},
+		},
 		nil,
-		nil,
-		cfg.Static.ContainerName,
+		name,
 	)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to create static container")
+		return "", fmt.Errorf("failed to create %s container: %w", name, err)
+	}
+	if err := a.copyFilesToContainer(resp.ID, files, nginxConfDir); err != nil {
+		return resp.ID, err
+	}
+	return resp.ID, nil
+}
+
+func (a *AppCtx) startStaticContainer(id string, healthcheck *v1.HealthcheckConfig) error {
+	if err := a.Docker.Client.ContainerStart(a.Context, id, container.StartOptions{}); err != nil {
+		return fmt.Errorf("failed to start static container: %w", err)
+	}
+	return a.waitForContainerHealthWithConfig(id, healthcheck)
+}
+
+func (a *AppCtx) removeStaticContainer(id string) {
+	if err := a.Docker.Client.ContainerRemove(context.Background(), id, container.RemoveOptions{Force: true}); err != nil && !errdefs.IsNotFound(err) {
+		log.Warn().Err(err).Str("container", id).Msg("failed to remove static container")
+	}
+}
+
+// swapStaticContainer replaces the running static container with one from image. The replacement starts next to it
+// and has to pass the healthcheck before the proxy is reloaded to forward to it, the old container keeps serving
+// until then and finishes the requests it has in flight before it is stopped. If anything fails before the
+// reload, the old container stays in service.
+// Containers created before the proxy publish the port themselves, they are stopped right before the proxy takes
+// the port over, that one time with a short gap.
+func (a *AppCtx) swapStaticContainer(old container.InspectResponse, image string, files map[string][]byte) error {
+	a.Spinner.Prefix = "starting the new static container"
+	upstream := newStaticUpstream()
+	next, err := a.createStaticContainer(cfg.Static.ContainerName+"-next", image, upstream, files)
+	if err != nil {
+		if next != "" {
+			a.removeStaticContainer(next)
+		}
+		return err
+	}
+	if err := a.startStaticContainer(next, nginx_healthcheck); err != nil {
+		a.removeStaticContainer(next)
+		return fmt.Errorf("new static container is unhealthy, the running one was kept: %w", err)
+	}
+	// nginx quits gracefully on docker stop, this is how long requests in flight get to finish
+	drain := 30
+	if old.Config.Labels[staticUpstreamLabel] == "" {
+		a.Spinner.Prefix = "handing the port over to the static proxy"
+		timeout := 10
+		if err := a.Docker.Client.ContainerStop(a.Context, old.ID, container.StopOptions{Timeout: &timeout}); err != nil {
+			a.removeStaticContainer(next)
+			return fmt.Errorf("failed to stop running static container: %w", err)
+		}
+		if err := a.pointStaticProxy(upstream); err != nil {
+			a.removeStaticContainer(next)
+			if startErr := a.Docker.Client.ContainerStart(a.Context, old.ID, container.StartOptions{}); startErr != nil {
+				return fmt.Errorf("failed to start the previous static container after %w: %w", err, startErr)
+			}
+			return fmt.Errorf("rolled back to the previous static container: %w", err)
+		}
+		drain = 0
+	} else if err := a.pointStaticProxy(upstream); err != nil {
+		a.removeStaticContainer(next)
+		return fmt.Errorf("the running static container was kept: %w", err)
+	}
+	a.Spinner.Prefix = "draining the previous static container"
+	if err := a.Docker.Client.ContainerStop(a.Context, old.ID, container.StopOptions{Timeout: &drain}); err != nil && !errdefs.IsNotFound(err) {
+		log.Warn().Err(err).Str("container", old.ID).Msg("failed to stop previous static container")
+	}
+	a.removeStaticContainer(old.ID)
+	if err := a.Docker.Client.ContainerRename(a.Context, next, cfg.Static.ContainerName); err != nil {
+		return fmt.Errorf("failed to rename static container: %w", err)
+	}
+	return nil
+}
+
+func staticUp(cmd *cobra.Command, args []string) {
+	app := GetApp(cmd)
+	app.Spinner.Prefix = "building static"
+	app.Spinner.Start()
+	app.Spinner.Prefix = "checking for nginx image"
+	defer app.Spinner.Stop()
+	tag, err := staticImage()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to hash static build files")
+		return
+	}
+	exists, err := app.imageExists(tag)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to check if image exists")
+		return
+	}
+	if !exists {
+		app.Spinner.Prefix = "building image..."
+		if err := app.buildImage(staticBuildContext(), tag, "nginx.Dockerfile"); err != nil {
+			log.Error().Err(err).Send()
+			return
+		}
+	}
+	if err := app.createNetworkIfNotExists(cfg.Networks.StaticNetworkName, nil); err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	if err := app.recoverStaticSwap(); err != nil {
+		log.Error().Err(err).Msg("failed to clean up an interrupted update")
+		return
+	}
+
+	inspect, err := app.Docker.Client.ContainerInspect(app.Context, cfg.Static.ContainerName)
+	if err != nil && !errdefs.IsNotFound(err) {
+		log.Error().Err(err).Msg("failed to inspect static container")
+		return
+	}
+	found := err == nil
+	upstream := ""
+	if found {
+		upstream = inspect.Config.Labels[staticUpstreamLabel]
+	}
+	if found && inspect.Config.Image == tag && upstream != "" {
+		if _, err := app.containerExists(cfg.Static.ContainerName); err != nil {
+			log.Error().Err(err).Send()
+			return
+		}
+		app.Spinner.Prefix = "rendering nginx.conf"
+		files, err := app.renderStaticNginx(cfg.Static.ContainerName)
+		if err != nil {
+			log.Error().Err(internal.RedactError(err)).Send()
+			return
+		}
+		changed, err := app.reloadStaticNginx(cfg.Static.ContainerName, files)
+		if err != nil {
+			log.Error().Err(err).Send()
+			return
+		}
+		// recreates a removed proxy
+		if err := app.pointStaticProxy(upstream); err != nil {
+			log.Error().Err(err).Send()
+			return
+		}
+		app.Spinner.Stop()
+		if changed {
+			color.Green("nginx.conf changed, static server reloaded")
+		} else {
+			color.Cyan("static server found")
+		}
+		return
+	}
+
+	app.Spinner.Prefix = "rendering nginx.conf"
+	current := ""
+	if found {
+		current = inspect.ID
+	}
+	files, err := app.renderStaticNginx(current)
+	if err != nil {
+		log.Error().Err(internal.RedactError(err)).Send()
+		return
+	}
+	app.Spinner.Prefix = "testing nginx.conf"
+	if err := app.testNginxConf(tag, files); err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	if found {
+		if err := app.swapStaticContainer(inspect, tag, files); err != nil {
+			log.Error().Err(err).Send()
+			return
+		}
+		// the previous image is only kept around by the container that was just removed
+		if inspect.Config.Image != tag {
+			if _, err := app.Docker.Client.ImageRemove(app.Context, inspect.Image, image.RemoveOptions{}); err != nil {
+				log.Warn().Err(err).Str("image", inspect.Config.Image).Msg("failed to remove previous static image")
+			}
+		}
+		app.Spinner.Stop()
+		color.Green("static server updated to %s", tag)
+		return
+	}
+	app.Spinner.Prefix = "creating static container"
+	upstream = newStaticUpstream()
+	id, err := app.createStaticContainer(cfg.Static.ContainerName, tag, upstream, files)
+	if err != nil {
+		log.Error().Err(err).Send()
 		return
 	}
 	app.Spinner.Prefix = "starting container"
-	if err := app.Docker.Client.ContainerStart(app.Context, resp.ID, container.StartOptions{}); err != nil {
+	if err := app.startStaticContainer(id, nginx_healthcheck); err != nil {
 		log.Error().Err(err).Send()
 		return
 	}
-	if err := app.waitForContainerHealthWithConfig(resp.ID, nginx_healthcheck); err != nil {
+	if err := app.pointStaticProxy(upstream); err != nil {
 		log.Error().Err(err).Send()
 		return
 	}
-
 }
 
 var nginx_healthcheck = &v1.HealthcheckConfig{
-	Test:     []string{"CMD-SHELL", "wget -O /dev/null http://localhost || exit 1"},
+	Test:     []string{"CMD-SHELL", "wget -q -O /dev/null http://localhost/.oblivion-health || exit 1"},
 	Interval: 5 * time.Second,
 	Timeout:  10 * time.Second,
 	Retries:  10,
 }
 
+// modes below the static path, everything is owned by root:uploader group
+const (
+	// 2775 on the static path itself:
+	// 2000 - setgid bit, new entries inherit the uploader group
+	// 7 - full permissions for owner
+	// 7 - full permissions for group
+	// 5 - read and execute for others (nginx)
+	staticRootMode os.FileMode = os.ModeSetgid | 0775
+	// directories below it, same permissions as the root but without setgid
+	staticDirMode os.FileMode = 0775
+	// rw for owner, rw for group, r for anyone else (nginx)
+	staticFileMode os.FileMode = 0664
+	// default ACL of every directory, keeps files created by other tools group writable
+	staticDefaultACL = "u::rwx,g::rwx,o::r-x"
+)
+
+// primary group of [Static] uploader_user, which owns everything below the static path
+func staticUploaderGID() (int, error) {
+	uploader, err := user.Lookup(cfg.Static.UploaderUser)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get user %s: %w", cfg.Static.UploaderUser, err)
+	}
+	return strconv.Atoi(uploader.Gid)
+}
+
 func staticChmod(cmd *cobra.Command, args []string) {
 	path := cfg.Static.StaticPath
-	uploader, err := user.Lookup(cfg.Static.UploaderUser)
+	uploader_gid, err := staticUploaderGID()
 	if err != nil {
-		log.Error().Err(err).Msgf("failed to get user %s", cfg.Static.UploaderUser)
+		log.Error().Err(err).Send()
+		if staticPermissionsCheck {
+			// the tree could not be checked, not "no drift"
+			exitCode = 2
+		}
+		return
+	}
+	if staticPermissionsCheck {
+		GetApp(cmd).Spinner.Stop()
+		staticCheckPermissions(path, uploader_gid)
 		return
 	}
-	uploader_gid, _ := strconv.Atoi(uploader.Gid)
 
 	// root:uploader_group
 	if err := os.Chown(path, 0, uploader_gid); err != nil {
@@ -125,12 +374,7 @@ func staticChmod(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	// 2775:
-	// 2000 - setgid bit
-	// 7 - full permissions for owner
-	// 7 - full permissions for group
-	// 5 - read and execute for others (nginx)
-	if err := os.Chmod(path, 2775); err != nil {
+	if err := os.Chmod(path, staticRootMode); err != nil {
 		log.Error().Err(err).Msg("failed to set permissions")
 		return
 	}
@@ -142,18 +386,19 @@ func staticChmod(cmd *cobra.Command, args []string) {
 		if err := os.Chown(path, 0, uploader_gid); err != nil {
 			return fmt.Errorf("chown failed for %s: %w", path, err)
 		}
-		// for directories, same permissions as 2775 but without setgid
-		if info.IsDir() {
-			return os.Chmod(path, 0775)
+		switch {
+		case path == cfg.Static.StaticPath:
+			return os.Chmod(path, staticRootMode)
+		case info.IsDir():
+			return os.Chmod(path, staticDirMode)
 		}
-		// for files, rw for owner, rw for group, wx for anyone else
-		return os.Chmod(path, 0664)
+		return os.Chmod(path, staticFileMode)
 	}); err != nil {
 		log.Error().Err(err).Msg("failed to set recursive permissions")
 		return
 	}
 
-	acl_cmd := exec.Command("sudo", "setfacl", "-R", "-d", "-m", "u::rwx,g::rwx,o::r-x", path)
+	acl_cmd := exec.Command("sudo", "setfacl", "-R", "-d", "-m", staticDefaultACL, path)
 	acl_cmd.Stdout = os.Stdout
 	acl_cmd.Stderr = os.Stderr
 	if err := acl_cmd.Run(); err != nil {
@@ -163,3 +408,89 @@ func staticChmod(cmd *cobra.Command, args []string) {
 
 	color.Green("Permissions and ownership set for %s", path)
 }
+
+// staticDrift lists how an entry below the static path differs from the policy staticChmod applies,
+// root is set for the static path itself
+func staticDrift(path string, info fs.FileInfo, gid int, root bool) ([]string, error) {
+	var drift []string
+	uid, currentGID, err := fileOwner(info)
+	if err != nil {
+		return nil, err
+	}
+	if uid != 0 {
+		drift = append(drift, fmt.Sprintf("owner is %d, want 0", uid))
+	}
+	if currentGID != gid {
+		drift = append(drift, fmt.Sprintf("group is %d, want %d", currentGID, gid))
+	}
+	want := staticFileMode
+	switch {
+	case root:
+		want = staticRootMode
+	case info.IsDir():
+		want = staticDirMode
+	}
+	if info.Mode().Perm() != want.Perm() {
+		drift = append(drift, fmt.Sprintf("mode is %04o, want %04o", info.Mode().Perm(), want.Perm()))
+	}
+	if setgid := info.Mode()&os.ModeSetgid != 0; setgid != (want&os.ModeSetgid != 0) {
+		if setgid {
+			drift = append(drift, "setgid is set, want unset")
+		} else {
+			drift = append(drift, "setgid is unset, want set")
+		}
+	}
+	if info.IsDir() {
+		acl, err := readDefaultACL(path)
+		if err != nil {
+			return nil, err
+		}
+		if acl != staticDefaultACL {
+			if acl == "" {
+				acl = "none"
+			}
+			drift = append(drift, fmt.Sprintf("default ACL is %s, want %s", acl, staticDefaultACL))
+		}
+	}
+	return drift, nil
+}
+
+// staticCheckPermissions reports drift from the policy without changing anything. Exits 1 on drift
+// and 2 if the tree could not be checked, so it can run from cron.
+func staticCheckPermissions(root string, gid int) {
+	checked, drifted := 0, 0
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// links and special files have no modes of their own worth checking
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		drift, err := staticDrift(path, info, gid, path == root)
+		if err != nil {
+			return err
+		}
+		checked++
+		if len(drift) > 0 {
+			drifted++
+			fmt.Printf("%s: %s\n", path, strings.Join(drift, ", "))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to check permissions")
+		exitCode = 2
+		return
+	}
+	if drifted > 0 {
+		color.Yellow("%d of %d entries differ from the policy, run static permissions to fix them", drifted, checked)
+		exitCode = 1
+		return
+	}
+	color.Green("%d entries match the policy", checked)
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestStaticBuildContext(t *testing.T) {
+	reader, err := createBuildContext(staticBuildContext(), "nginx.Dockerfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	names := make(map[string]bool)
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names[header.Name] = true
+	}
+	for _, want := range []string{"nginx.Dockerfile", "fancyindex/"} {
+		if !names[want] {
+			t.Errorf("build context has no %s at its root, got %v", want, names)
+		}
+	}
+}
+
+func TestStaticProxyConf(t *testing.T) {
+	tests := []struct {
+		name     string
+		upstream string
+	}{
+		{name: "alias", upstream: "file-server-18b2c3d4e5f6a7b8"},
+		{name: "custom container name", upstream: "my.files-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := staticProxyConf(tt.upstream)
+			m := staticProxyUpstreamLine.FindSubmatch(conf)
+			if m == nil {
+				t.Fatalf("no upstream in\n%s", conf)
+			}
+			if got := string(m[1]); got != tt.upstream {
+				t.Errorf("upstream = %q, want %q", got, tt.upstream)
+			}
+		})
+	}
+}
+
+func TestStaticProxyUpstreamLine(t *testing.T) {
+	tests := []struct {
+		name string
+		conf string
+		want string
+	}{
+		{name: "generated", conf: "location / {\n    proxy_pass http://file-server-1a;\n}", want: "file-server-1a"},
+		{name: "no proxy_pass", conf: "location / {\n    return 204;\n}", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if m := staticProxyUpstreamLine.FindStringSubmatch(tt.conf); m != nil {
+				got = m[1]
+			}
+			if got != tt.want {
+				t.Errorf("upstream = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
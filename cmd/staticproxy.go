@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-connections/nat"
+	v1 "github.com/moby/docker-image-spec/specs-go/v1"
+)
+
+// static containers carry the network alias the proxy reaches them by in this label.
+// Containers created before the proxy have none and publish the port themselves.
+const staticUpstreamLabel = "oblivion.static.upstream"
+
+// the proxy owns [Static] port and forwards to one static container. Updates start the new container next to
+// the old one and reload the proxy, nginx finishes requests in flight on the old upstream, so nothing is refused.
+const staticProxyConfFormat = `# generated by oblivion, static up points the upstream at the static container in service
+worker_processes auto;
+pid /var/run/nginx.pid;
+error_log /dev/stderr warn;
+
+events {
+    worker_connections 1024;
+}
+
+http {
+    # the static container logs every request
+    access_log off;
+
+    server {
+        listen 80 default_server;
+
+        location = /.oblivion-proxy-health {
+            return 204;
+        }
+
+        location / {
+            proxy_pass http://%s;
+            proxy_http_version 1.1;
+            proxy_set_header Host $host;
+            # static stats take the client from the first forwarded address
+            proxy_set_header X-Forwarded-For $proxy_add_x_forwarded_for;
+            # downloads are streamed instead of spooled to disk
+            proxy_buffering off;
+        }
+    }
+}
+`
+
+var staticProxyUpstreamLine = regexp.MustCompile(`proxy_pass http://([^;]+);`)
+
+var staticProxyHealthcheck = &v1.HealthcheckConfig{
+	Test:     []string{"CMD-SHELL", "wget -q -O /dev/null http://localhost/.oblivion-proxy-health || exit 1"},
+	Interval: 5 * time.Second,
+	Timeout:  10 * time.Second,
+	Retries:  10,
+}
+
+func staticProxyConf(upstream string) []byte {
+	return fmt.Appendf(nil, staticProxyConfFormat, upstream)
+}
+
+// newStaticUpstream returns a network alias no static container has used before, it stays with the container through renames
+func newStaticUpstream() string {
+	return fmt.Sprintf("%s-%x", cfg.Static.ContainerName, time.Now().UnixNano())
+}
+
+// staticProxyUpstream returns the alias the proxy currently forwards to, empty if there is no proxy
+func (a *AppCtx) staticProxyUpstream() (string, error) {
+	if _, err := a.Docker.Client.ContainerInspect(a.Context, cfg.Static.Proxy.ContainerName); err != nil {
+		if errdefs.IsNotFound(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to inspect static proxy: %w", err)
+	}
+	conf, err := a.readContainerFile(cfg.Static.Proxy.ContainerName, nginxConfDir+"/nginx.conf")
+	if err != nil {
+		return "", err
+	}
+	m := staticProxyUpstreamLine.FindSubmatch(conf)
+	if m == nil {
+		return "", nil
+	}
+	return string(m[1]), nil
+}
+
+// pointStaticProxy makes the proxy forward to upstream, creating the proxy if it does not exist.
+// A running proxy is reloaded after the new configuration passes nginx -t.
+func (a *AppCtx) pointStaticProxy(upstream string) error {
+	files := map[string][]byte{"nginx.conf": staticProxyConf(upstream)}
+	inspect, err := a.Docker.Client.ContainerInspect(a.Context, cfg.Static.Proxy.ContainerName)
+	if errdefs.IsNotFound(err) {
+		return a.createStaticProxy(files)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to inspect static proxy: %w", err)
+	}
+	if !inspect.State.Running {
+		// a stopped proxy is started with the new configuration, there is nothing to reload
+		if err := a.copyFilesToContainer(inspect.ID, files, nginxConfDir); err != nil {
+			return err
+		}
+		return a.startStaticContainer(inspect.ID, staticProxyHealthcheck)
+	}
+	a.Spinner.Prefix = "pointing static proxy at the new container"
+	if _, err := a.reloadStaticNginx(inspect.ID, files); err != nil {
+		return fmt.Errorf("static proxy: %w", err)
+	}
+	return nil
+}
+
+func (a *AppCtx) createStaticProxy(files map[string][]byte) error {
+	if err := a.pullImageIfNotExists(cfg.Static.Proxy.Image); err != nil {
+		return err
+	}
+	a.Spinner.Prefix = "creating static proxy"
+	resp, err := a.Docker.Client.ContainerCreate(a.Context,
+		&container.Config{
+			Image:       cfg.Static.Proxy.Image,
+			Labels:      managedLabels("static", "proxy"),
+			Healthcheck: staticProxyHealthcheck,
+		},
+		&container.HostConfig{
+			LogConfig: managedLogConfig("static", "proxy"),
+			PortBindings: nat.PortMap{
+				nat.Port("80/tcp"): []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: cfg.Static.Port}},
+			},
+			RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyAlways},
+		},
+		&network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{cfg.Networks.StaticNetworkName: {}},
+		},
+		nil,
+		cfg.Static.Proxy.ContainerName,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create static proxy: %w", err)
+	}
+	if err := a.copyFilesToContainer(resp.ID, files, nginxConfDir); err != nil {
+		a.removeStaticContainer(resp.ID)
+		return err
+	}
+	if err := a.startStaticContainer(resp.ID, staticProxyHealthcheck); err != nil {
+		a.removeStaticContainer(resp.ID)
+		return fmt.Errorf("static proxy: %w", err)
+	}
+	return nil
+}
+
+// recoverStaticSwap finishes or undoes a swap that was interrupted. The replacement is kept if the proxy
+// already forwards to it, otherwise it never served a request and is removed.
+func (a *AppCtx) recoverStaticSwap() error {
+	// left behind by versions that started a canary before swapping
+	a.removeStaticContainer(cfg.Static.ContainerName + "-canary")
+	next, err := a.Docker.Client.ContainerInspect(a.Context, cfg.Static.ContainerName+"-next")
+	if errdefs.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to inspect static container: %w", err)
+	}
+	proxied, err := a.staticProxyUpstream()
+	if err != nil {
+		return err
+	}
+	if upstream := next.Config.Labels[staticUpstreamLabel]; upstream == "" || upstream != proxied {
+		a.removeStaticContainer(next.ID)
+		return nil
+	}
+	a.removeStaticContainer(cfg.Static.ContainerName)
+	if err := a.Docker.Client.ContainerRename(a.Context, next.ID, cfg.Static.ContainerName); err != nil {
+		return fmt.Errorf("failed to rename static container: %w", err)
+	}
+	return nil
+}
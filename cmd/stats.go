@@ -0,0 +1,267 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/fatih/color"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	staticStatsCmd = &cobra.Command{
+		Use:   "stats",
+		Short: "summarize the access log of the running static container",
+		Long: `Nginx logs to stdout, so only the running container's log is read. static up replaces the container
+when the image changes and the history before that is gone, the report starts at the container's creation then.`,
+		Run: WrapCommandWithResources(staticStats, ResourceConfig{Resources: []ResourceType{ResourceDocker}}),
+	}
+	staticStatsSince time.Duration
+	staticStatsTop   int
+	staticStatsJSON  bool
+)
+
+// the main log_format of nginx.conf.tmpl, nginx escapes quotes inside variables as \x22
+var accessLogLine = regexp.MustCompile(`^(\S+) - (\S+) \[([^\]]+)\] "([^"]*)" (\d{3}) (\d+) "([^"]*)" "([^"]*)" "([^"]*)"$`)
+
+const accessLogTime = "02/Jan/2006:15:04:05 -0700"
+
+type accessLogEntry struct {
+	time     time.Time
+	client   string
+	path     string
+	status   int
+	bytes    int64
+	referrer string
+}
+
+func parseAccessLog(line string) (accessLogEntry, bool) {
+	m := accessLogLine.FindStringSubmatch(line)
+	if m == nil {
+		return accessLogEntry{}, false
+	}
+	t, err := time.Parse(accessLogTime, m[3])
+	if err != nil {
+		return accessLogEntry{}, false
+	}
+	status, _ := strconv.Atoi(m[5])
+	bytes, _ := strconv.ParseInt(m[6], 10, 64)
+	entry := accessLogEntry{time: t, client: m[1], status: status, bytes: bytes, referrer: m[7]}
+	// behind a reverse proxy remote_addr is the proxy, the first forwarded address is the client
+	if forwarded, _, _ := strings.Cut(m[9], ","); forwarded != "-" && forwarded != "" {
+		entry.client = strings.TrimSpace(forwarded)
+	}
+	// METHOD /path?query PROTOCOL, garbage requests have no path
+	if fields := strings.Fields(m[4]); len(fields) >= 2 {
+		entry.path, _, _ = strings.Cut(fields[1], "?")
+		if unescaped, err := url.PathUnescape(entry.path); err == nil {
+			entry.path = unescaped
+		}
+	}
+	return entry, true
+}
+
+type staticStatsCount struct {
+	Name     string `json:"name"`
+	Requests int    `json:"requests"`
+	Bytes    int64  `json:"bytes"`
+}
+
+type staticStatsReport struct {
+	Since         time.Time          `json:"since"`
+	Until         time.Time          `json:"until"`
+	Requests      int                `json:"requests"`
+	BytesServed   int64              `json:"bytes_served"`
+	UniqueClients int                `json:"unique_clients"`
+	Statuses      map[int]int        `json:"statuses"`
+	TopFiles      []staticStatsCount `json:"top_files"`
+	TopReferrers  []staticStatsCount `json:"top_referrers"`
+	// lines that are not in the main format, e.g. written by an older nginx.conf
+	Unparsed int `json:"unparsed"`
+}
+
+type staticStatsCollector struct {
+	report    staticStatsReport
+	clients   map[string]bool
+	files     map[string]*staticStatsCount
+	referrers map[string]*staticStatsCount
+}
+
+func newStaticStatsCollector(since time.Time, until time.Time) *staticStatsCollector {
+	return &staticStatsCollector{
+		report:    staticStatsReport{Since: since, Until: until, Statuses: make(map[int]int)},
+		clients:   make(map[string]bool),
+		files:     make(map[string]*staticStatsCount),
+		referrers: make(map[string]*staticStatsCount),
+	}
+}
+
+func countInto(counts map[string]*staticStatsCount, name string, bytes int64) {
+	c, ok := counts[name]
+	if !ok {
+		c = &staticStatsCount{Name: name}
+		counts[name] = c
+	}
+	c.Requests++
+	c.Bytes += bytes
+}
+
+// ownReferrer reports whether the referrer is one of the static server's own pages, e.g. a directory listing
+func ownReferrer(referrer string) bool {
+	u, err := url.Parse(referrer)
+	return err == nil && slices.Contains(cfg.Static.Nginx.ServerNames, u.Hostname())
+}
+
+func (c *staticStatsCollector) add(entry accessLogEntry) {
+	if entry.time.Before(c.report.Since) {
+		return
+	}
+	c.report.Requests++
+	c.report.BytesServed += entry.bytes
+	c.report.Statuses[entry.status]++
+	c.clients[entry.client] = true
+	// downloads only, listings end with a slash
+	if entry.status >= 200 && entry.status < 300 && entry.path != "" && !strings.HasSuffix(entry.path, "/") && !strings.HasPrefix(entry.path, "/fancyindex/") {
+		countInto(c.files, entry.path, entry.bytes)
+	}
+	if entry.referrer != "-" && entry.referrer != "" && !ownReferrer(entry.referrer) {
+		countInto(c.referrers, entry.referrer, entry.bytes)
+	}
+}
+
+func topCounts(counts map[string]*staticStatsCount, n int) []staticStatsCount {
+	top := make([]staticStatsCount, 0, len(counts))
+	for _, c := range counts {
+		top = append(top, *c)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Requests != top[j].Requests {
+			return top[i].Requests > top[j].Requests
+		}
+		return top[i].Name < top[j].Name
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+func (c *staticStatsCollector) finish(top int) staticStatsReport {
+	c.report.UniqueClients = len(c.clients)
+	c.report.TopFiles = topCounts(c.files, top)
+	c.report.TopReferrers = topCounts(c.referrers, top)
+	return c.report
+}
+
+// readAccessLog feeds the access log lines the static container wrote to stdout since the given time into the collector.
+// The error log goes to stderr and is skipped.
+func (a *AppCtx) readAccessLog(since time.Time, collector *staticStatsCollector) error {
+	logs, err := a.Docker.Client.ContainerLogs(a.Context, cfg.Static.ContainerName, container.LogsOptions{
+		ShowStdout: true,
+		Since:      strconv.FormatInt(since.Unix(), 10),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read static container logs: %w", err)
+	}
+	defer logs.Close()
+	stdout, writer := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(writer, io.Discard, logs)
+		writer.CloseWithError(err)
+	}()
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry, ok := parseAccessLog(scanner.Text())
+		if !ok {
+			collector.report.Unparsed++
+			continue
+		}
+		collector.add(entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read static container logs: %w", err)
+	}
+	return nil
+}
+
+func printStaticStats(report staticStatsReport) error {
+	fmt.Printf("%s - %s\n", report.Since.Local().Format(time.DateTime), report.Until.Local().Format(time.DateTime))
+	fmt.Printf("%d requests from %d clients, %.1f MiB served\n", report.Requests, report.UniqueClients, float64(report.BytesServed)/(1<<20))
+	if report.Unparsed > 0 {
+		color.Yellow("%d lines are not in the main log format and were skipped", report.Unparsed)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	statuses := make([]int, 0, len(report.Statuses))
+	for status := range report.Statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+	fmt.Fprintln(tw, "\nSTATUS\tREQUESTS")
+	for _, status := range statuses {
+		fmt.Fprintf(tw, "%d\t%d\n", status, report.Statuses[status])
+	}
+	for _, section := range []struct {
+		title  string
+		counts []staticStatsCount
+	}{{"FILE", report.TopFiles}, {"REFERRER", report.TopReferrers}} {
+		fmt.Fprintf(tw, "\n%s\tREQUESTS\tMIB\n", section.title)
+		for _, c := range section.counts {
+			fmt.Fprintf(tw, "%s\t%d\t%.1f\n", c.Name, c.Requests, float64(c.Bytes)/(1<<20))
+		}
+	}
+	return tw.Flush()
+}
+
+func staticStats(cmd *cobra.Command, args []string) {
+	app := GetApp(cmd)
+	until := time.Now()
+	since := until.Add(-staticStatsSince)
+	inspect, err := app.Docker.Client.ContainerInspect(app.Context, cfg.Static.ContainerName)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to inspect static container")
+		return
+	}
+	created, err := time.Parse(time.RFC3339Nano, inspect.Created)
+	truncated := err == nil && created.After(since)
+	if truncated {
+		since = created
+	}
+	collector := newStaticStatsCollector(since, until)
+	app.Spinner.Prefix = "reading access log"
+	if err := app.readAccessLog(since, collector); err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	report := collector.finish(staticStatsTop)
+	app.Spinner.Stop()
+	if staticStatsJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			log.Error().Err(err).Msg("failed to encode stats")
+		}
+		return
+	}
+	if err := printStaticStats(report); err != nil {
+		log.Error().Err(err).Msg("failed to print stats")
+	}
+	if truncated {
+		color.Yellow("\nthe static container was created within --since, requests served by the previous container are not included")
+	}
+}
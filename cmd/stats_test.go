@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/caner-cetin/oblivion/internal/config"
+)
+
+func TestParseAccessLog(t *testing.T) {
+	at := time.Date(2026, time.March, 4, 10, 20, 30, 0, time.FixedZone("", 3*60*60))
+	tests := []struct {
+		name string
+		line string
+		want accessLogEntry
+		ok   bool
+	}{
+		{
+			name: "download",
+			line: `172.18.0.1 - - [04/Mar/2026:10:20:30 +0300] "GET /files/a%20b.pdf?dl=1 HTTP/1.1" 200 1024 "https://example.com/post" "curl/8.0" "-"`,
+			want: accessLogEntry{time: at, client: "172.18.0.1", path: "/files/a b.pdf", status: 200, bytes: 1024, referrer: "https://example.com/post"},
+			ok:   true,
+		},
+		{
+			name: "forwarded client wins over the proxy",
+			line: `172.18.0.1 - - [04/Mar/2026:10:20:30 +0300] "GET / HTTP/1.1" 304 0 "-" "Mozilla/5.0" "203.0.113.7, 10.0.0.1"`,
+			want: accessLogEntry{time: at, client: "203.0.113.7", path: "/", status: 304, bytes: 0, referrer: "-"},
+			ok:   true,
+		},
+		{
+			name: "garbage request has no path",
+			line: `198.51.100.2 - - [04/Mar/2026:10:20:30 +0300] "\x16\x03\x01" 400 150 "-" "-" "-"`,
+			want: accessLogEntry{time: at, client: "198.51.100.2", status: 400, bytes: 150, referrer: "-"},
+			ok:   true,
+		},
+		{
+			name: "combined format without forwarded-for",
+			line: `172.18.0.1 - - [04/Mar/2026:10:20:30 +0300] "GET / HTTP/1.1" 200 10 "-" "curl/8.0"`,
+		},
+		{
+			name: "bad time",
+			line: `172.18.0.1 - - [yesterday] "GET / HTTP/1.1" 200 10 "-" "curl/8.0" "-"`,
+		},
+		{
+			name: "error log",
+			line: `2026/03/04 10:20:30 [error] 29#29: *1 open() "/srv/static/x" failed (2: No such file or directory)`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseAccessLog(tt.line)
+			if ok != tt.ok {
+				t.Fatalf("parseAccessLog() ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if !got.time.Equal(tt.want.time) {
+				t.Errorf("time = %v, want %v", got.time, tt.want.time)
+			}
+			got.time = tt.want.time
+			if got != tt.want {
+				t.Errorf("parseAccessLog() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStaticStatsCollector(t *testing.T) {
+	setTestConfig(t, func(c *config.Root) {
+		c.Static.Nginx.ServerNames = []string{"static.example.com"}
+	})
+	since := time.Date(2026, time.March, 4, 0, 0, 0, 0, time.UTC)
+	collector := newStaticStatsCollector(since, since.Add(24*time.Hour))
+	entries := []accessLogEntry{
+		// before the window
+		{time: since.Add(-time.Minute), client: "a", path: "/old.pdf", status: 200, bytes: 1, referrer: "-"},
+		{time: since, client: "a", path: "/a.pdf", status: 200, bytes: 100, referrer: "https://blog.example.com/"},
+		{time: since.Add(time.Hour), client: "b", path: "/a.pdf", status: 200, bytes: 100, referrer: "https://static.example.com/"},
+		{time: since.Add(time.Hour), client: "b", path: "/b.pdf", status: 206, bytes: 50, referrer: "https://blog.example.com/"},
+		// listings, icons of the listing and failures are not downloads
+		{time: since.Add(time.Hour), client: "c", path: "/docs/", status: 200, bytes: 10, referrer: "-"},
+		{time: since.Add(time.Hour), client: "c", path: "/fancyindex/icon.svg", status: 200, bytes: 5, referrer: "-"},
+		{time: since.Add(time.Hour), client: "c", path: "/missing.pdf", status: 404, bytes: 0, referrer: "-"},
+	}
+	for _, entry := range entries {
+		collector.add(entry)
+	}
+	report := collector.finish(1)
+	if report.Requests != 6 || report.BytesServed != 265 || report.UniqueClients != 3 {
+		t.Errorf("requests %d, bytes %d, clients %d", report.Requests, report.BytesServed, report.UniqueClients)
+	}
+	if report.Statuses[200] != 4 || report.Statuses[206] != 1 || report.Statuses[404] != 1 {
+		t.Errorf("statuses = %v", report.Statuses)
+	}
+	if want := []staticStatsCount{{Name: "/a.pdf", Requests: 2, Bytes: 200}}; !slices.Equal(report.TopFiles, want) {
+		t.Errorf("top files = %+v, want %+v", report.TopFiles, want)
+	}
+	if want := []staticStatsCount{{Name: "https://blog.example.com/", Requests: 2, Bytes: 150}}; !slices.Equal(report.TopReferrers, want) {
+		t.Errorf("top referrers = %+v, want %+v", report.TopReferrers, want)
+	}
+}
+
+func TestTopCountsBreaksTiesByName(t *testing.T) {
+	counts := map[string]*staticStatsCount{
+		"/b": {Name: "/b", Requests: 2},
+		"/a": {Name: "/a", Requests: 2},
+		"/c": {Name: "/c", Requests: 3},
+		"/d": {Name: "/d", Requests: 1},
+	}
+	var names []string
+	for _, c := range topCounts(counts, 3) {
+		names = append(names, c.Name)
+	}
+	if want := []string{"/c", "/a", "/b"}; !slices.Equal(names, want) {
+		t.Fatalf("topCounts() = %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,349 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/caner-cetin/oblivion/internal"
+	"github.com/fatih/color"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	staticSyncCmd = &cobra.Command{
+		Use:   "sync <local-dir> [remote-subpath]",
+		Short: "copy changed files from a local directory into the static path",
+		Args:  cobra.RangeArgs(1, 2),
+		Run:   WrapCommandWithResources(staticSync, ResourceConfig{}),
+	}
+	staticSyncDelete bool
+	staticSyncDryRun bool
+)
+
+type syncAction int
+
+const (
+	syncAdd syncAction = iota
+	syncUpdate
+	syncDelete
+)
+
+func (s syncAction) String() string {
+	switch s {
+	case syncAdd:
+		return "+"
+	case syncUpdate:
+		return "~"
+	default:
+		return "-"
+	}
+}
+
+type syncChange struct {
+	action syncAction
+	// relative to the source and destination roots
+	path string
+}
+
+type syncPlan struct {
+	changes   []syncChange
+	unchanged int
+	// source directories, created under the destination even when empty
+	dirs []string
+	// paths that are a file on one side and a directory on the other, nothing is synced while there are any
+	conflicts []syncConflict
+}
+
+type syncConflict struct {
+	path string
+	// the source has the directory, the destination the file
+	srcDir bool
+}
+
+func (c syncConflict) String() string {
+	if c.srcDir {
+		return fmt.Sprintf("%s is a directory locally but a file in the static path", c.path)
+	}
+	return fmt.Sprintf("%s is a file locally but a directory in the static path", c.path)
+}
+
+// planSync compares src against dest by size, then modification time, then SHA-256, so unchanged files are only hashed
+// when their timestamps differ. Deletions are only planned when withDelete is set. A path that is a file on one
+// side and a directory on the other is a conflict, neither overwriting nor deleting it is done without asking.
+func planSync(src string, dest string, withDelete bool) (*syncPlan, error) {
+	plan := &syncPlan{}
+	seen := make(map[string]bool)
+	// directories of the destination that are files in the source, not walked for deletions
+	conflicted := make(map[string]bool)
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		seen[rel] = true
+		if d.IsDir() {
+			if rel == "." {
+				return nil
+			}
+			// a symlink to a directory is fine, MkdirAll follows it
+			if destInfo, err := os.Stat(filepath.Join(dest, rel)); err == nil && !destInfo.IsDir() {
+				plan.conflicts = append(plan.conflicts, syncConflict{path: rel, srcDir: true})
+				return filepath.SkipDir
+			}
+			plan.dirs = append(plan.dirs, rel)
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			log.Warn().Str("path", path).Msg("skipping, only regular files are synced")
+			return nil
+		}
+		srcInfo, err := d.Info()
+		if err != nil {
+			return err
+		}
+		destInfo, err := os.Lstat(filepath.Join(dest, rel))
+		if errors.Is(err, fs.ErrNotExist) {
+			plan.changes = append(plan.changes, syncChange{syncAdd, rel})
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if destInfo.IsDir() {
+			plan.conflicts = append(plan.conflicts, syncConflict{path: rel})
+			conflicted[rel] = true
+			return nil
+		}
+		same, err := sameFile(path, srcInfo, filepath.Join(dest, rel), destInfo)
+		if err != nil {
+			return err
+		}
+		if same {
+			plan.unchanged++
+		} else {
+			plan.changes = append(plan.changes, syncChange{syncUpdate, rel})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk %s: %w", src, err)
+	}
+	if !withDelete {
+		return plan, nil
+	}
+	if _, err := os.Stat(dest); errors.Is(err, fs.ErrNotExist) {
+		return plan, nil
+	}
+	err = filepath.WalkDir(dest, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dest, path)
+		if err != nil {
+			return err
+		}
+		if conflicted[rel] {
+			return filepath.SkipDir
+		}
+		if seen[rel] {
+			return nil
+		}
+		plan.changes = append(plan.changes, syncChange{syncDelete, rel})
+		if d.IsDir() {
+			// removed as a whole
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk %s: %w", dest, err)
+	}
+	return plan, nil
+}
+
+func sameFile(srcPath string, srcInfo fs.FileInfo, destPath string, destInfo fs.FileInfo) (bool, error) {
+	if !destInfo.Mode().IsRegular() || srcInfo.Size() != destInfo.Size() {
+		return false, nil
+	}
+	if srcInfo.ModTime().Equal(destInfo.ModTime()) {
+		return true, nil
+	}
+	srcSum, err := fileSHA256(srcPath)
+	if err != nil {
+		return false, err
+	}
+	destSum, err := fileSHA256(destPath)
+	if err != nil {
+		return false, err
+	}
+	return srcSum == destSum, nil
+}
+
+func fileSHA256(path string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	f, err := os.Open(path)
+	if err != nil {
+		return sum, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer internal.CloseReader(f)
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// staticOwner returns the uid:gid written files get. Only root can give files to root,
+// anyone else keeps the uid and relies on being in the uploader group.
+func staticOwner() (int, int, error) {
+	gid, err := staticUploaderGID()
+	if err != nil {
+		return 0, 0, err
+	}
+	if os.Geteuid() == 0 {
+		return 0, gid, nil
+	}
+	return -1, gid, nil
+}
+
+func staticMkdir(path string, uid int, gid int) error {
+	if err := os.MkdirAll(path, staticDirMode); err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	if err := os.Chown(path, uid, gid); err != nil {
+		return fmt.Errorf("chown failed for %s: %w", path, err)
+	}
+	// MkdirAll is subject to the umask
+	if err := os.Chmod(path, staticDirMode); err != nil {
+		return fmt.Errorf("chmod failed for %s: %w", path, err)
+	}
+	return nil
+}
+
+// copyFileAtomic writes src next to dest and renames it into place, so nginx never serves a partially written file
+func copyFileAtomic(src string, dest string, uid int, gid int) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", src, err)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer internal.CloseReader(in)
+	tmp, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".oblivion-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for %s: %w", dest, err)
+	}
+	// no-op once the rename went through
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", dest, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync %s: %w", dest, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", dest, err)
+	}
+	if err := os.Chown(tmp.Name(), uid, gid); err != nil {
+		return fmt.Errorf("chown failed for %s: %w", dest, err)
+	}
+	if err := os.Chmod(tmp.Name(), staticFileMode); err != nil {
+		return fmt.Errorf("chmod failed for %s: %w", dest, err)
+	}
+	// keeps the next sync from hashing the file again
+	if err := os.Chtimes(tmp.Name(), info.ModTime(), info.ModTime()); err != nil {
+		return fmt.Errorf("failed to set modification time of %s: %w", dest, err)
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return fmt.Errorf("failed to move %s into place: %w", dest, err)
+	}
+	return nil
+}
+
+func staticSync(cmd *cobra.Command, args []string) {
+	app := GetApp(cmd)
+	src := args[0]
+	if info, err := os.Stat(src); err != nil || !info.IsDir() {
+		log.Error().Str("path", src).Msg("local path must be an existing directory")
+		return
+	}
+	dest := cfg.Static.StaticPath
+	if len(args) == 2 {
+		if !filepath.IsLocal(args[1]) {
+			log.Error().Str("subpath", args[1]).Msg("remote subpath must be relative and stay inside the static path")
+			return
+		}
+		dest = filepath.Join(dest, args[1])
+	}
+	app.Spinner.Prefix = "comparing files"
+	plan, err := planSync(src, dest, staticSyncDelete)
+	if err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	sort.Slice(plan.changes, func(i, j int) bool { return plan.changes[i].path < plan.changes[j].path })
+	app.Spinner.Stop()
+	if len(plan.conflicts) > 0 {
+		for _, conflict := range plan.conflicts {
+			fmt.Printf("! %s\n", conflict)
+		}
+		log.Error().Msgf("nothing was synced, %d path(s) differ in type, rename or remove one side", len(plan.conflicts))
+		exitCode = 1
+		return
+	}
+	if staticSyncDryRun {
+		for _, change := range plan.changes {
+			fmt.Printf("%s %s\n", change.action, filepath.Join(dest, change.path))
+		}
+		color.Cyan("dry run, %d change(s) and %d unchanged file(s)", len(plan.changes), plan.unchanged)
+		return
+	}
+
+	uid, gid, err := staticOwner()
+	if err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	if err := staticMkdir(dest, uid, gid); err != nil {
+		log.Error().Err(err).Send()
+		return
+	}
+	for _, dir := range plan.dirs {
+		if err := staticMkdir(filepath.Join(dest, dir), uid, gid); err != nil {
+			log.Error().Err(err).Send()
+			return
+		}
+	}
+	counts := make(map[syncAction]int)
+	for _, change := range plan.changes {
+		target := filepath.Join(dest, change.path)
+		switch change.action {
+		case syncAdd, syncUpdate:
+			err = copyFileAtomic(filepath.Join(src, change.path), target, uid, gid)
+		case syncDelete:
+			err = os.RemoveAll(target)
+		}
+		if err != nil {
+			log.Error().Err(err).Msgf("sync stopped after %d change(s)", counts[syncAdd]+counts[syncUpdate]+counts[syncDelete])
+			return
+		}
+		fmt.Printf("%s %s\n", change.action, target)
+		counts[change.action]++
+	}
+	color.Green("%d added, %d updated, %d deleted, %d unchanged", counts[syncAdd], counts[syncUpdate], counts[syncDelete], plan.unchanged)
+}
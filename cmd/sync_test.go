@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// writeTree creates files (names without a trailing slash) and directories (names with one) under root
+func writeTree(t *testing.T, root string, entries map[string]string, mtime time.Time) {
+	t.Helper()
+	for name, contents := range entries {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if name[len(name)-1] == '/' {
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPlanSync(t *testing.T) {
+	mtime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		src        map[string]string
+		dest       map[string]string
+		destMtime  time.Time
+		withDelete bool
+		want       []string
+		unchanged  int
+		dirs       []string
+		conflicts  []syncConflict
+	}{
+		{
+			name: "empty destination adds everything",
+			src:  map[string]string{"a.txt": "a", "sub/b.txt": "b", "empty/": ""},
+			want: []string{"+ a.txt", "+ sub/b.txt"},
+			dirs: []string{"empty", "sub"},
+		},
+		{
+			name:      "same size and time is unchanged",
+			src:       map[string]string{"a.txt": "a"},
+			dest:      map[string]string{"a.txt": "a"},
+			destMtime: mtime,
+			unchanged: 1,
+		},
+		{
+			name:      "same content with a different time is unchanged",
+			src:       map[string]string{"a.txt": "a"},
+			dest:      map[string]string{"a.txt": "a"},
+			destMtime: mtime.Add(time.Hour),
+			unchanged: 1,
+		},
+		{
+			name:      "different content is updated",
+			src:       map[string]string{"a.txt": "a", "b.txt": "bb"},
+			dest:      map[string]string{"a.txt": "x", "b.txt": "b"},
+			destMtime: mtime.Add(time.Hour),
+			want:      []string{"~ a.txt", "~ b.txt"},
+		},
+		{
+			name:      "extra files are kept without delete",
+			src:       map[string]string{"a.txt": "a"},
+			dest:      map[string]string{"a.txt": "a", "old.txt": "o", "gone/c.txt": "c"},
+			destMtime: mtime,
+			unchanged: 1,
+		},
+		{
+			name:       "extra files and directories are deleted with delete",
+			src:        map[string]string{"a.txt": "a"},
+			dest:       map[string]string{"a.txt": "a", "old.txt": "o", "gone/c.txt": "c"},
+			destMtime:  mtime,
+			withDelete: true,
+			want:       []string{"- gone", "- old.txt"},
+			unchanged:  1,
+		},
+		{
+			name:       "file replaced by a directory is a conflict",
+			src:        map[string]string{"docs/index.html": "i", "a.txt": "a"},
+			dest:       map[string]string{"docs": "old file"},
+			destMtime:  mtime,
+			withDelete: true,
+			want:       []string{"+ a.txt"},
+			conflicts:  []syncConflict{{path: "docs", srcDir: true}},
+		},
+		{
+			name:       "directory replaced by a file is a conflict",
+			src:        map[string]string{"docs": "new file"},
+			dest:       map[string]string{"docs/index.html": "i"},
+			destMtime:  mtime,
+			withDelete: true,
+			conflicts:  []syncConflict{{path: "docs"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dest := t.TempDir(), filepath.Join(t.TempDir(), "dest")
+			writeTree(t, src, tt.src, mtime)
+			if tt.dest != nil {
+				writeTree(t, dest, tt.dest, tt.destMtime)
+			}
+			plan, err := planSync(src, dest, tt.withDelete)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, change := range plan.changes {
+				got = append(got, change.action.String()+" "+filepath.ToSlash(change.path))
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("changes = %v, want %v", got, tt.want)
+			}
+			if plan.unchanged != tt.unchanged {
+				t.Errorf("unchanged = %d, want %d", plan.unchanged, tt.unchanged)
+			}
+			dirs := slices.Clone(plan.dirs)
+			slices.Sort(dirs)
+			if tt.dirs != nil && !slices.Equal(dirs, tt.dirs) {
+				t.Errorf("dirs = %v, want %v", dirs, tt.dirs)
+			}
+			if !slices.Equal(plan.conflicts, tt.conflicts) {
+				t.Errorf("conflicts = %v, want %v", plan.conflicts, tt.conflicts)
+			}
+		})
+	}
+}
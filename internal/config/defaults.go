@@ -2,6 +2,7 @@ package config
 
 // this wont override your config https://stackoverflow.com/a/30445480
 func (c *Root) SetDefaults() {
+	c.Version = CurrentVersion
 	c.Docker.Socket = "unix:///var/run/docker.sock"
 	c.Postgres.DB = "postgres"
 	c.Postgres.Primary.Port = "5432"
@@ -17,23 +18,46 @@ func (c *Root) SetDefaults() {
 	c.Networks.DatabaseNetworkName = "database_bridge"
 	c.Networks.UptimeNetworkName = "uptime_bridge"
 	c.Onepass.VaultName = "Server"
+	c.Onepass.Cache.Enabled = false
+	c.Onepass.Cache.TTL = "12h"
+	c.Onepass.Cache.KeySource = "machine"
 	c.Static.UploaderUser = "caner"
 	c.Static.StaticPath = "/var/www/servers/cansu.dev/static"
 	c.Static.Port = "44444"
 	c.Static.ImageName = "cansu.dev-static-nginx"
 	c.Static.ContainerName = "file-server"
+	c.Static.Proxy.ContainerName = "file-server-proxy"
+	c.Static.Proxy.Image = "nginx:alpine"
+	c.Static.Nginx.ServerNames = []string{"cansu.dev"}
+	c.Static.Nginx.ListingPaths = []string{"/"}
+	c.Static.Nginx.Fancyindex = true
+	c.Static.Nginx.FancyindexShowPath = true
+	c.Static.Nginx.FancyindexTimeFormat = "%Y-%m-%d %H:%M"
+	c.Static.Nginx.Gzip = true
+	c.Static.Nginx.SecureLink.Path = "/private/"
+	c.Static.FTP.ContainerName = "cansu.dev-static-sftp"
+	c.Static.FTP.Image = "atmoz/sftp:alpine"
+	c.Static.FTP.Port = "2222"
+	c.Static.FTP.KeysVolume = "static_sftp_keys"
 	c.Kuma.ContainerName = "uptime"
 	c.Kuma.ImageName = "louislam/uptime-kuma:1"
 	c.Kuma.Port = "3001"
 	c.Kuma.DataVolume = "kuma_kuma_data"
+	c.Kuma.ManagedMonitors = true
 	c.Networks.GrafanaNetworkName = "grafana_bridge"
 	c.Networks.LokiNetworkName = "loki_bridge"
+	c.Networks.StaticNetworkName = "static_bridge"
 	c.Observer.ContainerNames.Grafana = "cansu.dev-observer-grafana"
 	c.Observer.ContainerNames.Prometheus = "cansu.dev-observer-prometheus"
 	c.Observer.ContainerNames.Loki = "cansu.dev-observer-loki"
 	c.Observer.ContainerNames.Cadvisor = "cansu.dev-observer-cadvisor"
 	c.Observer.ContainerNames.NodeExporter = "cansu.dev-observer-node_exporter"
 	c.Observer.ContainerNames.Alertmanager = "cansu.dev-observer-alertmanager"
+	c.Observer.ContainerNames.PostgresExporter = "cansu.dev-observer-postgres_exporter"
+	c.Observer.ContainerNames.PgbouncerExporter = "cansu.dev-observer-pgbouncer_exporter"
+	c.Observer.ContainerNames.RedisExporter = "cansu.dev-observer-redis_exporter"
+	c.Observer.ContainerNames.Promtail = "cansu.dev-observer-promtail"
+	c.Observer.ContainerNames.BlackboxExporter = "cansu.dev-observer-blackbox_exporter"
 	c.Observer.Ports.Grafana = "3000"
 	c.Observer.Ports.Prometheus = "9090"
 	c.Observer.Ports.NodeExporter = "9100"
@@ -42,16 +66,40 @@ func (c *Root) SetDefaults() {
 	c.Observer.Ports.Loki = "3169"
 	c.Observer.Volumes.Grafana = "grafana_data"
 	c.Observer.Volumes.Prometheus = "prometheus_data"
+	c.Observer.Volumes.Promtail = "promtail_data"
 	c.Observer.Images.Grafana = "grafana/grafana:latest"
 	c.Observer.Images.Prometheus = "prom/prometheus:latest"
 	c.Observer.Images.NodeExporter = "quay.io/prometheus/node-exporter:latest"
 	c.Observer.Images.Alertmanager = "prom/alertmanager:latest"
 	c.Observer.Images.Cadvisor = "gcr.io/cadvisor/cadvisor"
 	c.Observer.Images.Loki = "grafana/loki:latest"
-	c.Observer.Binds.Prometheus = "/Users/canercetin/Git/oblivion/cmd/config/prometheus/"
-	c.Observer.Binds.Grafana = "/Users/canercetin/Git/oblivion/cmd/config/grafana/"
-	c.Observer.Binds.Alertmanager = "/Users/canercetin/Git/oblivion/cmd/config/alertmanager/"
-	c.Observer.Binds.Loki = "/Users/canercetin/Git/oblivion/cmd/config/loki"
+	c.Observer.Images.PostgresExporter = "quay.io/prometheuscommunity/postgres-exporter:latest"
+	c.Observer.Images.PgbouncerExporter = "prometheuscommunity/pgbouncer-exporter:latest"
+	c.Observer.Images.RedisExporter = "oliver006/redis_exporter:latest"
+	c.Observer.Images.Promtail = "grafana/promtail:latest"
+	c.Observer.Images.BlackboxExporter = "prom/blackbox-exporter:latest"
+	c.Observer.Logs.Shipping = "off"
+	c.Observer.Enabled.Grafana = true
+	c.Observer.Enabled.Prometheus = true
+	c.Observer.Enabled.Loki = true
+	c.Observer.Enabled.Cadvisor = true
+	c.Observer.Enabled.NodeExporter = true
+	c.Observer.Enabled.Alertmanager = true
+	c.Observer.ConfigVolumes.Prometheus = "observer_prometheus_config"
+	c.Observer.ConfigVolumes.Grafana = "observer_grafana_config"
+	c.Observer.ConfigVolumes.Alertmanager = "observer_alertmanager_config"
+	c.Observer.ConfigVolumes.Loki = "observer_loki_config"
+	c.Observer.ConfigVolumes.Promtail = "observer_promtail_config"
+	c.Observer.ConfigVolumes.BlackboxExporter = "observer_blackbox_exporter_config"
+	c.Observer.Prometheus.ScrapeInterval = "15s"
+	c.Observer.Prometheus.EvaluationInterval = "15s"
+	c.Observer.Prometheus.RetentionTime = "15d"
+	c.Observer.Prometheus.BuiltinRules = true
+	c.Observer.Alerting.GroupBy = []string{"alertname", "job"}
+	c.Observer.Alerting.GroupWait = "30s"
+	c.Observer.Alerting.GroupInterval = "5m"
+	c.Observer.Alerting.RepeatInterval = "3h"
+	c.Observer.Alerting.TestWebhookPort = "9097"
 	c.Dragonfly.Port = "6379"
 	c.Dragonfly.ContainerName = "cansu.dev-redis"
 	c.Dragonfly.Image = "docker.dragonflydb.io/dragonflydb/dragonfly"
@@ -60,4 +108,6 @@ func (c *Root) SetDefaults() {
 	c.Playground.Backend.Repository = "https://github.com/caner-cetin/code-cansu-dev"
 	c.Playground.Backend.ContainerName = "cansu.dev-playground-backend"
 	c.Playground.Backend.ImageName = "playground-backend"
+	c.Playground.Backend.KeepImages = 3
+	c.Playground.Backend.Healthcheck = []string{"CMD-SHELL", "nc -z 127.0.0.1 6767"}
 }
@@ -0,0 +1,191 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// document is a config file as lines. Migrations edit it line by line, so comments, ordering and every key a
+// migration does not touch stay as the user wrote them.
+type document struct {
+	lines []string
+}
+
+// documentLine is a header or key/value line of a document, lines inside multi-line values are not listed
+type documentLine struct {
+	index int
+	// table the line belongs to, "" for top level. For headers the table they open
+	table  string
+	header bool
+	// dotted key relative to table and its raw TOML value, empty for headers
+	key   string
+	value string
+}
+
+func parseDocument(contents []byte) *document {
+	return &document{lines: strings.Split(string(contents), "\n")}
+}
+
+func (d *document) bytes() []byte {
+	return []byte(strings.Join(d.lines, "\n"))
+}
+
+func (d *document) scan() []documentLine {
+	var (
+		parsed    []documentLine
+		table     string
+		multiline string
+		depth     int
+	)
+	for i, line := range d.lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case multiline != "":
+			if strings.Contains(trimmed, multiline) {
+				multiline = ""
+			}
+			continue
+		case depth > 0:
+			depth += bracketDepth(trimmed)
+			continue
+		case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+			continue
+		case strings.HasPrefix(trimmed, "["):
+			name := strings.TrimLeft(trimmed, "[")
+			name, _, _ = strings.Cut(name, "]")
+			table = normalizeKey(name)
+			parsed = append(parsed, documentLine{index: i, table: table, header: true})
+			continue
+		}
+		key, value, ok := strings.Cut(trimmed, "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		for _, delim := range []string{`"""`, `'''`} {
+			if strings.HasPrefix(value, delim) && strings.Count(value, delim) == 1 {
+				multiline = delim
+			}
+		}
+		if multiline == "" {
+			depth = bracketDepth(value)
+		}
+		parsed = append(parsed, documentLine{index: i, table: table, key: normalizeKey(key), value: value})
+	}
+	return parsed
+}
+
+// full returns the dotted key of a key/value line from the root of the document
+func (l documentLine) full() string {
+	if l.table == "" {
+		return l.key
+	}
+	return l.table + "." + l.key
+}
+
+// renameTable moves the table from, its sub-tables and dotted top-level keys below it to to.
+// It fails when the document already sets anything below to, the two would have to be merged by hand.
+func (d *document) renameTable(from string, to string) error {
+	below := func(key string, table string) bool {
+		return key == table || strings.HasPrefix(key, table+".")
+	}
+	lines := d.scan()
+	for _, l := range lines {
+		if (l.header && below(l.table, to)) || (!l.header && below(l.full(), to)) {
+			return fmt.Errorf("both [%s] and [%s] are set, merge them into [%s] by hand", from, to, to)
+		}
+	}
+	for _, l := range lines {
+		line := d.lines[l.index]
+		switch {
+		case l.header && below(l.table, from):
+			open := strings.Index(line, "[")
+			closing := strings.Index(line, "]")
+			brackets := strings.Count(line[open:closing], "[")
+			d.lines[l.index] = line[:open+brackets] + to + strings.TrimPrefix(l.table, from) + line[closing:]
+		case !l.header && l.table == "" && below(l.key, from):
+			indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+			_, rest, _ := strings.Cut(line, "=")
+			d.lines[l.index] = indent + to + strings.TrimPrefix(l.key, from) + " =" + rest
+		}
+	}
+	return nil
+}
+
+// deleteKeys removes the single-line keys match accepts and returns their dotted keys.
+// match receives the decoded value.
+func (d *document) deleteKeys(match func(key string, value any) bool) []string {
+	var deleted []string
+	lines := d.scan()
+	for i := len(lines) - 1; i >= 0; i-- {
+		l := lines[i]
+		if l.header {
+			continue
+		}
+		var decoded struct{ V any }
+		if err := toml.Unmarshal([]byte("V = "+l.value), &decoded); err != nil {
+			// spans several lines
+			continue
+		}
+		if match(l.full(), decoded.V) {
+			d.lines = append(d.lines[:l.index], d.lines[l.index+1:]...)
+			deleted = append(deleted, l.full())
+		}
+	}
+	return deleted
+}
+
+// setVersion replaces the top-level version key or adds it as the first line
+func (d *document) setVersion(version int) {
+	line := "version = " + strconv.Itoa(version)
+	for _, l := range d.scan() {
+		if l.header {
+			break
+		}
+		if l.key == "version" {
+			d.lines[l.index] = line
+			return
+		}
+	}
+	d.lines = append([]string{line, ""}, d.lines...)
+}
+
+// normalizeKey strips whitespace and quotes around the parts of a dotted key
+func normalizeKey(key string) string {
+	parts := strings.Split(key, ".")
+	for i, part := range parts {
+		parts[i] = strings.Trim(strings.TrimSpace(part), `"'`)
+	}
+	return strings.Join(parts, ".")
+}
+
+// bracketDepth counts the array brackets a line opens and does not close, ignoring strings and comments
+func bracketDepth(s string) int {
+	depth := 0
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			return depth
+		case r == '[':
+			depth++
+		case r == ']':
+			depth--
+		}
+	}
+	return depth
+}
@@ -0,0 +1,197 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+const (
+	EnvPrefix     = "OBLIVION_"
+	SourceDefault = "default"
+)
+
+// Provenance maps a dotted TOML key (Postgres.Primary.port) to the layer that last set it,
+// either SourceDefault, a file path or "env OBLIVION_..."
+type Provenance map[string]string
+
+// Loader merges configuration layers in order of increasing precedence:
+// defaults, the base file, the per-host overlay next to it and OBLIVION_<SECTION>_<KEY> environment variables.
+type Loader struct {
+	Path     string
+	Hostname string
+	// defaults to os.Environ()
+	Environ []string
+}
+
+// HostOverlayPath returns the per-host overlay for a base config path,
+// ~/.oblivion.toml with hostname "web1" becomes ~/.oblivion.web1.toml
+func HostOverlayPath(base string, hostname string) string {
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext) + "." + hostname + ext
+}
+
+// EnvName returns the environment variable overriding a dotted TOML key.
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// Load resets root to defaults and applies every layer on top of it.
+func (l *Loader) Load(root *Root) (Provenance, error) {
+	root.SetDefaults()
+	provenance := make(Provenance)
+	walkLeaves(reflect.ValueOf(root).Elem(), nil, func(key string, _ reflect.Value) {
+		provenance[key] = SourceDefault
+	})
+	if err := applyFile(root, l.Path, provenance); err != nil {
+		return nil, err
+	}
+	if l.Hostname != "" {
+		overlay := HostOverlayPath(l.Path, l.Hostname)
+		if err := applyFile(root, overlay, provenance); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	environ := l.Environ
+	if environ == nil {
+		environ = os.Environ()
+	}
+	if err := applyEnv(root, environ, provenance); err != nil {
+		return nil, err
+	}
+	return provenance, nil
+}
+
+func applyFile(root *Root, path string, provenance Provenance) error {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	// go-toml only overwrites keys present in the document, so this layers on top of what root already holds
+	if err := toml.Unmarshal(contents, root); err != nil {
+		return fmt.Errorf("failed to unmarshal config %s: %w", path, err)
+	}
+	var raw map[string]any
+	if err := toml.Unmarshal(contents, &raw); err != nil {
+		return fmt.Errorf("failed to unmarshal config %s: %w", path, err)
+	}
+	flattenKeys(raw, "", func(key string) {
+		provenance[key] = path
+	})
+	return nil
+}
+
+func applyEnv(root *Root, environ []string, provenance Provenance) error {
+	values := make(map[string]string)
+	for _, kv := range environ {
+		name, value, ok := strings.Cut(kv, "=")
+		if ok && strings.HasPrefix(name, EnvPrefix) {
+			values[name] = value
+		}
+	}
+	var errs []error
+	walkLeaves(reflect.ValueOf(root).Elem(), nil, func(key string, field reflect.Value) {
+		name := EnvName(key)
+		value, ok := values[name]
+		if !ok {
+			return
+		}
+		if err := setFromString(field, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			return
+		}
+		provenance[key] = "env " + name
+	})
+	return errors.Join(errs...)
+}
+
+func setFromString(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("expected a boolean: %w", err)
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		i, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("expected an integer: %w", err)
+		}
+		field.SetInt(i)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("cannot be set from the environment")
+		}
+		parts := strings.Split(value, ",")
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		field.Set(reflect.ValueOf(parts))
+	default:
+		return fmt.Errorf("cannot be set from the environment")
+	}
+	return nil
+}
+
+// calls fn for every non-table field of v with its dotted TOML key, arrays of tables count as a single leaf
+func walkLeaves(v reflect.Value, path []string, fn func(key string, field reflect.Value)) {
+	t := v.Type()
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name := tomlName(sf)
+		if name == "-" {
+			continue
+		}
+		field := v.Field(i)
+		fieldPath := append(append([]string{}, path...), name)
+		if field.Kind() == reflect.Struct {
+			walkLeaves(field, fieldPath, fn)
+			continue
+		}
+		fn(strings.Join(fieldPath, "."), field)
+	}
+}
+
+// calls fn for every non-table key of raw. An array of tables is reported as a key and so is every key of its
+// tables, indexed like Kuma.Monitors[0].name
+func flattenKeys(raw map[string]any, prefix string, fn func(key string)) {
+	for k, v := range raw {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		switch v := v.(type) {
+		case map[string]any:
+			flattenKeys(v, key, fn)
+			continue
+		case []any:
+			for i, element := range v {
+				if table, ok := element.(map[string]any); ok {
+					flattenKeys(table, fmt.Sprintf("%s[%d]", key, i), fn)
+				}
+			}
+		}
+		fn(key)
+	}
+}
+
+func tomlName(sf reflect.StructField) string {
+	tag := sf.Tag.Get("toml")
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "" {
+		return sf.Name
+	}
+	return name
+}
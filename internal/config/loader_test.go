@@ -0,0 +1,167 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestHostOverlayPath(t *testing.T) {
+	tests := []struct {
+		base, hostname, want string
+	}{
+		{"/root/.oblivion.toml", "web1", "/root/.oblivion.web1.toml"},
+		{"/etc/oblivion/config.toml", "db", "/etc/oblivion/config.db.toml"},
+		{"config", "web1", "config.web1"},
+	}
+	for _, tt := range tests {
+		if got := HostOverlayPath(tt.base, tt.hostname); got != tt.want {
+			t.Errorf("HostOverlayPath(%q, %q) = %q, want %q", tt.base, tt.hostname, got, tt.want)
+		}
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	if got := EnvName("Postgres.Primary.port"); got != "OBLIVION_POSTGRES_PRIMARY_PORT" {
+		t.Fatalf("EnvName = %q", got)
+	}
+}
+
+func TestLoaderLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		overlay string
+		environ []string
+		wantErr bool
+		check   func(t *testing.T, root *Root)
+		// dotted key to the layer expected to have set it, "file" and "overlay" stand for their paths
+		provenance map[string]string
+	}{
+		{
+			name: "defaults stay where the file is silent",
+			file: "[Kuma]\nport = \"3002\"\n",
+			check: func(t *testing.T, root *Root) {
+				if root.Kuma.Port != "3002" || root.Kuma.ContainerName != "uptime" {
+					t.Errorf("Kuma = %+v", root.Kuma)
+				}
+			},
+			provenance: map[string]string{"Kuma.port": "file", "Kuma.container_name": SourceDefault},
+		},
+		{
+			name:    "overlay wins over the file",
+			file:    "[Kuma]\nport = \"3002\"\nimage_name = \"kuma:base\"\n",
+			overlay: "[Kuma]\nport = \"3003\"\n",
+			check: func(t *testing.T, root *Root) {
+				if root.Kuma.Port != "3003" || root.Kuma.ImageName != "kuma:base" {
+					t.Errorf("Kuma = %+v", root.Kuma)
+				}
+			},
+			provenance: map[string]string{"Kuma.port": "overlay", "Kuma.image_name": "file"},
+		},
+		{
+			name:    "environment wins over both",
+			file:    "[Kuma]\nport = \"3002\"\n",
+			overlay: "[Kuma]\nport = \"3003\"\n",
+			environ: []string{
+				"OBLIVION_KUMA_PORT=3004",
+				"OBLIVION_KUMA_MANAGED_MONITORS=false",
+				"OBLIVION_OBSERVER_PROMETHEUS_RULE_FILES=/a.yml, /b.yml",
+				"UNRELATED=1",
+			},
+			check: func(t *testing.T, root *Root) {
+				if root.Kuma.Port != "3004" || root.Kuma.ManagedMonitors {
+					t.Errorf("Kuma = %+v", root.Kuma)
+				}
+				if !slices.Equal(root.Observer.Prometheus.RuleFiles, []string{"/a.yml", "/b.yml"}) {
+					t.Errorf("RuleFiles = %v", root.Observer.Prometheus.RuleFiles)
+				}
+			},
+			provenance: map[string]string{
+				"Kuma.port":                         "env OBLIVION_KUMA_PORT",
+				"Kuma.managed_monitors":             "env OBLIVION_KUMA_MANAGED_MONITORS",
+				"Observer.Prometheus.rule_files":    "env OBLIVION_OBSERVER_PROMETHEUS_RULE_FILES",
+				"Observer.Prometheus.builtin_rules": SourceDefault,
+			},
+		},
+		{
+			name: "arrays of tables are a single key",
+			file: "[[Kuma.Monitors]]\nname = \"site\"\ntype = \"http\"\nurl = \"https://cansu.dev\"\n",
+			check: func(t *testing.T, root *Root) {
+				if len(root.Kuma.Monitors) != 1 || root.Kuma.Monitors[0].Name != "site" {
+					t.Errorf("Monitors = %+v", root.Kuma.Monitors)
+				}
+			},
+			provenance: map[string]string{"Kuma.Monitors": "file"},
+		},
+		{
+			name:    "invalid environment value",
+			file:    "",
+			environ: []string{"OBLIVION_KUMA_MANAGED_MONITORS=maybe"},
+			wantErr: true,
+		},
+		{
+			name:    "arrays of tables cannot come from the environment",
+			file:    "",
+			environ: []string{"OBLIVION_KUMA_MONITORS=site"},
+			wantErr: true,
+		},
+		{
+			name:    "malformed file",
+			file:    "[Kuma\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "oblivion.toml")
+			if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			overlay := HostOverlayPath(path, "web1")
+			if tt.overlay != "" {
+				if err := os.WriteFile(overlay, []byte(tt.overlay), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			environ := tt.environ
+			if environ == nil {
+				environ = []string{}
+			}
+			loader := Loader{Path: path, Hostname: "web1", Environ: environ}
+			var root Root
+			provenance, err := loader.Load(&root)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, &root)
+			for key, want := range tt.provenance {
+				switch want {
+				case "file":
+					want = path
+				case "overlay":
+					want = overlay
+				}
+				if provenance[key] != want {
+					t.Errorf("provenance[%s] = %q, want %q", key, provenance[key], want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoaderLoadMissingFile(t *testing.T) {
+	loader := Loader{Path: filepath.Join(t.TempDir(), "missing.toml"), Environ: []string{}}
+	var root Root
+	if _, err := loader.Load(&root); err == nil {
+		t.Fatal("expected an error for a missing base file")
+	}
+}
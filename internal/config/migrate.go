@@ -0,0 +1,144 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// CurrentVersion is the config schema version written by this build, bump it when adding a migration.
+const CurrentVersion = 2
+
+// Migration upgrades a config document from version From to From+1.
+// Migrations only edit the keys they rename, move or remove. Keys missing from the document are
+// filled with their defaults by the Loader, they are never written into the file.
+type Migration struct {
+	From        int
+	Description string
+	Apply       func(doc *document) error
+}
+
+var migrations = []Migration{
+	{
+		From:        0,
+		Description: "move top-level [Backend] under [Playground.Backend] and add the version key",
+		Apply:       migrateV0,
+	},
+	{
+		From:        1,
+		Description: "drop [Observer.Binds] pointing at the old default checkout, the configuration is embedded now",
+		Apply:       migrateV1,
+	},
+}
+
+// MigrationResult is the outcome of Migrate, Contents is the upgraded file and Original the input.
+type MigrationResult struct {
+	From     int
+	To       int
+	Applied  []Migration
+	Original []byte
+	Contents []byte
+	// keys of the migrated file that have no place in the schema, they are kept as they are
+	Unknown []string
+}
+
+// FileVersion returns the version key of a raw config file, files written before versioning have none and are version 0.
+func FileVersion(contents []byte) (int, error) {
+	var doc struct {
+		Version int `toml:"version"`
+	}
+	if err := toml.Unmarshal(contents, &doc); err != nil {
+		return 0, fmt.Errorf("failed to read config version: %w", err)
+	}
+	return doc.Version, nil
+}
+
+// Migrate runs every migration newer than the file's version on its lines, comments and keys the
+// migrations do not touch are left alone. Files already at CurrentVersion are returned unchanged with no migrations applied.
+func Migrate(contents []byte) (*MigrationResult, error) {
+	from, err := FileVersion(contents)
+	if err != nil {
+		return nil, err
+	}
+	result := &MigrationResult{From: from, To: from, Original: contents, Contents: contents}
+	if from >= CurrentVersion {
+		return result, nil
+	}
+	doc := parseDocument(contents)
+	for _, m := range migrations {
+		if m.From < result.To {
+			continue
+		}
+		if m.From != result.To {
+			return nil, fmt.Errorf("no migration from config version %d", result.To)
+		}
+		if err := m.Apply(doc); err != nil {
+			return nil, fmt.Errorf("migration from version %d failed: %w", m.From, err)
+		}
+		result.Applied = append(result.Applied, m)
+		result.To = m.From + 1
+	}
+	doc.setVersion(result.To)
+	result.Contents = doc.bytes()
+
+	var raw map[string]any
+	if err := toml.Unmarshal(result.Contents, &raw); err != nil {
+		return nil, fmt.Errorf("migrated config is not valid TOML, migrate it by hand: %w", err)
+	}
+	var root Root
+	if err := toml.Unmarshal(result.Contents, &root); err != nil {
+		return nil, fmt.Errorf("migrated config does not match the schema: %w", err)
+	}
+	known := knownKeys()
+	flattenKeys(raw, "", func(key string) {
+		if !known.has(key) {
+			result.Unknown = append(result.Unknown, key)
+		}
+	})
+	sort.Strings(result.Unknown)
+	return result, nil
+}
+
+func migrateV0(doc *document) error {
+	// the example config shipped [Backend] as a top-level table, so it was never read
+	return doc.renameTable("Backend", "Playground.Backend")
+}
+
+func migrateV1(doc *document) error {
+	const oldDefaultBindPrefix = "/Users/canercetin/Git/oblivion/cmd/config/"
+	doc.deleteKeys(func(key string, value any) bool {
+		path, ok := value.(string)
+		return strings.HasPrefix(key, "Observer.Binds.") && ok && strings.HasPrefix(path, oldDefaultBindPrefix)
+	})
+	return nil
+}
+
+// keySet holds every key the schema knows, keys inside arrays of tables are stored as Kuma.Monitors[].name
+type keySet map[string]struct{}
+
+var arrayIndex = regexp.MustCompile(`\[[0-9]+\]`)
+
+// has reports whether the schema knows key, array indexes in key are ignored
+func (k keySet) has(key string) bool {
+	_, ok := k[arrayIndex.ReplaceAllString(key, "[]")]
+	return ok
+}
+
+func knownKeys() keySet {
+	known := make(keySet)
+	var walk func(v reflect.Value, path []string)
+	walk = func(v reflect.Value, path []string) {
+		walkLeaves(v, path, func(key string, field reflect.Value) {
+			known[key] = struct{}{}
+			if field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Struct {
+				walk(reflect.New(field.Type().Elem()).Elem(), []string{key + "[]"})
+			}
+		})
+	}
+	walk(reflect.ValueOf(&Root{}).Elem(), nil)
+	return known
+}
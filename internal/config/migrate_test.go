@@ -0,0 +1,235 @@
+package config
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+func TestMigrate(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		from    int
+		applied int
+		unknown []string
+		// exact migrated file, checked when set
+		want    string
+		wantErr bool
+		check   func(t *testing.T, root *Root)
+	}{
+		{
+			name: "unversioned file moves [Backend] under [Playground]",
+			input: `[Backend]
+port = "7000"
+container_name = "backend"
+
+[Kuma]
+port = "3002"
+`,
+			from:    0,
+			applied: 2,
+			check: func(t *testing.T, root *Root) {
+				if root.Playground.Backend.Port != "7000" || root.Playground.Backend.ContainerName != "backend" {
+					t.Errorf("Playground.Backend = %+v", root.Playground.Backend)
+				}
+				if root.Kuma.Port != "3002" {
+					t.Errorf("Kuma.port = %q", root.Kuma.Port)
+				}
+			},
+		},
+		{
+			name: "only the moved keys change, comments and layout stay",
+			input: `# deployment of web1
+[Backend] # the playground
+port = "7000" # exposed
+  [Backend.Extra]
+  a = 1
+
+[Kuma]
+port = "3002"
+`,
+			from:    0,
+			applied: 2,
+			unknown: []string{"Playground.Backend.Extra.a"},
+			want: `version = 2
+
+# deployment of web1
+[Playground.Backend] # the playground
+port = "7000" # exposed
+  [Playground.Backend.Extra]
+  a = 1
+
+[Kuma]
+port = "3002"
+`,
+		},
+		{
+			name:    "inline [Backend] table",
+			input:   "Backend = { port = \"7000\" }\n",
+			from:    0,
+			applied: 2,
+			want:    "version = 2\n\nPlayground.Backend = { port = \"7000\" }\n",
+		},
+		{
+			name: "[Backend] and [Playground.Backend] have to be merged by hand",
+			input: `[Backend]
+port = "7000"
+
+[Playground.Backend]
+port = "7001"
+`,
+			wantErr: true,
+		},
+		{
+			name: "binds into the old checkout are dropped",
+			input: `version = 1
+
+[Observer.Binds]
+prometheus = "/Users/canercetin/Git/oblivion/cmd/config/prometheus"
+grafana = "/srv/grafana"
+`,
+			from:    1,
+			applied: 1,
+			want: `version = 2
+
+[Observer.Binds]
+grafana = "/srv/grafana"
+`,
+		},
+		{
+			name: "multi-line values are not mistaken for tables",
+			input: `version = 1
+
+[Observer.Prometheus]
+rule_files = [
+  "/srv/rules.yml", # [Observer.Binds]
+]
+
+[Observer.Binds]
+loki = "/Users/canercetin/Git/oblivion/cmd/config/loki"
+`,
+			from:    1,
+			applied: 1,
+			want: `version = 2
+
+[Observer.Prometheus]
+rule_files = [
+  "/srv/rules.yml", # [Observer.Binds]
+]
+
+[Observer.Binds]
+`,
+		},
+		{
+			name: "unknown keys are kept and reported",
+			input: `[Kuma]
+prot = "3002"
+
+[Legacy]
+enabled = true
+`,
+			from:    0,
+			applied: 2,
+			unknown: []string{"Kuma.prot", "Legacy.enabled"},
+			want: `version = 2
+
+[Kuma]
+prot = "3002"
+
+[Legacy]
+enabled = true
+`,
+		},
+		{
+			name: "unknown keys inside arrays of tables",
+			input: `[[Kuma.Monitors]]
+name = "site"
+typ = "http"
+`,
+			from:    0,
+			applied: 2,
+			unknown: []string{"Kuma.Monitors[0].typ"},
+			want: `version = 2
+
+[[Kuma.Monitors]]
+name = "site"
+typ = "http"
+`,
+		},
+		{
+			name:  "current version is left alone",
+			input: "version = 2\n\n[Kuma]\nport = \"3002\"\n",
+			from:  CurrentVersion,
+		},
+		{
+			name:    "malformed file",
+			input:   "[Kuma\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Migrate([]byte(tt.input))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.From != tt.from || len(result.Applied) != tt.applied {
+				t.Fatalf("migrated from %d with %d migration(s), want %d and %d", result.From, len(result.Applied), tt.from, tt.applied)
+			}
+			if !slices.Equal(result.Unknown, tt.unknown) {
+				t.Errorf("unknown %v, want %v", result.Unknown, tt.unknown)
+			}
+			if tt.applied == 0 {
+				if string(result.Contents) != tt.input {
+					t.Errorf("contents changed without a migration:\n%s", result.Contents)
+				}
+				return
+			}
+			if result.To != CurrentVersion {
+				t.Errorf("migrated to %d, want %d", result.To, CurrentVersion)
+			}
+			version, err := FileVersion(result.Contents)
+			if err != nil || version != CurrentVersion {
+				t.Errorf("FileVersion of the result = %d, %v", version, err)
+			}
+			if string(result.Original) != tt.input {
+				t.Errorf("original was not kept")
+			}
+			if tt.want != "" && string(result.Contents) != tt.want {
+				t.Errorf("migrated file:\n%s\nwant:\n%s", result.Contents, tt.want)
+			}
+			var root Root
+			root.SetDefaults()
+			if err := toml.Unmarshal(result.Contents, &root); err != nil {
+				t.Fatal(err)
+			}
+			if tt.check != nil {
+				tt.check(t, &root)
+			}
+			// the result is migrated already
+			again, err := Migrate(result.Contents)
+			if err != nil || len(again.Applied) != 0 {
+				t.Errorf("migrating the result again applied %d migration(s), %v", len(again.Applied), err)
+			}
+		})
+	}
+}
+
+func TestMigrationsFormAChain(t *testing.T) {
+	if len(migrations) != CurrentVersion {
+		t.Fatalf("%d migrations for version %d", len(migrations), CurrentVersion)
+	}
+	for i, m := range migrations {
+		if m.From != i {
+			t.Errorf("migration %d starts at version %d", i, m.From)
+		}
+	}
+}
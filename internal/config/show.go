@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// WriteAnnotated writes root as TOML with a trailing comment on every key naming the layer it came from.
+func (c *Root) WriteAnnotated(w io.Writer, provenance Provenance) error {
+	var b strings.Builder
+	writeTable(&b, reflect.ValueOf(c).Elem(), nil, provenance)
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	return nil
+}
+
+func writeTable(b *strings.Builder, v reflect.Value, path []string, provenance Provenance) {
+	if len(path) > 0 {
+		fmt.Fprintf(b, "\n[%s]\n", strings.Join(path, "."))
+	}
+	writeFields(b, v, path, provenance, true)
+}
+
+// writeFields writes the keys of v, then its tables, then its arrays of tables, TOML needs every key of a table
+// before its first sub-table. Elements of arrays of tables have no provenance of their own, only annotated tables
+// get a comment per key. Empty arrays of tables are written inline, leaving them out would bring the defaults back.
+func writeFields(b *strings.Builder, v reflect.Value, path []string, provenance Provenance, annotate bool) {
+	t := v.Type()
+	var tables, arrays []int
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		name := tomlName(sf)
+		if !sf.IsExported() || name == "-" {
+			continue
+		}
+		field := v.Field(i)
+		key := strings.Join(append(append([]string{}, path...), name), ".")
+		switch {
+		case field.Kind() == reflect.Struct:
+			tables = append(tables, i)
+			continue
+		case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Struct:
+			if field.Len() > 0 {
+				arrays = append(arrays, i)
+				continue
+			}
+			fmt.Fprintf(b, "%s = []", name)
+		default:
+			fmt.Fprintf(b, "%s = %s", name, tomlValue(field))
+		}
+		if annotate {
+			fmt.Fprintf(b, " # %s", provenance[key])
+		}
+		b.WriteString("\n")
+	}
+	for _, i := range tables {
+		tablePath := append(append([]string{}, path...), tomlName(t.Field(i)))
+		fmt.Fprintf(b, "\n[%s]\n", strings.Join(tablePath, "."))
+		writeFields(b, v.Field(i), tablePath, provenance, annotate)
+	}
+	for _, i := range arrays {
+		arrayPath := append(append([]string{}, path...), tomlName(t.Field(i)))
+		key := strings.Join(arrayPath, ".")
+		field := v.Field(i)
+		for j := range field.Len() {
+			if annotate {
+				fmt.Fprintf(b, "\n[[%s]] # %s\n", key, provenance[key])
+			} else {
+				fmt.Fprintf(b, "\n[[%s]]\n", key)
+			}
+			writeFields(b, field.Index(j), arrayPath, provenance, false)
+		}
+	}
+}
+
+func tomlValue(v reflect.Value) string {
+	// go-toml cannot marshal a bare value, wrap it in a single key document and strip the key
+	out, err := toml.Marshal(map[string]any{"v": v.Interface()})
+	if err != nil {
+		return fmt.Sprintf("%q", fmt.Sprint(v.Interface()))
+	}
+	return strings.TrimSpace(strings.TrimPrefix(string(out), "v = "))
+}
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+func TestWriteAnnotatedRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(root *Root)
+	}{
+		{
+			name:   "defaults",
+			modify: func(root *Root) {},
+		},
+		{
+			name: "nested arrays of tables",
+			modify: func(root *Root) {
+				root.Static.Nginx.Protected = []StaticProtectedConfig{
+					{Path: "/family/", Realm: "family", Users: []StaticProtectedUserConfig{
+						{Name: "mom", PasswordRef: "/Static/Family/mom"},
+						{Name: "dad", PasswordRef: "/Static/Family/dad"},
+					}},
+					{Path: "/work/", Users: []StaticProtectedUserConfig{{Name: "me", PasswordRef: "/Static/Work/me"}}},
+				}
+				root.Kuma.Monitors = []KumaMonitorConfig{{Name: "site", Type: "http", URL: "https://cansu.dev"}}
+			},
+		},
+		{
+			name: "emptied default array of tables",
+			modify: func(root *Root) {
+				root.Static.Nginx.Cache = []StaticCacheConfig{}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var root Root
+			root.SetDefaults()
+			tt.modify(&root)
+			var out bytes.Buffer
+			if err := root.WriteAnnotated(&out, Provenance{}); err != nil {
+				t.Fatal(err)
+			}
+			// what config show --effective prints has to load into the same configuration
+			var back Root
+			back.SetDefaults()
+			if err := toml.Unmarshal(out.Bytes(), &back); err != nil {
+				t.Fatalf("output is not valid TOML: %v\n%s", err, out.String())
+			}
+			want, err := toml.Marshal(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := toml.Marshal(back)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(want) != string(got) {
+				t.Errorf("round trip changed the config\nwant:\n%s\ngot:\n%s\nwritten:\n%s", want, got, out.String())
+			}
+		})
+	}
+}
+
+func TestWriteAnnotatedProvenance(t *testing.T) {
+	var root Root
+	root.SetDefaults()
+	root.Kuma.Monitors = []KumaMonitorConfig{{Name: "site", Type: "http", URL: "https://cansu.dev"}}
+	provenance := Provenance{"Kuma.port": "/etc/oblivion.toml", "Kuma.Monitors": "/etc/oblivion.toml"}
+	var out bytes.Buffer
+	if err := root.WriteAnnotated(&out, provenance); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"port = '3001' # /etc/oblivion.toml\n",
+		"[[Kuma.Monitors]] # /etc/oblivion.toml\n",
+		// keys of array elements carry no comment
+		"name = 'site'\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output has no %q:\n%s", want, out.String())
+		}
+	}
+}
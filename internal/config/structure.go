@@ -1,6 +1,8 @@
 package config
 
 type Root struct {
+	// schema version of the file, see CurrentVersion and Migrate
+	Version    int               `toml:"version"`
 	Docker     DockerConfig      `toml:"Docker"`
 	Networks   NetworkConfig     `toml:"Networks"`
 	Postgres   PostgresConfig    `toml:"Postgres"`
@@ -21,10 +23,23 @@ type NetworkConfig struct {
 	UptimeNetworkName   string `toml:"uptime_network_name"`
 	GrafanaNetworkName  string `toml:"grafana_network_name"`
 	LokiNetworkName     string `toml:"loki_network_name"`
+	// the static proxy reaches the static containers over it
+	StaticNetworkName string `toml:"static_network_name"`
 }
 
 type OnepasswordConfig struct {
-	VaultName string `toml:"vault_name"`
+	VaultName string            `toml:"vault_name"`
+	Cache     SecretCacheConfig `toml:"Cache"`
+}
+
+type SecretCacheConfig struct {
+	Enabled bool   `toml:"enabled"`
+	TTL     string `toml:"ttl"`
+	// defaults to the user cache directory if empty
+	Path string `toml:"path"`
+	// "machine" or "passphrase", passphrase is read from OBLIVION_SECRET_CACHE_PASSPHRASE or prompted.
+	// machine only obfuscates, the key is derived from values anyone on the machine can read
+	KeySource string `toml:"key_source"`
 }
 
 type PostgresConfig struct {
@@ -42,11 +57,89 @@ type PostgresInstanceConfig struct {
 }
 
 type StaticConfig struct {
-	UploaderUser  string `toml:"uploader_user"`
-	StaticPath    string `toml:"static_path"`
-	Port          string `toml:"port"`
-	ImageName     string `toml:"image_name"`
+	UploaderUser  string            `toml:"uploader_user"`
+	StaticPath    string            `toml:"static_path"`
+	Port          string            `toml:"port"`
+	ImageName     string            `toml:"image_name"`
+	ContainerName string            `toml:"container_name"`
+	Nginx         StaticNginxConfig `toml:"Nginx"`
+	FTP           StaticFTPConfig   `toml:"FTP"`
+	Proxy         StaticProxyConfig `toml:"Proxy"`
+}
+
+// StaticProxyConfig is the nginx in front of the static server. It owns the host port, so updates swap the static
+// container behind it without refusing connections.
+type StaticProxyConfig struct {
+	ContainerName string `toml:"container_name"`
+	Image         string `toml:"image"`
+}
+
+// StaticNginxConfig is rendered into the nginx.conf of the static server on static up
+type StaticNginxConfig struct {
+	ServerNames []string `toml:"server_names"`
+	// url prefixes that list their directories, everywhere else only files are served
+	ListingPaths []string `toml:"listing_paths"`
+	// themed listings with the fancyindex module, nginx's plain autoindex if disabled
+	Fancyindex           bool   `toml:"fancyindex"`
+	FancyindexExactSize  bool   `toml:"fancyindex_exact_size"`
+	FancyindexShowPath   bool   `toml:"fancyindex_show_path"`
+	FancyindexTimeFormat string `toml:"fancyindex_time_format"`
+	Gzip                 bool   `toml:"gzip"`
+	// needs an image built with ngx_brotli
+	Brotli     bool                    `toml:"brotli"`
+	Cache      []StaticCacheConfig     `toml:"Cache"`
+	Protected  []StaticProtectedConfig `toml:"Protected"`
+	SecureLink StaticSecureLinkConfig  `toml:"SecureLink"`
+}
+
+// StaticSecureLinkConfig serves a url prefix only through expiring links signed by static link
+type StaticSecureLinkConfig struct {
+	// 1Password reference without the vault prefix, links are disabled while empty
+	SecretRef string `toml:"secret_ref"`
+	Path      string `toml:"path"`
+	// scheme and host links start with, defaults to https:// and the first server name
+	BaseURL string `toml:"base_url"`
+}
+
+// StaticCacheConfig sets the Cache-Control header of files with the given extensions
+type StaticCacheConfig struct {
+	// without the leading dot, matched case-insensitively
+	Extensions   []string `toml:"extensions"`
+	CacheControl string   `toml:"cache_control"`
+}
+
+// StaticProtectedConfig puts a url prefix behind basic auth
+type StaticProtectedConfig struct {
+	Path  string                      `toml:"path"`
+	Realm string                      `toml:"realm"`
+	Users []StaticProtectedUserConfig `toml:"Users"`
+}
+
+type StaticProtectedUserConfig struct {
+	Name string `toml:"name"`
+	// 1Password reference without the vault prefix
+	PasswordRef string `toml:"password_ref"`
+}
+
+// StaticFTPConfig is the sftp server uploaders use to write into the static path
+type StaticFTPConfig struct {
 	ContainerName string `toml:"container_name"`
+	Image         string `toml:"image"`
+	Port          string `toml:"port"`
+	// holds /etc/ssh so the host keys survive recreating the container
+	KeysVolume string             `toml:"keys_volume"`
+	Accounts   []FTPAccountConfig `toml:"Accounts"`
+}
+
+// FTPAccountConfig is a single upload account, every account writes files as [Static] uploader_user.
+type FTPAccountConfig struct {
+	Name string `toml:"name"`
+	// 1Password reference without the vault prefix, leave empty for key-only logins
+	PasswordRef string `toml:"password_ref"`
+	// public keys in authorized_keys format
+	AuthorizedKeys []string `toml:"authorized_keys"`
+	// relative to [Static] static_path, the account is chrooted to it. Defaults to the account name
+	Directory string `toml:"directory"`
 }
 
 type KumaConfig struct {
@@ -54,14 +147,134 @@ type KumaConfig struct {
 	ImageName     string `toml:"image_name"`
 	Port          string `toml:"port"`
 	DataVolume    string `toml:"data_volume"`
+	// kuma sync adds a tcp monitor for every postgres, redis and observer container
+	ManagedMonitors bool `toml:"managed_monitors"`
+	// daemon docker monitors ask, unix:// binds that socket into kuma and gives it full control of the host,
+	// tcp:// or http(s):// points at a socket proxy instead. Empty disables docker monitors
+	DockerDaemon string              `toml:"docker_daemon"`
+	Monitors     []KumaMonitorConfig `toml:"Monitors"`
+}
+
+// KumaMonitorConfig is a monitor kuma sync keeps in Uptime Kuma, monitors are matched by name
+type KumaMonitorConfig struct {
+	Name string `toml:"name"`
+	// http, keyword, port or docker
+	Type string `toml:"type"`
+	// http and keyword
+	URL string `toml:"url"`
+	// keyword, the response has to contain it
+	Keyword string `toml:"keyword"`
+	// port
+	Hostname string `toml:"hostname"`
+	Port     int    `toml:"port"`
+	// docker, name of a container on this host
+	Container string `toml:"container"`
+	// seconds between checks, defaults to 60
+	Interval int `toml:"interval"`
 }
 
 type ObserverConfig struct {
-	Volumes        ObserverInstanceConfig `toml:"Volumes"`
+	Volumes ObserverInstanceConfig `toml:"Volumes"`
+	// volumes the embedded configuration is rendered into, used when the matching bind is empty
+	ConfigVolumes ObserverInstanceConfig `toml:"ConfigVolumes"`
+	// optional host directories that replace the embedded configuration
 	Binds          ObserverInstanceConfig `toml:"Binds"`
 	ContainerNames ObserverInstanceConfig `toml:"ContainerNames"`
 	Ports          ObserverInstanceConfig `toml:"Ports"`
 	Images         ObserverInstanceConfig `toml:"Images"`
+	// components observer up starts when no component is named, all enabled by default
+	Enabled    ObserverComponentsConfig `toml:"Enabled"`
+	Prometheus PrometheusConfig         `toml:"Prometheus"`
+	Alerting   AlertingConfig           `toml:"Alerting"`
+	Logs       LogsConfig               `toml:"Logs"`
+	Probes     ProbesConfig             `toml:"Probes"`
+}
+
+type ObserverComponentsConfig struct {
+	Grafana      bool `toml:"grafana"`
+	Prometheus   bool `toml:"prometheus"`
+	Loki         bool `toml:"loki"`
+	Cadvisor     bool `toml:"cadvisor"`
+	NodeExporter bool `toml:"node_exporter"`
+	Alertmanager bool `toml:"alertmanager"`
+}
+
+type ProbesConfig struct {
+	// blackbox_exporter is only started when at least one target is declared
+	Targets []ProbeConfig `toml:"Targets"`
+}
+
+// ProbeConfig is a single blackbox probe target.
+type ProbeConfig struct {
+	Name string `toml:"name"`
+	// http:// and https:// are probed with GET, tcp://host:port checks the connection and tls://host:port the handshake
+	URL string `toml:"url"`
+	// http only, defaults to 200
+	ExpectedStatus int `toml:"expected_status"`
+	// alert when the certificate expires within this many days, 0 disables the alert
+	TLSExpiryDays int `toml:"tls_expiry_days"`
+}
+
+type LogsConfig struct {
+	// collector runs promtail, driver sets the loki logging driver on every container, off leaves logs in json-file
+	Shipping string `toml:"shipping"`
+}
+
+type PrometheusConfig struct {
+	ScrapeInterval     string `toml:"scrape_interval"`
+	EvaluationInterval string `toml:"evaluation_interval"`
+	// how long samples are kept, a prometheus duration like 15d or 1y
+	RetentionTime string `toml:"retention_time"`
+	// upper bound of the TSDB size like 10GB, empty means no limit. Whichever limit is hit first applies
+	RetentionSize string `toml:"retention_size"`
+	// starts prometheus with --web.enable-admin-api for observer prometheus snapshot. The admin api deletes series
+	// without asking for credentials, anything that reaches prometheus can use it
+	AdminAPI bool `toml:"admin_api"`
+	// deploys the rule pack for the services oblivion manages
+	BuiltinRules bool `toml:"builtin_rules"`
+	// host paths of additional rule files, validated with prometheus' rule parser before they are deployed
+	RuleFiles []string `toml:"rule_files"`
+	// scraped in addition to the jobs generated for the containers oblivion manages
+	ExtraJobs []ScrapeJobConfig `toml:"ExtraJobs"`
+}
+
+type ScrapeJobConfig struct {
+	Name           string   `toml:"name"`
+	Targets        []string `toml:"targets"`
+	MetricsPath    string   `toml:"metrics_path"`
+	Scheme         string   `toml:"scheme"`
+	ScrapeInterval string   `toml:"scrape_interval"`
+}
+
+type AlertingConfig struct {
+	// receiver alerts go to, defaults to the first of Receivers
+	DefaultReceiver string   `toml:"default_receiver"`
+	GroupBy         []string `toml:"group_by"`
+	GroupWait       string   `toml:"group_wait"`
+	GroupInterval   string   `toml:"group_interval"`
+	RepeatInterval  string   `toml:"repeat_interval"`
+	// host port the stand-in webhook of observer alerts test listens on
+	TestWebhookPort string                `toml:"test_webhook_port"`
+	Receivers       []AlertReceiverConfig `toml:"Receivers"`
+}
+
+// AlertReceiverConfig is a single alertmanager receiver, fields ending in _ref are 1Password references without the vault prefix.
+type AlertReceiverConfig struct {
+	Name string `toml:"name"`
+	// one of discord, slack, webhook or email
+	Type string `toml:"type"`
+	// webhook url for discord, slack and webhook receivers
+	URLRef string `toml:"url_ref"`
+	// slack only
+	Channel string `toml:"channel"`
+	// email only
+	To          string `toml:"to"`
+	From        string `toml:"from"`
+	Smarthost   string `toml:"smarthost"`
+	UsernameRef string `toml:"username_ref"`
+	PasswordRef string `toml:"password_ref"`
+
+	SendResolved bool `toml:"send_resolved"`
 }
 
 type ObserverInstanceConfig struct {
@@ -71,6 +284,14 @@ type ObserverInstanceConfig struct {
 	Alertmanager string `toml:"alertmanager"`
 	Cadvisor     string `toml:"cadvisor"`
 	Loki         string `toml:"loki"`
+	// exporters are scraped over the docker networks, their ports are only published if set
+	PostgresExporter  string `toml:"postgres_exporter"`
+	PgbouncerExporter string `toml:"pgbouncer_exporter"`
+	RedisExporter     string `toml:"redis_exporter"`
+	// log collector, only started when [Observer.Logs] shipping is collector
+	Promtail string `toml:"promtail"`
+	// only started when [[Observer.Probes.Targets]] are declared
+	BlackboxExporter string `toml:"blackbox_exporter"`
 }
 
 type DragonflyConfig struct {
@@ -89,6 +310,10 @@ type PlaygroundBackendConfig struct {
 	Repository    string `toml:"repository"`
 	ContainerName string `toml:"container_name"`
 	ImageName     string `toml:"image_name"`
+	// commit tagged images kept for rollback, the running one is always kept
+	KeepImages int `toml:"keep_images"`
+	// docker healthcheck test a deploy waits for, CMD or CMD-SHELL followed by the command
+	Healthcheck []string `toml:"healthcheck"`
 }
 
 var Config Root
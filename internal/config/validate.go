@@ -0,0 +1,582 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// prometheus accepts larger units than time.ParseDuration, e.g. 15d or 1y2w
+var (
+	prometheusDuration = regexp.MustCompile(`^([0-9]+(ms|s|m|h|d|w|y))+$`)
+	prometheusSize     = regexp.MustCompile(`^[0-9]+(B|KB|MB|GB|TB|PB|EB)$`)
+)
+
+// Problem is a single validation failure, Key is the dotted TOML path of the offending key.
+type Problem struct {
+	Key     string
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s", p.Key, p.Message)
+}
+
+// ValidationError carries every problem found in a config instead of stopping at the first one.
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Problems)+1)
+	lines = append(lines, fmt.Sprintf("%d problem(s) found in config", len(e.Problems)))
+	for _, p := range e.Problems {
+		lines = append(lines, "  "+p.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Within splits the problems into those below one of keys and the rest. A key covers itself, everything below it
+// and its array elements, Static.Nginx covers Static.Nginx.Cache[0].extensions. The config version is always covered.
+func (e *ValidationError) Within(keys []string) (within []Problem, rest []Problem) {
+	for _, p := range e.Problems {
+		covered := p.Key == "version"
+		for _, key := range keys {
+			if p.Key == key || strings.HasPrefix(p.Key, key+".") || strings.HasPrefix(p.Key, key+"[") {
+				covered = true
+				break
+			}
+		}
+		if covered {
+			within = append(within, p)
+		} else {
+			rest = append(rest, p)
+		}
+	}
+	return within, rest
+}
+
+type hostPort struct {
+	key   string
+	value string
+	// replica and exporters have no host binding by default, an empty port is fine there
+	optional bool
+}
+
+func (c *Root) hostPorts() []hostPort {
+	return []hostPort{
+		{"Postgres.Primary.port", c.Postgres.Primary.Port, false},
+		{"Postgres.Replica.port", c.Postgres.Replica.Port, true},
+		{"Postgres.Bouncer.port", c.Postgres.Bouncer.Port, false},
+		{"Static.port", c.Static.Port, false},
+		{"Static.FTP.port", c.Static.FTP.Port, false},
+		{"Kuma.port", c.Kuma.Port, false},
+		{"Observer.Ports.grafana", c.Observer.Ports.Grafana, false},
+		{"Observer.Ports.prometheus", c.Observer.Ports.Prometheus, false},
+		{"Observer.Ports.node_exporter", c.Observer.Ports.NodeExporter, false},
+		{"Observer.Ports.alertmanager", c.Observer.Ports.Alertmanager, false},
+		{"Observer.Ports.cadvisor", c.Observer.Ports.Cadvisor, false},
+		{"Observer.Ports.loki", c.Observer.Ports.Loki, false},
+		{"Observer.Ports.postgres_exporter", c.Observer.Ports.PostgresExporter, true},
+		{"Observer.Ports.pgbouncer_exporter", c.Observer.Ports.PgbouncerExporter, true},
+		{"Observer.Ports.redis_exporter", c.Observer.Ports.RedisExporter, true},
+		{"Observer.Ports.promtail", c.Observer.Ports.Promtail, true},
+		{"Observer.Ports.blackbox_exporter", c.Observer.Ports.BlackboxExporter, true},
+		{"Observer.Alerting.test_webhook_port", c.Observer.Alerting.TestWebhookPort, false},
+		{"Dragonfly.port", c.Dragonfly.Port, false},
+		{"Playground.Backend.port", c.Playground.Backend.Port, false},
+	}
+}
+
+// Validate checks the config for problems that would otherwise only surface deep inside Docker.
+// Provenance is used to find keys in config files that do not map to any field, it may be nil.
+// A nil error means the config is valid, otherwise the error is a *ValidationError.
+func (c *Root) Validate(provenance Provenance) error {
+	var problems []Problem
+	add := func(key string, format string, args ...any) {
+		problems = append(problems, Problem{Key: key, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if c.Version > CurrentVersion {
+		add("version", "config version %d is newer than this build supports (%d), upgrade oblivion", c.Version, CurrentVersion)
+	}
+
+	usedBy := make(map[int]string)
+	for _, hp := range c.hostPorts() {
+		if hp.value == "" {
+			if !hp.optional {
+				add(hp.key, "port must not be empty")
+			}
+			continue
+		}
+		port, err := strconv.Atoi(hp.value)
+		if err != nil {
+			add(hp.key, "port must be numeric, got %q", hp.value)
+			continue
+		}
+		if port < 1 || port > 65535 {
+			add(hp.key, "port must be between 1 and 65535, got %d", port)
+			continue
+		}
+		if other, ok := usedBy[port]; ok {
+			add(hp.key, "host port %d is already used by %s", port, other)
+			continue
+		}
+		usedBy[port] = hp.key
+	}
+
+	required := map[string]string{
+		"Networks.database_network_name": c.Networks.DatabaseNetworkName,
+		"Networks.uptime_network_name":   c.Networks.UptimeNetworkName,
+		"Networks.grafana_network_name":  c.Networks.GrafanaNetworkName,
+		"Networks.loki_network_name":     c.Networks.LokiNetworkName,
+		"Networks.static_network_name":   c.Networks.StaticNetworkName,
+		"Static.Proxy.container_name":    c.Static.Proxy.ContainerName,
+		"Static.Proxy.image":             c.Static.Proxy.Image,
+		"Onepass.vault_name":             c.Onepass.VaultName,
+		"Static.uploader_user":           c.Static.UploaderUser,
+	}
+	for _, key := range sortedKeys(required) {
+		if required[key] == "" {
+			add(key, "must not be empty")
+		}
+	}
+
+	if c.Static.StaticPath == "" {
+		add("Static.static_path", "must not be empty")
+	} else if !filepath.IsAbs(c.Static.StaticPath) {
+		add("Static.static_path", "must be an absolute path, got %q", c.Static.StaticPath)
+	}
+
+	binds := map[string]string{
+		"Observer.Binds.prometheus":        c.Observer.Binds.Prometheus,
+		"Observer.Binds.grafana":           c.Observer.Binds.Grafana,
+		"Observer.Binds.alertmanager":      c.Observer.Binds.Alertmanager,
+		"Observer.Binds.loki":              c.Observer.Binds.Loki,
+		"Observer.Binds.promtail":          c.Observer.Binds.Promtail,
+		"Observer.Binds.blackbox_exporter": c.Observer.Binds.BlackboxExporter,
+	}
+	for _, key := range sortedKeys(binds) {
+		if binds[key] == "" {
+			continue
+		}
+		if msg := checkDirectory(binds[key]); msg != "" {
+			add(key, "%s", msg)
+		}
+	}
+	configVolumes := map[string][2]string{
+		"Observer.ConfigVolumes.prometheus":        {c.Observer.ConfigVolumes.Prometheus, c.Observer.Binds.Prometheus},
+		"Observer.ConfigVolumes.grafana":           {c.Observer.ConfigVolumes.Grafana, c.Observer.Binds.Grafana},
+		"Observer.ConfigVolumes.alertmanager":      {c.Observer.ConfigVolumes.Alertmanager, c.Observer.Binds.Alertmanager},
+		"Observer.ConfigVolumes.loki":              {c.Observer.ConfigVolumes.Loki, c.Observer.Binds.Loki},
+		"Observer.ConfigVolumes.promtail":          {c.Observer.ConfigVolumes.Promtail, c.Observer.Binds.Promtail},
+		"Observer.ConfigVolumes.blackbox_exporter": {c.Observer.ConfigVolumes.BlackboxExporter, c.Observer.Binds.BlackboxExporter},
+	}
+	for _, key := range sortedKeys(configVolumes) {
+		if volume, bind := configVolumes[key][0], configVolumes[key][1]; volume == "" && bind == "" {
+			add(key, "must not be empty unless the matching Observer.Binds key is set")
+		}
+	}
+
+	switch c.Observer.Logs.Shipping {
+	case "collector", "driver":
+		if !c.Observer.Enabled.Loki {
+			add("Observer.Logs.shipping", "must be \"off\" when Observer.Enabled.loki is false, got %q", c.Observer.Logs.Shipping)
+		}
+	case "off":
+	default:
+		add("Observer.Logs.shipping", "must be \"collector\", \"driver\" or \"off\", got %q", c.Observer.Logs.Shipping)
+	}
+
+	if _, err := time.ParseDuration(c.Onepass.Cache.TTL); err != nil {
+		add("Onepass.Cache.ttl", "must be a duration like 12h or 30m, got %q", c.Onepass.Cache.TTL)
+	}
+	switch c.Onepass.Cache.KeySource {
+	case "", "machine", "passphrase":
+	default:
+		add("Onepass.Cache.key_source", "must be \"machine\" or \"passphrase\", got %q", c.Onepass.Cache.KeySource)
+	}
+
+	intervals := map[string]string{
+		"Observer.Prometheus.scrape_interval":     c.Observer.Prometheus.ScrapeInterval,
+		"Observer.Prometheus.evaluation_interval": c.Observer.Prometheus.EvaluationInterval,
+	}
+	for _, key := range sortedKeys(intervals) {
+		if _, err := time.ParseDuration(intervals[key]); err != nil {
+			add(key, "must be a duration like 15s, got %q", intervals[key])
+		}
+	}
+	if !prometheusDuration.MatchString(c.Observer.Prometheus.RetentionTime) {
+		add("Observer.Prometheus.retention_time", "must be a duration like 15d or 1y, got %q", c.Observer.Prometheus.RetentionTime)
+	}
+	if c.Observer.Prometheus.RetentionSize != "" && !prometheusSize.MatchString(c.Observer.Prometheus.RetentionSize) {
+		add("Observer.Prometheus.retention_size", "must be a size like 512MB or 10GB, got %q", c.Observer.Prometheus.RetentionSize)
+	}
+	ruleNames := make(map[string]string)
+	for i, path := range c.Observer.Prometheus.RuleFiles {
+		key := fmt.Sprintf("Observer.Prometheus.rule_files[%d]", i)
+		if msg := checkFile(path); msg != "" {
+			add(key, "%s", msg)
+			continue
+		}
+		// rule files are deployed flat, by their base name
+		if other, ok := ruleNames[filepath.Base(path)]; ok {
+			add(key, "has the same file name as %s", other)
+		}
+		ruleNames[filepath.Base(path)] = path
+	}
+	jobNames := make(map[string]bool)
+	for i, job := range c.Observer.Prometheus.ExtraJobs {
+		key := fmt.Sprintf("Observer.Prometheus.ExtraJobs[%d]", i)
+		if job.Name == "" {
+			add(key+".name", "must not be empty")
+		} else if jobNames[job.Name] {
+			add(key+".name", "job %q is defined twice", job.Name)
+		}
+		jobNames[job.Name] = true
+		if len(job.Targets) == 0 {
+			add(key+".targets", "must list at least one host:port target")
+		}
+		if job.ScrapeInterval != "" {
+			if _, err := time.ParseDuration(job.ScrapeInterval); err != nil {
+				add(key+".scrape_interval", "must be a duration like 15s, got %q", job.ScrapeInterval)
+			}
+		}
+	}
+	if c.Playground.Backend.KeepImages < 1 {
+		add("Playground.Backend.keep_images", "must be at least 1, got %d", c.Playground.Backend.KeepImages)
+	}
+	if hc := c.Playground.Backend.Healthcheck; len(hc) < 2 || (hc[0] != "CMD" && hc[0] != "CMD-SHELL") {
+		add("Playground.Backend.healthcheck", `must be "CMD" or "CMD-SHELL" followed by the command, got %q`, hc)
+	}
+
+	problems = append(problems, c.Observer.Alerting.validate()...)
+	problems = append(problems, c.Observer.Probes.validate()...)
+	problems = append(problems, c.Static.Nginx.validate()...)
+	problems = append(problems, c.Kuma.validate()...)
+	problems = append(problems, c.Static.FTP.validate()...)
+	problems = append(problems, unknownKeys(provenance)...)
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: problems}
+}
+
+func (a *AlertingConfig) validate() []Problem {
+	var problems []Problem
+	add := func(key string, format string, args ...any) {
+		problems = append(problems, Problem{Key: key, Message: fmt.Sprintf(format, args...)})
+	}
+	durations := map[string]string{
+		"Observer.Alerting.group_wait":      a.GroupWait,
+		"Observer.Alerting.group_interval":  a.GroupInterval,
+		"Observer.Alerting.repeat_interval": a.RepeatInterval,
+	}
+	for _, key := range sortedKeys(durations) {
+		if _, err := time.ParseDuration(durations[key]); err != nil {
+			add(key, "must be a duration like 5m, got %q", durations[key])
+		}
+	}
+	names := make(map[string]bool)
+	for i, r := range a.Receivers {
+		key := fmt.Sprintf("Observer.Alerting.Receivers[%d]", i)
+		if r.Name == "" {
+			add(key+".name", "must not be empty")
+		} else if names[r.Name] {
+			add(key+".name", "receiver %q is defined twice", r.Name)
+		}
+		names[r.Name] = true
+		var required map[string]string
+		switch r.Type {
+		case "discord", "slack", "webhook":
+			required = map[string]string{"url_ref": r.URLRef}
+		case "email":
+			required = map[string]string{"to": r.To, "from": r.From, "smarthost": r.Smarthost}
+		default:
+			add(key+".type", "must be one of discord, slack, webhook or email, got %q", r.Type)
+		}
+		for _, field := range sortedKeys(required) {
+			if required[field] == "" {
+				add(key+"."+field, "must not be empty for %s receivers", r.Type)
+			}
+		}
+		if r.Type == "email" && (r.UsernameRef == "") != (r.PasswordRef == "") {
+			add(key+".password_ref", "username_ref and password_ref must be set together")
+		}
+	}
+	if a.DefaultReceiver != "" && !names[a.DefaultReceiver] {
+		add("Observer.Alerting.default_receiver", "no receiver named %q", a.DefaultReceiver)
+	}
+	return problems
+}
+
+func (p *ProbesConfig) validate() []Problem {
+	var problems []Problem
+	add := func(key string, format string, args ...any) {
+		problems = append(problems, Problem{Key: key, Message: fmt.Sprintf(format, args...)})
+	}
+	names := make(map[string]bool)
+	for i, probe := range p.Targets {
+		key := fmt.Sprintf("Observer.Probes.Targets[%d]", i)
+		if probe.Name == "" {
+			add(key+".name", "must not be empty")
+		} else if names[probe.Name] {
+			add(key+".name", "probe %q is defined twice", probe.Name)
+		}
+		names[probe.Name] = true
+		target, err := url.Parse(probe.URL)
+		if err != nil || target.Host == "" {
+			add(key+".url", "must be a url like https://cansu.dev or tcp://host:port, got %q", probe.URL)
+			continue
+		}
+		switch target.Scheme {
+		case "http", "https":
+		case "tcp", "tls":
+			if target.Port() == "" {
+				add(key+".url", "%s probes need a port, got %q", target.Scheme, probe.URL)
+			}
+			if probe.ExpectedStatus != 0 {
+				add(key+".expected_status", "only applies to http and https probes")
+			}
+		default:
+			add(key+".url", "scheme must be http, https, tcp or tls, got %q", target.Scheme)
+		}
+		if probe.ExpectedStatus != 0 && (probe.ExpectedStatus < 100 || probe.ExpectedStatus > 599) {
+			add(key+".expected_status", "must be an http status code, got %d", probe.ExpectedStatus)
+		}
+		if probe.TLSExpiryDays < 0 {
+			add(key+".tls_expiry_days", "must not be negative, got %d", probe.TLSExpiryDays)
+		} else if probe.TLSExpiryDays > 0 && target.Scheme != "https" && target.Scheme != "tls" {
+			add(key+".tls_expiry_days", "only applies to https and tls probes")
+		}
+	}
+	return problems
+}
+
+// names and values end up in nginx.conf unquoted or inside double quotes
+var (
+	serverName    = regexp.MustCompile(`^(\*\.)?[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)*$`)
+	fileExtension = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+	urlPrefix     = regexp.MustCompile(`^/([A-Za-z0-9._~-]+/)*$`)
+)
+
+func (n *StaticNginxConfig) validate() []Problem {
+	var problems []Problem
+	add := func(key string, format string, args ...any) {
+		problems = append(problems, Problem{Key: key, Message: fmt.Sprintf(format, args...)})
+	}
+	if len(n.ServerNames) == 0 {
+		add("Static.Nginx.server_names", "must not be empty")
+	}
+	for i, name := range n.ServerNames {
+		if name != "_" && !serverName.MatchString(name) {
+			add(fmt.Sprintf("Static.Nginx.server_names[%d]", i), "must be a host name, a *.wildcard or _, got %q", name)
+		}
+	}
+	for i, prefix := range n.ListingPaths {
+		if !urlPrefix.MatchString(prefix) {
+			add(fmt.Sprintf("Static.Nginx.listing_paths[%d]", i), "must start and end with /, got %q", prefix)
+		}
+	}
+	if strings.ContainsAny(n.FancyindexTimeFormat, "\"\\") {
+		add("Static.Nginx.fancyindex_time_format", "must not contain quotes or backslashes")
+	}
+	for i, cache := range n.Cache {
+		key := fmt.Sprintf("Static.Nginx.Cache[%d]", i)
+		if len(cache.Extensions) == 0 {
+			add(key+".extensions", "must not be empty")
+		}
+		for _, ext := range cache.Extensions {
+			if !fileExtension.MatchString(ext) {
+				add(key+".extensions", "must be letters and digits without the leading dot, got %q", ext)
+			}
+		}
+		if cache.CacheControl == "" || strings.ContainsAny(cache.CacheControl, "\"\\") {
+			add(key+".cache_control", "must not be empty or contain quotes or backslashes")
+		}
+	}
+	if n.SecureLink.SecretRef != "" {
+		if !urlPrefix.MatchString(n.SecureLink.Path) || n.SecureLink.Path == "/" {
+			add("Static.Nginx.SecureLink.path", "must start and end with / and not be /, got %q", n.SecureLink.Path)
+		}
+		if n.SecureLink.BaseURL != "" {
+			if u, err := url.Parse(n.SecureLink.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				add("Static.Nginx.SecureLink.base_url", "must be an http(s) url, got %q", n.SecureLink.BaseURL)
+			}
+		}
+	}
+	paths := make(map[string]bool)
+	for i, protected := range n.Protected {
+		key := fmt.Sprintf("Static.Nginx.Protected[%d]", i)
+		if !urlPrefix.MatchString(protected.Path) {
+			add(key+".path", "must start and end with /, got %q", protected.Path)
+		} else if paths[protected.Path] {
+			add(key+".path", "%s is protected twice", protected.Path)
+		}
+		paths[protected.Path] = true
+		if strings.ContainsAny(protected.Realm, "\"\\") {
+			add(key+".realm", "must not contain quotes or backslashes")
+		}
+		if len(protected.Users) == 0 {
+			add(key+".Users", "must not be empty")
+		}
+		for j, user := range protected.Users {
+			if user.Name == "" || strings.ContainsAny(user.Name, ":\n") {
+				add(fmt.Sprintf("%s.Users[%d].name", key, j), "must not be empty or contain ':'")
+			}
+			if user.PasswordRef == "" {
+				add(fmt.Sprintf("%s.Users[%d].password_ref", key, j), "must not be empty")
+			}
+		}
+	}
+	return problems
+}
+
+// kuma rejects shorter intervals
+const kumaMinInterval = 20
+
+func (k *KumaConfig) validate() []Problem {
+	var problems []Problem
+	add := func(key string, format string, args ...any) {
+		problems = append(problems, Problem{Key: key, Message: fmt.Sprintf(format, args...)})
+	}
+	if k.DockerDaemon != "" {
+		if daemon, err := url.Parse(k.DockerDaemon); err != nil || !slices.Contains([]string{"unix", "tcp", "http", "https"}, daemon.Scheme) {
+			add("Kuma.docker_daemon", "must be a unix://, tcp:// or http(s):// url, got %q", k.DockerDaemon)
+		}
+	}
+	names := make(map[string]bool)
+	for i, monitor := range k.Monitors {
+		key := fmt.Sprintf("Kuma.Monitors[%d]", i)
+		if monitor.Name == "" {
+			add(key+".name", "must not be empty")
+		} else if names[monitor.Name] {
+			add(key+".name", "monitor %q is defined twice", monitor.Name)
+		}
+		names[monitor.Name] = true
+		switch monitor.Type {
+		case "http", "keyword":
+			if target, err := url.Parse(monitor.URL); err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+				add(key+".url", "%s monitors need an http(s) url, got %q", monitor.Type, monitor.URL)
+			}
+			if monitor.Type == "keyword" && monitor.Keyword == "" {
+				add(key+".keyword", "keyword monitors need a keyword")
+			}
+		case "port":
+			if monitor.Hostname == "" {
+				add(key+".hostname", "port monitors need a hostname")
+			}
+			if monitor.Port < 1 || monitor.Port > 65535 {
+				add(key+".port", "port must be between 1 and 65535, got %d", monitor.Port)
+			}
+		case "docker":
+			if monitor.Container == "" {
+				add(key+".container", "docker monitors need a container")
+			}
+			if k.DockerDaemon == "" {
+				add(key+".type", "docker monitors need [Kuma] docker_daemon, a socket proxy or the docker socket")
+			}
+		default:
+			add(key+".type", "must be http, keyword, port or docker, got %q", monitor.Type)
+		}
+		if monitor.Interval != 0 && monitor.Interval < kumaMinInterval {
+			add(key+".interval", "must be at least %d seconds, got %d", kumaMinInterval, monitor.Interval)
+		}
+	}
+	return problems
+}
+
+// same rule useradd applies by default
+var accountName = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
+func (f *StaticFTPConfig) validate() []Problem {
+	var problems []Problem
+	add := func(key string, format string, args ...any) {
+		problems = append(problems, Problem{Key: key, Message: fmt.Sprintf(format, args...)})
+	}
+	if len(f.Accounts) > 0 && f.KeysVolume == "" {
+		add("Static.FTP.keys_volume", "must not be empty")
+	}
+	names := make(map[string]bool)
+	for i, account := range f.Accounts {
+		key := fmt.Sprintf("Static.FTP.Accounts[%d]", i)
+		if !accountName.MatchString(account.Name) {
+			add(key+".name", "must be a lowercase unix user name, got %q", account.Name)
+		} else if account.Name == "root" {
+			add(key+".name", "must not be root")
+		} else if names[account.Name] {
+			add(key+".name", "account %q is defined twice", account.Name)
+		}
+		names[account.Name] = true
+		if account.PasswordRef == "" && len(account.AuthorizedKeys) == 0 {
+			add(key, "needs a password_ref, authorized_keys or both")
+		}
+		if account.Directory != "" && !filepath.IsLocal(account.Directory) {
+			add(key+".directory", "must be relative to Static.static_path and stay inside it, got %q", account.Directory)
+		}
+	}
+	return problems
+}
+
+func checkDirectory(path string) string {
+	if path == "" {
+		return "must not be empty"
+	}
+	if !filepath.IsAbs(path) {
+		return fmt.Sprintf("must be an absolute path, got %q", path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Sprintf("directory %s does not exist on this host", path)
+	}
+	if !info.IsDir() {
+		return fmt.Sprintf("%s is not a directory", path)
+	}
+	return ""
+}
+
+func checkFile(path string) string {
+	if path == "" {
+		return "must not be empty"
+	}
+	if !filepath.IsAbs(path) {
+		return fmt.Sprintf("must be an absolute path, got %q", path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Sprintf("file %s does not exist on this host", path)
+	}
+	if info.IsDir() {
+		return fmt.Sprintf("%s is a directory", path)
+	}
+	return ""
+}
+
+// every key set by a config file that is not a known leaf is a typo or a leftover from an older version
+func unknownKeys(provenance Provenance) []Problem {
+	known := knownKeys()
+	var problems []Problem
+	for _, key := range sortedKeys(provenance) {
+		if !known.has(key) {
+			problems = append(problems, Problem{Key: key, Message: fmt.Sprintf("unknown key in %s", provenance[key])})
+		}
+	}
+	return problems
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,270 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	ruleFile := filepath.Join(dir, "rules.yml")
+	if err := os.WriteFile(ruleFile, []byte("groups: []\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	otherRuleFile := filepath.Join(dir, "other", "rules.yml")
+	if err := os.MkdirAll(filepath.Dir(otherRuleFile), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(otherRuleFile, []byte("groups: []\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		modify     func(c *Root)
+		provenance Provenance
+		// keys of the expected problems, in order. Empty means valid
+		want []string
+	}{
+		{
+			name:   "defaults",
+			modify: func(c *Root) {},
+		},
+		{
+			name: "ports",
+			modify: func(c *Root) {
+				c.Kuma.Port = "http"
+				c.Static.Port = "70000"
+				c.Dragonfly.Port = c.Postgres.Primary.Port
+				c.Postgres.Bouncer.Port = ""
+				// optional
+				c.Postgres.Replica.Port = ""
+			},
+			want: []string{"Postgres.Bouncer.port", "Static.port", "Kuma.port", "Dragonfly.port"},
+		},
+		{
+			name: "required values and paths",
+			modify: func(c *Root) {
+				c.Networks.LokiNetworkName = ""
+				c.Onepass.VaultName = ""
+				c.Static.StaticPath = "srv/static"
+				c.Observer.Binds.Loki = filepath.Join(dir, "missing")
+				c.Observer.ConfigVolumes.Grafana = ""
+			},
+			want: []string{
+				"Networks.loki_network_name", "Onepass.vault_name", "Static.static_path",
+				"Observer.Binds.loki", "Observer.ConfigVolumes.grafana",
+			},
+		},
+		{
+			name: "config volume may be empty when bound",
+			modify: func(c *Root) {
+				c.Observer.Binds.Grafana = dir
+				c.Observer.ConfigVolumes.Grafana = ""
+			},
+		},
+		{
+			name: "log shipping needs loki",
+			modify: func(c *Root) {
+				c.Observer.Enabled.Loki = false
+				c.Observer.Logs.Shipping = "collector"
+			},
+			want: []string{"Observer.Logs.shipping"},
+		},
+		{
+			name: "durations and sizes",
+			modify: func(c *Root) {
+				c.Onepass.Cache.TTL = "forever"
+				c.Observer.Prometheus.ScrapeInterval = "15"
+				c.Observer.Prometheus.RetentionTime = "15 days"
+				c.Observer.Prometheus.RetentionSize = "10G"
+			},
+			want: []string{
+				"Onepass.Cache.ttl", "Observer.Prometheus.scrape_interval",
+				"Observer.Prometheus.retention_time", "Observer.Prometheus.retention_size",
+			},
+		},
+		{
+			name: "rule files",
+			modify: func(c *Root) {
+				c.Observer.Prometheus.RuleFiles = []string{ruleFile, otherRuleFile, "relative.yml", dir}
+			},
+			want: []string{
+				"Observer.Prometheus.rule_files[1]", "Observer.Prometheus.rule_files[2]", "Observer.Prometheus.rule_files[3]",
+			},
+		},
+		{
+			name: "extra scrape jobs",
+			modify: func(c *Root) {
+				c.Observer.Prometheus.ExtraJobs = []ScrapeJobConfig{
+					{Name: "app", Targets: []string{"app:9000"}},
+					{Name: "app", ScrapeInterval: "often"},
+				}
+			},
+			want: []string{
+				"Observer.Prometheus.ExtraJobs[1].name", "Observer.Prometheus.ExtraJobs[1].targets",
+				"Observer.Prometheus.ExtraJobs[1].scrape_interval",
+			},
+		},
+		{
+			name: "playground",
+			modify: func(c *Root) {
+				c.Playground.Backend.KeepImages = 0
+				c.Playground.Backend.Healthcheck = []string{"nc -z 127.0.0.1 6767"}
+			},
+			want: []string{"Playground.Backend.keep_images", "Playground.Backend.healthcheck"},
+		},
+		{
+			name: "kuma monitors",
+			modify: func(c *Root) {
+				c.Kuma.Monitors = []KumaMonitorConfig{
+					{Name: "site", Type: "keyword", URL: "ftp://cansu.dev"},
+					{Name: "site", Type: "port", Hostname: "db", Port: 5432, Interval: 5},
+					{Name: "pg", Type: "docker", Container: "pg"},
+					{Name: "other", Type: "ping"},
+				}
+			},
+			want: []string{
+				"Kuma.Monitors[0].url", "Kuma.Monitors[0].keyword", "Kuma.Monitors[1].name", "Kuma.Monitors[1].interval",
+				"Kuma.Monitors[2].type", "Kuma.Monitors[3].type",
+			},
+		},
+		{
+			name: "kuma docker daemon",
+			modify: func(c *Root) {
+				c.Kuma.DockerDaemon = "/var/run/docker.sock"
+			},
+			want: []string{"Kuma.docker_daemon"},
+		},
+		{
+			name: "kuma docker monitor with a daemon",
+			modify: func(c *Root) {
+				c.Kuma.DockerDaemon = "tcp://docker-socket-proxy:2375"
+				c.Kuma.Monitors = []KumaMonitorConfig{{Name: "pg", Type: "docker", Container: "pg"}}
+			},
+		},
+		{
+			name:       "unknown keys",
+			modify:     func(c *Root) {},
+			provenance: Provenance{"Kuma.port": "/etc/oblivion.toml", "Kuma.prot": "/etc/oblivion.toml"},
+			want:       []string{"Kuma.prot"},
+		},
+		{
+			name:   "unknown keys in arrays of tables",
+			modify: func(c *Root) {},
+			provenance: Provenance{
+				"Kuma.Monitors":                             "/etc/oblivion.toml",
+				"Kuma.Monitors[0].name":                     "/etc/oblivion.toml",
+				"Kuma.Monitors[1].nme":                      "/etc/oblivion.toml",
+				"Static.FTP.Accounts[0].authorized_keys":    "/etc/oblivion.toml",
+				"Static.FTP.Accounts[0].password":           "/etc/oblivion.toml",
+				"Static.Nginx.Protected[0].Users[2].name":   "/etc/oblivion.toml",
+				"Static.Nginx.Protected[0].Users[2].secret": "/etc/oblivion.toml",
+			},
+			want: []string{"Kuma.Monitors[1].nme", "Static.FTP.Accounts[0].password", "Static.Nginx.Protected[0].Users[2].secret"},
+		},
+		{
+			name: "newer config version",
+			modify: func(c *Root) {
+				c.Version = CurrentVersion + 1
+			},
+			want: []string{"version"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var root Root
+			root.SetDefaults()
+			tt.modify(&root)
+			err := root.Validate(tt.provenance)
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("expected a valid config, got %v", err)
+				}
+				return
+			}
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("expected a *ValidationError, got %v", err)
+			}
+			var got []string
+			for _, p := range validationErr.Problems {
+				got = append(got, p.Key)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("problems at %v, want %v\n%v", got, tt.want, err)
+			}
+		})
+	}
+}
+
+func TestValidationErrorListsEveryProblem(t *testing.T) {
+	err := &ValidationError{Problems: []Problem{
+		{Key: "Kuma.port", Message: "port must not be empty"},
+		{Key: "Static.port", Message: "port must be numeric, got \"x\""},
+	}}
+	want := "2 problem(s) found in config\n  Kuma.port: port must not be empty\n  Static.port: port must be numeric, got \"x\""
+	if got := err.Error(); got != want {
+		t.Fatalf("Error() = %q, want %q", got, want)
+	}
+	if !strings.HasPrefix(err.Problems[0].String(), "Kuma.port: ") {
+		t.Fatalf("Problem.String() = %q", err.Problems[0].String())
+	}
+}
+
+func TestValidationErrorWithin(t *testing.T) {
+	err := &ValidationError{Problems: []Problem{
+		{Key: "version"},
+		{Key: "Static.port"},
+		{Key: "Static.Nginx.Cache[0].extensions"},
+		{Key: "StaticExtra.port"},
+		{Key: "Kuma.Monitors[1].name"},
+		{Key: "Observer.Logs.shipping"},
+		{Key: "Observer.Ports.grafana"},
+	}}
+	tests := []struct {
+		name   string
+		keys   []string
+		within []string
+		rest   []string
+	}{
+		{
+			name:   "sections",
+			keys:   []string{"Static", "Observer.Logs"},
+			within: []string{"version", "Static.port", "Static.Nginx.Cache[0].extensions", "Observer.Logs.shipping"},
+			rest:   []string{"StaticExtra.port", "Kuma.Monitors[1].name", "Observer.Ports.grafana"},
+		},
+		{
+			name:   "array of tables",
+			keys:   []string{"Kuma.Monitors"},
+			within: []string{"version", "Kuma.Monitors[1].name"},
+			rest:   []string{"Static.port", "Static.Nginx.Cache[0].extensions", "StaticExtra.port", "Observer.Logs.shipping", "Observer.Ports.grafana"},
+		},
+		{
+			name:   "nothing",
+			within: []string{"version"},
+			rest:   []string{"Static.port", "Static.Nginx.Cache[0].extensions", "StaticExtra.port", "Kuma.Monitors[1].name", "Observer.Logs.shipping", "Observer.Ports.grafana"},
+		},
+	}
+	keys := func(problems []Problem) []string {
+		var keys []string
+		for _, p := range problems {
+			keys = append(keys, p.Key)
+		}
+		return keys
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			within, rest := err.Within(tt.keys)
+			if got := keys(within); !slices.Equal(got, tt.within) {
+				t.Errorf("within = %v, want %v", got, tt.within)
+			}
+			if got := keys(rest); !slices.Equal(got, tt.rest) {
+				t.Errorf("rest = %v, want %v", got, tt.rest)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LineDiff returns a unified-style diff of two texts, "-" marks lines only in old, "+" lines only in new.
+// Unchanged lines further than context lines away from a change are collapsed. Empty output means no change.
+func LineDiff(old string, new string, context int) string {
+	a := strings.Split(strings.TrimSuffix(old, "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(new, "\n"), "\n")
+	// longest common subsequence table, lcs[i][j] is the lcs length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	type line struct {
+		op   byte
+		text string
+	}
+	lines := make([]line, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, line{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, line{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, line{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, line{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, line{'+', b[j]})
+	}
+
+	changed := false
+	keep := make([]bool, len(lines))
+	for idx, l := range lines {
+		if l.op == ' ' {
+			continue
+		}
+		changed = true
+		for k := max(0, idx-context); k <= min(len(lines)-1, idx+context); k++ {
+			keep[k] = true
+		}
+	}
+	if !changed {
+		return ""
+	}
+	var out strings.Builder
+	skipped := false
+	for idx, l := range lines {
+		if !keep[idx] {
+			skipped = true
+			continue
+		}
+		if skipped {
+			out.WriteString("@@\n")
+			skipped = false
+		}
+		fmt.Fprintf(&out, "%c %s\n", l.op, l.text)
+	}
+	return out.String()
+}
@@ -0,0 +1,61 @@
+package internal
+
+import "testing"
+
+func TestLineDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		old     string
+		new     string
+		context int
+		want    string
+	}{
+		{
+			name: "identical",
+			old:  "a\nb\n",
+			new:  "a\nb\n",
+			want: "",
+		},
+		{
+			name: "trailing newline is ignored",
+			old:  "a\nb",
+			new:  "a\nb\n",
+			want: "",
+		},
+		{
+			name:    "changed line",
+			old:     "a\nb\nc\n",
+			new:     "a\nB\nc\n",
+			context: 1,
+			want:    "  a\n- b\n+ B\n  c\n",
+		},
+		{
+			name:    "added and removed lines",
+			old:     "a\nb\n",
+			new:     "b\nc\n",
+			context: 0,
+			want:    "- a\n@@\n+ c\n",
+		},
+		{
+			name:    "far away lines are collapsed",
+			old:     "1\n2\n3\n4\n5\n6\n7\n",
+			new:     "1\n2\n3\n4\n5\n6\nseven\n",
+			context: 1,
+			want:    "@@\n  6\n- 7\n+ seven\n",
+		},
+		{
+			name:    "separate hunks",
+			old:     "1\n2\n3\n4\n5\n6\n7\n",
+			new:     "one\n2\n3\n4\n5\n6\nseven\n",
+			context: 1,
+			want:    "- 1\n+ one\n  2\n@@\n  6\n- 7\n+ seven\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := LineDiff(tt.old, tt.new, tt.context); got != tt.want {
+				t.Fatalf("LineDiff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
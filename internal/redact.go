@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const RedactedPlaceholder = "[REDACTED]"
+
+// secrets shorter than this are not registered, otherwise
+// redacting them would shred unrelated log lines beyond recognition
+const minRedactableLength = 4
+
+var redactor = struct {
+	mu       sync.RWMutex
+	secrets  map[string]struct{}
+	replacer *strings.Replacer
+}{
+	secrets: make(map[string]struct{}),
+}
+
+// RegisterSecret marks the value as sensitive so that every later call to Redact,
+// RedactError or a writer returned from NewRedactWriter replaces it with RedactedPlaceholder.
+// Empty and very short values are ignored.
+func RegisterSecret(secret string) {
+	if len(secret) < minRedactableLength {
+		return
+	}
+	redactor.mu.Lock()
+	defer redactor.mu.Unlock()
+	if _, ok := redactor.secrets[secret]; ok {
+		return
+	}
+	for _, variant := range jsonEscaped(secret) {
+		redactor.secrets[variant] = struct{}{}
+	}
+	// longest first so a secret containing another secret is not half-replaced
+	sorted := make([]string, 0, len(redactor.secrets))
+	for s := range redactor.secrets {
+		sorted = append(sorted, s)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	pairs := make([]string, 0, len(sorted)*2)
+	for _, s := range sorted {
+		pairs = append(pairs, s, RedactedPlaceholder)
+	}
+	redactor.replacer = strings.NewReplacer(pairs...)
+}
+
+// jsonEscaped returns the secret as it appears inside JSON strings, the logger writes JSON and escapes quotes,
+// backslashes and control characters before the output reaches the redact writer. encoding/json also escapes
+// <, > and & by default, both spellings are returned.
+func jsonEscaped(secret string) []string {
+	variants := []string{secret}
+	for _, escapeHTML := range []bool{false, true} {
+		var b bytes.Buffer
+		enc := json.NewEncoder(&b)
+		enc.SetEscapeHTML(escapeHTML)
+		// strings always encode
+		_ = enc.Encode(secret)
+		// drop the quotes and the newline Encode appends
+		escaped := strings.TrimSuffix(b.String(), "\n")
+		escaped = escaped[1 : len(escaped)-1]
+		if escaped != secret && !slices.Contains(variants, escaped) {
+			variants = append(variants, escaped)
+		}
+	}
+	return variants
+}
+
+// Redact replaces every registered secret in the input with RedactedPlaceholder.
+func Redact(input string) string {
+	redactor.mu.RLock()
+	replacer := redactor.replacer
+	redactor.mu.RUnlock()
+	if replacer == nil {
+		return input
+	}
+	return replacer.Replace(input)
+}
+
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
+
+// RedactError returns an error with the same chain as err but with registered
+// secrets scrubbed from its message. nil stays nil.
+func RedactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	redacted := Redact(msg)
+	if redacted == msg {
+		return err
+	}
+	return &redactedError{msg: redacted, err: err}
+}
+
+type redactWriter struct {
+	w io.Writer
+}
+
+// NewRedactWriter wraps w so that registered secrets are scrubbed from everything written through it.
+// Secrets split across two Write calls are not detected, writers should be fed whole lines or messages.
+func NewRedactWriter(w io.Writer) io.Writer {
+	return &redactWriter{w: w}
+}
+
+func (r *redactWriter) Write(p []byte) (int, error) {
+	redacted := Redact(string(p))
+	if _, err := io.WriteString(r.w, redacted); err != nil {
+		return 0, fmt.Errorf("failed to write redacted output: %w", err)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestRedactJSONEscaped(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+	}{
+		{name: "plain", secret: "hunter2hunter2"},
+		{name: "quote", secret: `pa"ss"word`},
+		{name: "backslash", secret: `C:\secret\path`},
+		{name: "control characters", secret: "line\none\ttab\x01"},
+		{name: "html characters", secret: "<a&b>secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			RegisterSecret(tt.secret)
+			var out bytes.Buffer
+			logger := zerolog.New(NewRedactWriter(&out))
+			logger.Error().Str("password", tt.secret).Msg("login failed with " + tt.secret)
+			if strings.Count(out.String(), RedactedPlaceholder) != 2 {
+				t.Fatalf("secret not redacted from %s", out.String())
+			}
+			if got := Redact("raw " + tt.secret); got != "raw "+RedactedPlaceholder {
+				t.Fatalf("Redact(raw) = %q", got)
+			}
+		})
+	}
+}
+
+func TestRegisterSecretIgnoresShortValues(t *testing.T) {
+	RegisterSecret("abc")
+	if got := Redact("abcdef"); got != "abcdef" {
+		t.Fatalf("short secret was redacted: %q", got)
+	}
+}
@@ -0,0 +1,228 @@
+package secretcache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	KeySourceMachine    = "machine"
+	KeySourcePassphrase = "passphrase"
+
+	saltSize = 16
+	keySize  = 32
+)
+
+// Entry is a single resolved secret and the time it was fetched from 1Password.
+type Entry struct {
+	Secret     string    `json:"secret"`
+	ResolvedAt time.Time `json:"resolved_at"`
+}
+
+// on-disk layout, everything except the salt and nonce is encrypted with AES-256-GCM
+type envelope struct {
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Cache is an encrypted file mapping fully qualified secret references (op://Vault/...) to their values.
+// Entries older than the TTL are treated as missing and dropped on the next Save.
+type Cache struct {
+	mu      sync.Mutex
+	path    string
+	ttl     time.Duration
+	secret  []byte
+	salt    []byte
+	entries map[string]Entry
+}
+
+// DefaultPath returns $XDG_CACHE_HOME/oblivion/secrets.cache or the platform equivalent.
+func DefaultPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find user cache directory: %w", err)
+	}
+	return filepath.Join(dir, "oblivion", "secrets.cache"), nil
+}
+
+// Open reads the cache at path, decrypting it with a key derived from keyMaterial.
+// A missing file yields an empty cache. A file that cannot be decrypted (e.g. the passphrase changed)
+// is returned as an empty cache alongside the error, so callers can warn and carry on.
+func Open(path string, ttl time.Duration, keyMaterial []byte) (*Cache, error) {
+	c := &Cache{
+		path:    path,
+		ttl:     ttl,
+		secret:  keyMaterial,
+		entries: make(map[string]Entry),
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return c, nil
+		}
+		return c, fmt.Errorf("failed to read secret cache %s: %w", path, err)
+	}
+	var env envelope
+	if err := json.Unmarshal(contents, &env); err != nil {
+		return c, fmt.Errorf("secret cache %s is corrupted: %w", path, err)
+	}
+	gcm, err := newGCM(keyMaterial, env.Salt)
+	if err != nil {
+		return c, err
+	}
+	plaintext, err := gcm.Open(nil, env.Nonce, env.Ciphertext, nil)
+	if err != nil {
+		return c, fmt.Errorf("failed to decrypt secret cache %s, wrong passphrase or machine key: %w", path, err)
+	}
+	var entries map[string]Entry
+	if err := json.Unmarshal(plaintext, &entries); err != nil {
+		return c, fmt.Errorf("failed to decode secret cache %s: %w", path, err)
+	}
+	c.salt = env.Salt
+	c.entries = entries
+	return c, nil
+}
+
+// Get returns the cached value for a reference if it exists and is younger than the TTL.
+func (c *Cache) Get(ref string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[ref]
+	if !ok || c.expired(entry) {
+		return "", false
+	}
+	return entry.Secret, true
+}
+
+// Put stores a freshly resolved value. Call Save to persist it.
+func (c *Cache) Put(ref string, secret string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[ref] = Entry{Secret: secret, ResolvedAt: time.Now()}
+}
+
+// Save prunes expired entries and atomically rewrites the cache file with mode 0600.
+func (c *Cache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for ref, entry := range c.entries {
+		if c.expired(entry) {
+			delete(c.entries, ref)
+		}
+	}
+	plaintext, err := json.Marshal(c.entries)
+	if err != nil {
+		return fmt.Errorf("failed to encode secret cache: %w", err)
+	}
+	if c.salt == nil {
+		c.salt = make([]byte, saltSize)
+		if _, err := rand.Read(c.salt); err != nil {
+			return fmt.Errorf("failed to generate salt: %w", err)
+		}
+	}
+	gcm, err := newGCM(c.secret, c.salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	contents, err := json.Marshal(envelope{
+		Salt:       c.salt,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plaintext, nil),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode secret cache envelope: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return fmt.Errorf("failed to create secret cache directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), ".secrets-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary secret cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to restrict secret cache permissions: %w", err)
+	}
+	if _, err := tmp.Write(contents); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write secret cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close secret cache: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return fmt.Errorf("failed to move secret cache into place: %w", err)
+	}
+	return nil
+}
+
+func (c *Cache) expired(entry Entry) bool {
+	return c.ttl > 0 && time.Since(entry.ResolvedAt) > c.ttl
+}
+
+// Clear removes the cache file at path. A missing file is not an error.
+func Clear(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove secret cache %s: %w", path, err)
+	}
+	return nil
+}
+
+// MachineKey returns key material bound to this machine and user.
+// It is not a secret by itself, it only prevents a copied cache file from being readable elsewhere.
+func MachineKey() ([]byte, error) {
+	var parts []string
+	for _, p := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+		if id, err := os.ReadFile(p); err == nil {
+			parts = append(parts, strings.TrimSpace(string(id)))
+			break
+		}
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hostname: %w", err)
+	}
+	current, err := user.Current()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current user: %w", err)
+	}
+	parts = append(parts, hostname, current.Uid, current.HomeDir, runtime.GOOS)
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return sum[:], nil
+}
+
+func newGCM(keyMaterial []byte, salt []byte) (cipher.AEAD, error) {
+	if len(salt) != saltSize {
+		return nil, fmt.Errorf("invalid secret cache salt length %d", len(salt))
+	}
+	key := argon2.IDKey(keyMaterial, salt, 1, 64*1024, 4, keySize)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gcm: %w", err)
+	}
+	return gcm, nil
+}
@@ -0,0 +1,160 @@
+package secretcache
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCacheRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "oblivion", "secrets.cache")
+	key := []byte("correct horse battery staple")
+	c, err := Open(path, time.Hour, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Put("op://Server/Postgres/password", "hunter2")
+	c.Put("op://Server/Redis/password", "swordfish")
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"hunter2", "swordfish"} {
+		if bytes.Contains(contents, []byte(secret)) {
+			t.Errorf("cache file holds %q in plain text", secret)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		key     []byte
+		wantErr bool
+		want    map[string]string
+	}{
+		{
+			name: "same key",
+			key:  key,
+			want: map[string]string{"op://Server/Postgres/password": "hunter2", "op://Server/Redis/password": "swordfish"},
+		},
+		{
+			name:    "wrong key",
+			key:     []byte("wrong"),
+			wantErr: true,
+			want:    map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reopened, err := Open(path, time.Hour, tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Open() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if reopened == nil {
+				t.Fatal("Open() returned no cache")
+			}
+			if len(reopened.entries) != len(tt.want) {
+				t.Errorf("%d entries, want %d", len(reopened.entries), len(tt.want))
+			}
+			for ref, want := range tt.want {
+				if got, ok := reopened.Get(ref); !ok || got != want {
+					t.Errorf("Get(%q) = %q, %v, want %q", ref, got, ok, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheMissingFile(t *testing.T) {
+	c, err := Open(filepath.Join(t.TempDir(), "secrets.cache"), time.Hour, []byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get("op://Server/Postgres/password"); ok {
+		t.Error("missing cache file has entries")
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	tests := []struct {
+		name  string
+		ttl   time.Duration
+		age   time.Duration
+		fresh bool
+	}{
+		{name: "fresh", ttl: time.Hour, age: time.Minute, fresh: true},
+		{name: "expired", ttl: time.Hour, age: 2 * time.Hour, fresh: false},
+		{name: "no ttl keeps everything", ttl: 0, age: 24 * time.Hour, fresh: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "secrets.cache")
+			key := []byte("key")
+			c, err := Open(path, tt.ttl, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			const ref = "op://Server/Postgres/password"
+			c.entries[ref] = Entry{Secret: "hunter2", ResolvedAt: time.Now().Add(-tt.age)}
+			if _, ok := c.Get(ref); ok != tt.fresh {
+				t.Errorf("Get() found = %v, want %v", ok, tt.fresh)
+			}
+			if err := c.Save(); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := c.entries[ref]; ok != tt.fresh {
+				t.Errorf("entry kept by Save = %v, want %v", ok, tt.fresh)
+			}
+			reopened, err := Open(path, 0, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := reopened.entries[ref]; ok != tt.fresh {
+				t.Errorf("entry on disk = %v, want %v", ok, tt.fresh)
+			}
+		})
+	}
+}
+
+func TestCacheFileMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("windows has no unix permissions")
+	}
+	path := filepath.Join(t.TempDir(), "secrets.cache")
+	c, err := Open(path, time.Hour, []byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Put("op://Server/Postgres/password", "hunter2")
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("cache file mode = %o, want 600", mode)
+	}
+}
+
+func TestClear(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets.cache")
+	if err := os.WriteFile(path, []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		// the second call finds no file
+		if err := Clear(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("cache file still exists: %v", err)
+	}
+}